@@ -13,9 +13,19 @@ import (
 	"github.com/nvj9singhnavjot/media-docker/validator"
 )
 
+// audioOptions holds the processing options of an audio, shared by upload and reprocess requests.
+type audioOptions struct {
+	Bitrate           *string                 `json:"bitrate" validate:"omitempty,oneof=32k 48k 64k 96k 128k 160k 192k 256k 320k"` // Optional quality parameter
+	Waveform          *topics.AudioWaveform   `json:"waveform" validate:"omitempty"`                                               // Optional resolution of the waveform peaks
+	Outputs           []topics.AudioOutput    `json:"outputs" validate:"omitempty,max=8,unique,dive"`                              // Optional additional formats and bitrates
+	HLS               *topics.AudioHLS        `json:"hls" validate:"omitempty"`                                                    // Optional HLS audio renditions
+	NormalizeLoudness *topics.LoudnessOptions `json:"normalizeLoudness" validate:"omitempty"`                                      // Optional loudness normalization, an empty object uses the defaults
+	Tags              *topics.AudioTags       `json:"tags" validate:"omitempty"`                                                   // Optional tags replacing the tags of the upload
+}
+
 type audioRequest struct {
-	UuidFilename string  `json:"uuidFilename" validate:"required,uuid4"`
-	Bitrate      *string `json:"bitrate" validate:"omitempty,oneof=128k 192k 256k 320k"` // Optional quality parameter
+	UuidFilename string `json:"uuidFilename" validate:"required,customUuidFilename"`
+	audioOptions
 }
 
 // Audio handles audio file upload requests and sends processing messages to Kafka.
@@ -41,24 +51,66 @@ func Audio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := uuid.New().String()                                                        // Generate a new UUID for the audio file
-	outputPath := fmt.Sprintf("%s/audios/%s.mp3", helper.Constants.MediaStorage, id) // Define the output path for the audio file
-
-	// Create the AudioMessage struct
-	message := topics.AudioMessage{
-		FilePath: path,        // Set the file path
-		NewId:    id,          // Set the new ID for the file URL
-		Bitrate:  req.Bitrate, // Set the bitrate if provided in the request
+	// Hand off the upload through the storage, so consumers which do not share the disk of the server can fetch it
+	upload, err := pkg.HandOffUpload(config.ServerEnv.STORAGE, path)
+	if err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error handing off upload", err)
+		return
 	}
 
-	// Pass the struct to the Kafka producer
+	id := uuid.New().String() // Generate a new UUID for the audio file
+
+	// Pass the AudioMessage struct to the Kafka producer
+	message := req.message(path, id, nil)
+	message.Upload = upload // Set the key of the handed off upload (empty for a local storage)
 	if err := kafkahandler.KafkaProducer.Produce("audio", message); err != nil {
-		pkg.AddToFileDeleteChan(path) // Add to deletion channel on error
+		discardUpload(path, upload) // Remove the upload on error
 		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error sending Kafka message", err)
 		return
 	}
 
 	// Respond with success, providing the audio URL
-	audioUrl := fmt.Sprintf("%s/%s", config.ServerEnv.BASE_URL, outputPath) // Construct the audio file URL
-	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "audio uploaded and processed successfully", map[string]any{"id": id, "fileUrl": audioUrl})
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "audio uploaded and processed successfully", audioResponse(id, req.audioOptions))
+}
+
+// message returns the AudioMessage of a job converting the audio at path, reprocess is nil for uploads.
+func (o audioOptions) message(path, id string, reprocess *topics.Reprocess) topics.AudioMessage {
+	return topics.AudioMessage{
+		FilePath:          path,                // Set the file path
+		NewId:             id,                  // Set the new ID for the file URL
+		Bitrate:           o.Bitrate,           // Set the bitrate if provided in the request
+		Waveform:          o.Waveform,          // Set the waveform resolution if provided in the request
+		Outputs:           o.Outputs,           // Set the additional outputs if provided in the request
+		HLS:               o.HLS,               // Set the HLS renditions if provided in the request
+		NormalizeLoudness: o.NormalizeLoudness, // Set the loudness normalization if provided in the request
+		Tags:              o.Tags,              // Set the tag overrides if provided in the request
+		Reprocess:         reprocess,           // Set for reprocess jobs (nil for uploads)
+	}
+}
+
+// audioResponse returns the response data of an audio job: its id, audio URL, waveform URL and optional URLs.
+func audioResponse(id string, options audioOptions) map[string]any {
+	outputPath := fmt.Sprintf("%s/audios/%s.mp3", helper.Constants.MediaStorage, id)                              // Define the output path for the audio file
+	audioUrl := fileUrl(outputPath, "")                                                                           // Construct the audio file URL
+	waveformUrl := fileUrl(pkg.MediaDir(helper.Constants.MediaStorage, "audio", id)+"/"+pkg.WaveformFileName, "") // Construct the waveform peaks URL
+	data := map[string]any{"id": id, "fileUrl": audioUrl, "waveformUrl": waveformUrl}
+
+	// Provide the URLs of the additional outputs by format and bitrate, and of the HLS master playlist
+	mediaDir := pkg.MediaDir(helper.Constants.MediaStorage, "audio", id)
+	if len(options.Outputs) > 0 {
+		fileUrls := map[string]map[string]string{}
+		for _, output := range pkg.AudioOutputsFromMessage(options.Outputs) {
+			if fileUrls[output.Format] == nil {
+				fileUrls[output.Format] = map[string]string{}
+			}
+			fileUrls[output.Format][output.Bitrate] = fileUrl(mediaDir+"/"+output.FileName(), "")
+		}
+		data["fileUrls"] = fileUrls
+	}
+	if options.HLS != nil {
+		hlsDir := mediaDir + "/" + pkg.AudioHLSDir
+		data["hlsUrl"] = fileUrl(mediaDir+"/"+pkg.AudioHLSPlaylist(), hlsDir+"/") // Signed for all renditions
+	}
+
+	return data
 }
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/kafkahandler"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
+	"github.com/nvj9singhnavjot/media-docker/topics"
+	"github.com/nvj9singhnavjot/media-docker/validator"
+)
+
+// captionRequest represents the structure of the request for caption upload.
+type captionRequest struct {
+	UuidFilename string `json:"uuidFilename" validate:"required,customUuidFilename"`
+	VideoId      string `json:"videoId" validate:"required,uuid4"`               // Id of the processed video the caption belongs to
+	Language     string `json:"language" validate:"required,bcp47_language_tag"` // BCP 47 language tag, e.g. "en" or "pt-BR"
+	Name         string `json:"name" validate:"required,max=64"`                 // Name of the track shown by players, e.g. "English"
+	Default      bool   `json:"default"`                                         // Track selected by players without a language preference
+}
+
+// Caption handles caption upload requests for a processed video and sends processing messages to Kafka.
+func Caption(w http.ResponseWriter, r *http.Request) {
+
+	var req captionRequest
+	// Parse the JSON request and populate the captionRequest struct
+	if err := validator.ValidateRequest(r, &req); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+		return
+	}
+
+	path := helper.Constants.UploadStorage + "/" + req.UuidFilename
+
+	// Check if the file exists at the specified path
+	exist, err := pkg.DirOrFileExist(path)
+	if err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid uuidFilename", err)
+		return
+	}
+
+	if !exist {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "file doesn't exist", nil)
+		return
+	}
+
+	// Captions can only be added to videos which have been processed
+	if _, err := pkg.ReadStoredMetadata(config.ServerEnv.STORAGE, "video", req.VideoId); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusNotFound, "video not found", nil)
+			return
+		}
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error reading video metadata", err)
+		return
+	}
+
+	// Hand off the upload through the storage, so consumers which do not share the disk of the server can fetch it
+	upload, err := pkg.HandOffUpload(config.ServerEnv.STORAGE, path)
+	if err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error handing off upload", err)
+		return
+	}
+
+	outputPath := fmt.Sprintf("%s/videos/%s", helper.Constants.MediaStorage, req.VideoId) // Media directory of the video
+
+	// Create the CaptionMessage struct to be passed to Kafka
+	message := topics.CaptionMessage{
+		FilePath: path,         // Set the file path
+		Upload:   upload,       // Set the key of the handed off subtitle file (empty for a local storage)
+		NewId:    req.VideoId,  // Set the id of the video
+		Language: req.Language, // Set the language of the track
+		Name:     req.Name,     // Set the name of the track
+		Default:  req.Default,  // Set whether the track is the default track
+	}
+
+	// Pass the struct to the Kafka producer, keyed by the video so the caption jobs of a video are handled in order
+	if err := kafkahandler.KafkaProducer.ProduceWithKey("caption", req.VideoId, message); err != nil {
+		discardUpload(path, upload) // Remove the upload on error
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error sending Kafka message", err)
+		return
+	}
+
+	// Respond with success, providing the master playlist and caption track URLs
+	track := pkg.CaptionTrack{Language: req.Language}
+	masterUrl := fileUrl(outputPath+"/"+pkg.VideoMasterPlaylist, outputPath+"/") // Master playlist, signed for the whole video directory
+	captionUrl := fileUrl(outputPath+"/"+track.Playlist(), outputPath+"/")       // Playlist of the caption track
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "caption uploaded successfully",
+		map[string]any{"id": req.VideoId, "masterUrl": masterUrl, "captionUrl": captionUrl})
+}
@@ -1,9 +1,11 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
+	"os"
 
+	"github.com/nvj9singhnavjot/media-docker/config"
 	"github.com/nvj9singhnavjot/media-docker/helper"
 	"github.com/nvj9singhnavjot/media-docker/kafkahandler"
 	"github.com/nvj9singhnavjot/media-docker/pkg"
@@ -24,19 +26,16 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	path := fmt.Sprintf("%s/%ss/%s", helper.Constants.MediaStorage, req.Type, req.Id)
-
-	exist, err := pkg.DirOrFileExist(path)
-	if err != nil {
+	// Resolve the served file of the media, images and audios are stored with their own extension
+	if _, err := pkg.ResolveStoredMedia(config.ServerEnv.STORAGE, req.Type, req.Id); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "file doesn't exist for deleting", nil)
+			return
+		}
 		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid file for deleting", err)
 		return
 	}
 
-	if !exist {
-		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "file doesn't exist for deleting", nil)
-		return
-	}
-
 	if err := kafkahandler.KafkaProducer.Produce("delete-file", req); err != nil {
 		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error deleting file", err)
 		return
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/kafkahandler"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
+	"github.com/nvj9singhnavjot/media-docker/topics"
+	"github.com/nvj9singhnavjot/media-docker/validator"
+)
+
+// clipRequest represents the structure of the request for cutting a range of an existing video or audio.
+type clipRequest struct {
+	MediaType string             `json:"mediaType" validate:"required,oneof=video audio"` // Type of the existing media file
+	Part      topics.EditPart    `json:"part" validate:"required"`                        // Id and range of the existing media file
+	HLS       *topics.HLSOptions `json:"hls" validate:"omitempty"`                        // Optional HLS overrides for a re-encoded range of a video
+}
+
+// concatRequest represents the structure of the request for joining ranges of existing videos or audios.
+type concatRequest struct {
+	MediaType string             `json:"mediaType" validate:"required,oneof=video audio"` // Type of the existing media files
+	Parts     []topics.EditPart  `json:"parts" validate:"required,min=2,max=20,dive"`     // Ids and ranges of the existing media files, in playback order
+	HLS       *topics.HLSOptions `json:"hls" validate:"omitempty"`                        // Optional HLS overrides for re-encoded ranges of a video
+}
+
+// Clip handles requests for cutting a range of an existing video or audio into a new media file,
+// and sends processing messages to Kafka.
+// Videos are cut from their highest resolution, the new video has a single resolution.
+func Clip(w http.ResponseWriter, r *http.Request) {
+	var req clipRequest
+	// Parse the JSON request and populate the clipRequest struct
+	if err := validator.ValidateRequest(r, &req); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+		return
+	}
+
+	id := uuid.New().String() // Generate a new UUID for the clip
+	message := topics.ClipMessage{NewId: id, MediaType: req.MediaType, Part: req.Part, HLS: req.HLS}
+	produceEdit(w, r, "clip", req.MediaType, id, []topics.EditPart{req.Part}, message)
+}
+
+// Concat handles requests for joining ranges of existing videos or audios into a new media file,
+// and sends processing messages to Kafka.
+// Videos are cut from their highest resolution, the new video has a single resolution.
+func Concat(w http.ResponseWriter, r *http.Request) {
+	var req concatRequest
+	// Parse the JSON request and populate the concatRequest struct
+	if err := validator.ValidateRequest(r, &req); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+		return
+	}
+
+	id := uuid.New().String() // Generate a new UUID for the joined media file
+	message := topics.ConcatMessage{NewId: id, MediaType: req.MediaType, Parts: req.Parts, HLS: req.HLS}
+	produceEdit(w, r, "concat", req.MediaType, id, req.Parts, message)
+}
+
+// produceEdit checks that the media files of the parts exist, sends the message of a clip or concat job to Kafka
+// and responds with the URLs of the new media file.
+func produceEdit(w http.ResponseWriter, r *http.Request, topic, mediaType, id string, parts []topics.EditPart, message any) {
+	for _, part := range parts {
+		if _, err := pkg.ResolveStoredMedia(config.ServerEnv.STORAGE, mediaType, part.Id); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusNotFound, mediaType+" "+part.Id+" not found", nil)
+				return
+			}
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid "+mediaType+" "+part.Id, err)
+			return
+		}
+	}
+
+	// Pass the struct to the Kafka producer
+	if err := kafkahandler.KafkaProducer.Produce(topic, message); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error sending Kafka message", err)
+		return
+	}
+
+	// Respond with success, providing the URLs of the new media file
+	mediaDir := pkg.MediaDir(helper.Constants.MediaStorage, mediaType, id)
+	data := map[string]any{"id": id}
+	if mediaType == "video" {
+		data["fileUrl"] = fileUrl(mediaDir+"/index.m3u8", mediaDir+"/") // Signed for the whole video directory
+		data["posterUrl"] = fileUrl(mediaDir+"/"+pkg.PosterFileName, "")
+	} else {
+		data["fileUrl"] = fileUrl(fmt.Sprintf("%s/audios/%s.mp3", helper.Constants.MediaStorage, id), "")
+		data["waveformUrl"] = fileUrl(mediaDir+"/"+pkg.WaveformFileName, "")
+	}
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, topic+" job created successfully", data)
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/playback"
+)
+
+// fileUrl constructs the URL of a media file for the client, e.g. "media_docker_files/audios/<id>.mp3".
+// If signed URLs are enabled, the URL is signed with the configured SIGNED_URL_TTL.
+// A non-empty prefix (e.g. "media_docker_files/videos/<id>/") signs all files below the prefix,
+// which is required for HLS playlists, whose renditions and segments are requested by the player.
+func fileUrl(filePath, prefix string) string {
+	url := fmt.Sprintf("%s/%s", config.ServerEnv.BASE_URL, filePath)
+	if !config.ServerEnv.SIGNED_URLS {
+		return url
+	}
+
+	opts := playback.SignOptions{}
+	if prefix != "" {
+		opts.Prefix = "/" + prefix
+	}
+
+	expiresAt := time.Now().Add(time.Duration(config.ServerEnv.SIGNED_URL_TTL) * time.Second)
+	return url + "?" + playback.SignURL(config.ServerEnv.PLAYBACK_SECRET, "/"+filePath, expiresAt, opts).Encode()
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
+	"github.com/nvj9singhnavjot/media-docker/playback"
+	"github.com/nvj9singhnavjot/media-docker/validator"
+)
+
+// HLSKey releases the AES-128 key of an encrypted video to players holding a valid playback token.
+// The token is read from the "token" query parameter or from the Authorization Bearer header.
+// Requests already verified by their URL signature (see middleware.SignedURL) need no token.
+//
+// INFO: Used by media-docker-client, the key URI in #EXT-X-KEY of the playlists points to this handler.
+func HLSKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := validator.ValidateAndParseUUID(id); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid id", err)
+		return
+	}
+
+	if !playback.IsSignedRequest(r.Context()) {
+		// Retrieve the playback token from the query, or from the Authorization header
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+
+		if err := playback.ValidateToken(config.ClientEnv.PLAYBACK_SECRET, id, token); err != nil {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusForbidden, "invalid playback token", err)
+			return
+		}
+	}
+
+	// The key is read from KeyStorage for a local storage, and from the hidden keys directory of other storages
+	key, err := pkg.ReadHLSKey(config.ClientEnv.STORAGE, helper.Constants.KeyStorage, id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusNotFound, "key doesn't exist", nil)
+			return
+		}
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error reading key", err)
+		return
+	}
+
+	// Keys must never be cached by browsers or CDNs
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(key)
+}
@@ -1,9 +1,14 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/nvj9singhnavjot/media-docker/config"
 	"github.com/nvj9singhnavjot/media-docker/helper"
@@ -13,8 +18,27 @@ import (
 	"github.com/nvj9singhnavjot/media-docker/validator"
 )
 
+// imageVariantsRequest represents the optional responsive variants of an image upload.
+type imageVariantsRequest struct {
+	Widths  []int    `json:"widths" validate:"omitempty,max=10,unique,dive,min=16,max=8192"`              // Optional widths, default IMAGE_VARIANT_WIDTHS
+	Formats []string `json:"formats" validate:"omitempty,max=5,unique,dive,oneof=jpeg png webp avif gif"` // Optional formats, default the format of the image
+}
+
+// imageOptions holds the processing options of an image, shared by upload and reprocess requests.
+type imageOptions struct {
+	Format        *string               `json:"format" validate:"omitempty,oneof=jpeg png webp avif gif"` // Optional output format, defaults to the format of the uploaded image, animated gif uploads default to webp
+	Quality       *int                  `json:"quality" validate:"omitempty,min=1,max=100"`               // Optional encoder quality, 1 (lowest) to 100 (highest), ignored for png
+	MaxWidth      *int                  `json:"maxWidth" validate:"omitempty,min=1,max=8192"`             // Optional maximum width in pixels
+	MaxHeight     *int                  `json:"maxHeight" validate:"omitempty,min=1,max=8192"`            // Optional maximum height in pixels
+	Fit           *string               `json:"fit" validate:"omitempty,oneof=contain cover fill"`        // Optional fit into maxWidth x maxHeight, default contain
+	Variants      *imageVariantsRequest `json:"variants" validate:"omitempty"`                            // Optional responsive variants for srcset
+	KeepCopyright bool                  `json:"keepCopyright"`                                            // Optional, record the Copyright and Artist fields of the image in metadata.json, the served image is stripped of all metadata
+	Watermark     *string               `json:"watermark" validate:"omitempty,max=32"`                    // Optional name of a watermark profile of WATERMARK_PROFILES
+}
+
 type imageRequest struct {
-	UuidFilename string `json:"uuidFilename" validate:"required,uuid4"`
+	UuidFilename string `json:"uuidFilename" validate:"required,customUuidFilename"`
+	imageOptions
 }
 
 // Image handles image file upload requests and sends processing messages to Kafka.
@@ -26,6 +50,13 @@ func Image(w http.ResponseWriter, r *http.Request) {
 		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
 		return
 	}
+
+	// Check the options depending on each other and on the configuration
+	if errMsg := req.check(); errMsg != "" {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, errMsg, nil)
+		return
+	}
+
 	path := helper.Constants.UploadStorage + "/" + req.UuidFilename
 
 	// Check if the file exists at the specified path
@@ -40,23 +71,176 @@ func Image(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := uuid.New().String()                                                         // Generate a new UUID for the image file
-	outputPath := fmt.Sprintf("%s/images/%s.jpeg", helper.Constants.MediaStorage, id) // Define the output path for the image file
-
-	// Create the ImageMessage struct to be passed to Kafka
-	message := topics.ImageMessage{
-		FilePath: path, // Set the file path
-		NewId:    id,   // Set the new ID for the file URL
+	// Hand off the upload through the storage, so consumers which do not share the disk of the server can fetch it
+	upload, err := pkg.HandOffUpload(config.ServerEnv.STORAGE, path)
+	if err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error handing off upload", err)
+		return
 	}
 
-	// Pass the struct to the Kafka producer
+	id := uuid.New().String() // Generate a new UUID for the image file
+
+	// Pass the ImageMessage struct to the Kafka producer
+	message := req.message(path, id, nil)
+	message.Upload = upload // Set the key of the handed off upload (empty for a local storage)
 	if err := kafkahandler.KafkaProducer.Produce("image", message); err != nil {
-		pkg.AddToFileDeleteChan(path) // Add to deletion channel on error
+		discardUpload(path, upload) // Remove the upload on error
 		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error sending Kafka message", err)
 		return
 	}
 
 	// Respond with success, providing the image URL
-	imageUrl := fmt.Sprintf("%s/%s", config.ServerEnv.BASE_URL, outputPath) // Construct the image file URL
-	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "image uploaded successfully", map[string]any{"id": id, "fileUrl": imageUrl})
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "image uploaded successfully", imageResponse(message))
+}
+
+// check returns the error message of the first invalid option, or an empty string if the options are valid.
+func (o imageOptions) check() string {
+	// Cropping and stretching need both dimensions of the target box
+	if o.Fit != nil && *o.Fit != "contain" && (o.MaxWidth == nil || o.MaxHeight == nil) {
+		return "fit " + *o.Fit + " requires maxWidth and maxHeight"
+	}
+
+	// Only the configured watermark profiles can be burned into the media
+	if !watermarkExists(o.Watermark) {
+		return "unknown watermark profile " + *o.Watermark
+	}
+	return ""
+}
+
+// message returns the ImageMessage of a job converting the image at path, whose extension is the default output format.
+// Reprocess is nil for uploads.
+func (o imageOptions) message(path, id string, reprocess *topics.Reprocess) topics.ImageMessage {
+	// Keep the format of the uploaded image (e.g. png with transparency), unless a format is requested
+	sourceFormat, ok := pkg.ImageFormatFromExtension(filepath.Ext(path))
+	if !ok {
+		sourceFormat = "jpeg"
+	}
+	format := sourceFormat
+	if format == "gif" {
+		format = "webp" // Animated WebP is a fraction of the size of the GIF, which is kept as fallback
+	}
+	if o.Format != nil {
+		format = *o.Format
+	}
+
+	// Create the ImageMessage struct to be passed to Kafka
+	message := topics.ImageMessage{
+		FilePath:      path,                                                        // Set the file path
+		NewId:         id,                                                          // Set the new ID for the file URL
+		Format:        format,                                                      // Set the output format of the image
+		Quality:       o.Quality,                                                   // Set the optional encoder quality
+		MaxWidth:      o.MaxWidth,                                                  // Set the optional maximum width
+		MaxHeight:     o.MaxHeight,                                                 // Set the optional maximum height
+		Fit:           o.Fit,                                                       // Set the optional fit
+		KeepCopyright: o.KeepCopyright,                                             // Record the copyright fields of the image
+		Fallback:      format == "webp" && pkg.IsAnimatedImageFormat(sourceFormat), // Request a GIF fallback of uploads that may be animated, the consumer only writes it for animations
+		Watermark:     o.Watermark,                                                 // Set the optional watermark profile
+		Reprocess:     reprocess,                                                   // Set for reprocess jobs (nil for uploads)
+	}
+
+	// Resolve the widths and formats of the responsive variants
+	if o.Variants != nil {
+		message.Variants = &topics.ImageVariants{Widths: o.Variants.Widths, Formats: o.Variants.Formats}
+		if len(message.Variants.Widths) == 0 {
+			message.Variants.Widths = config.ServerEnv.IMAGE_VARIANT_WIDTHS
+		}
+		if len(message.Variants.Formats) == 0 {
+			message.Variants.Formats = []string{format}
+		}
+	}
+
+	return message
+}
+
+// imageResponse returns the response data of an image job: its id, image URL, and the URLs of the requested variants
+// by format and width with a ready to use srcset per format. Requested widths above the width of the upload resolve
+// to the variant of the upload width, the written widths and the fallback are resolved from the metadata
+// of the processed image, see ImageUrls.
+func imageResponse(message topics.ImageMessage) map[string]any {
+	id := message.NewId
+	imageUrl := fileUrl(fmt.Sprintf("%s/images/%s%s", helper.Constants.MediaStorage, id, pkg.ImageExtension(message.Format)), "") // Construct the image file URL
+	data := map[string]any{"id": id, "fileUrl": imageUrl}
+
+	// Provide the requested variant URLs by format and width, and a ready to use srcset per format
+	if message.Variants != nil {
+		variantUrls, srcsets := imageVariantUrls(id, pkg.ImageVariantsFromMessage(message.Variants))
+		data["variants"] = variantUrls
+		data["srcset"] = srcsets
+	}
+	return data
+}
+
+// ImageUrls handles requests for the URLs of a processed image, resolved from its metadata: the image URL,
+// the URL of the GIF fallback if one was written, and the URLs of the written responsive variants by format and width,
+// with a ready to use srcset per format.
+// The variants record the widths they were written with, variants are never wider than the upload.
+func ImageUrls(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := validator.ValidateAndParseUUID(id); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid id", err)
+		return
+	}
+
+	// The metadata is published with the outputs, an image without metadata is not processed yet
+	metadata, err := pkg.ReadStoredMetadata(config.ServerEnv.STORAGE, "image", id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusNotFound, "image "+id+" not found", nil)
+			return
+		}
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error reading image metadata", err)
+		return
+	}
+
+	imageUrl := fileUrl(fmt.Sprintf("%s/images/%s%s", helper.Constants.MediaStorage, id, metadata.Extension), "") // Construct the image file URL
+	data := map[string]any{"id": id, "fileUrl": imageUrl}
+
+	// Only animated WebP images have a fallback
+	if metadata.Fallback != "" {
+		data["fallbackUrl"] = fileUrl(pkg.MediaDir(helper.Constants.MediaStorage, "image", id)+"/"+metadata.Fallback, "")
+	}
+
+	// The camera metadata is not served with the image, it is only returned here
+	camera, err := pkg.ReadStoredCamera(config.ServerEnv.STORAGE, id)
+	if err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error reading camera metadata", err)
+		return
+	}
+	if camera != nil {
+		data["camera"] = camera
+	}
+
+	// Provide the variant URLs by format and width, and a ready to use srcset per format
+	if len(metadata.Variants) > 0 {
+		variantUrls, srcsets := imageVariantUrls(id, metadata.Variants)
+		data["variants"] = variantUrls
+		data["srcset"] = srcsets
+	}
+
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusOK, "image urls resolved successfully", data)
+}
+
+// imageVariantUrls returns the URLs of the variants of an image by format and width,
+// and a srcset attribute value per format, e.g. "<url> 320w, <url> 640w".
+func imageVariantUrls(id string, variants []pkg.ImageVariant) (map[string]map[string]string, map[string]string) {
+	mediaDir := pkg.MediaDir(helper.Constants.MediaStorage, "image", id)
+	variantUrls := map[string]map[string]string{}
+	srcsets := map[string]string{}
+
+	for _, variant := range variants {
+		url := fileUrl(mediaDir+"/"+variant.FileName(), "")
+		width := strconv.Itoa(variant.Width)
+
+		if variantUrls[variant.Format] == nil {
+			variantUrls[variant.Format] = map[string]string{}
+		}
+		variantUrls[variant.Format][width] = url
+
+		if srcsets[variant.Format] != "" {
+			srcsets[variant.Format] += ", "
+		}
+		srcsets[variant.Format] += url + " " + width + "w"
+	}
+
+	return variantUrls, srcsets
 }
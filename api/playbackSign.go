@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
+	"github.com/nvj9singhnavjot/media-docker/playback"
+	"github.com/nvj9singhnavjot/media-docker/validator"
+)
+
+// playbackSignRequest represents the structure of the request for a signed media URL.
+type playbackSignRequest struct {
+	Id        string  `json:"id" validate:"required,uuid4"`
+	Type      string  `json:"type" validate:"required,oneof=image video audio"`
+	ExpiresIn *int    `json:"expiresIn" validate:"omitempty,min=60,max=604800"` // Optional lifetime in seconds, default SIGNED_URL_TTL
+	IP        *string `json:"ip" validate:"omitempty,ip"`                       // Optional client IP the URL is bound to
+}
+
+// PlaybackSign issues a new signed URL for a media file, optionally bound to the IP of the viewer.
+//
+// For videos the signature is bound to the video directory, and the returned "query" can be appended
+// to any file of the video, e.g. the playlist of a specific resolution.
+func PlaybackSign(w http.ResponseWriter, r *http.Request) {
+	// Signed URLs are only available when a playback secret is configured
+	if config.ServerEnv.PLAYBACK_SECRET == "" {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusServiceUnavailable, "signed urls are not enabled", nil)
+		return
+	}
+
+	var req playbackSignRequest
+	// Parse the JSON request and populate the playbackSignRequest struct
+	if err := validator.ValidateRequest(r, &req); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+		return
+	}
+
+	expiresIn := config.ServerEnv.SIGNED_URL_TTL
+	if req.ExpiresIn != nil {
+		expiresIn = *req.ExpiresIn
+	}
+	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
+
+	opts := playback.SignOptions{}
+	if req.IP != nil {
+		opts.IP = *req.IP
+	}
+
+	// Determine the file path of the media, videos are signed for the whole video directory
+	var filePath string
+	switch req.Type {
+	case "video":
+		outputPath := fmt.Sprintf("%s/videos/%s", helper.Constants.MediaStorage, req.Id)
+		filePath = outputPath + "/index.m3u8"
+		opts.Prefix = "/" + outputPath + "/"
+	default:
+		// Images and audios are stored with their own extension, e.g. "<id>.png"
+		key, err := pkg.ResolveStoredMedia(config.ServerEnv.STORAGE, req.Type, req.Id)
+		if err != nil {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusNotFound, "file doesn't exist", err)
+			return
+		}
+		filePath = helper.Constants.MediaStorage + "/" + key
+	}
+
+	query := playback.SignURL(config.ServerEnv.PLAYBACK_SECRET, "/"+filePath, expiresAt, opts).Encode()
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "signed url created", map[string]any{
+		"fileUrl":   fmt.Sprintf("%s/%s?%s", config.ServerEnv.BASE_URL, filePath, query),
+		"query":     query,
+		"expiresAt": expiresAt.Unix(),
+	})
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/playback"
+	"github.com/nvj9singhnavjot/media-docker/validator"
+)
+
+// playbackTokenRequest represents the structure of the request for a playback token.
+type playbackTokenRequest struct {
+	Id        string `json:"id" validate:"required,uuid4"`
+	ExpiresIn *int   `json:"expiresIn" validate:"omitempty,min=60,max=86400"` // Optional token lifetime in seconds, default 1 hour
+}
+
+// PlaybackToken issues a playback token for a media id, which media-docker-client
+// requires before releasing the HLS encryption key of the video.
+func PlaybackToken(w http.ResponseWriter, r *http.Request) {
+	// Playback tokens are only available when a playback secret is configured
+	if config.ServerEnv.PLAYBACK_SECRET == "" {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusServiceUnavailable, "playback tokens are not enabled", nil)
+		return
+	}
+
+	var req playbackTokenRequest
+	// Parse the JSON request and populate the playbackTokenRequest struct
+	if err := validator.ValidateRequest(r, &req); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+		return
+	}
+
+	expiresIn := 3600 // Default token lifetime of 1 hour
+	if req.ExpiresIn != nil {
+		expiresIn = *req.ExpiresIn
+	}
+	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
+
+	token := playback.GenerateToken(config.ServerEnv.PLAYBACK_SECRET, req.Id, expiresAt)
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "playback token created", map[string]any{"token": token, "expiresAt": expiresAt.Unix()})
+}
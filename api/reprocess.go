@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/kafkahandler"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
+	"github.com/nvj9singhnavjot/media-docker/topics"
+	"github.com/nvj9singhnavjot/media-docker/validator"
+)
+
+// videoReprocessRequest represents the structure of the request for reprocessing a video.
+type videoReprocessRequest struct {
+	Resolutions bool `json:"resolutions"` // Optional, convert the video into the 360, 480, 720 and 1080 resolutions, like the video-resolutions uploads
+	videoOptions
+}
+
+// Reprocess handles requests for processing an existing media file again from its archived original with new options,
+// and sends processing messages to Kafka. The request body holds the options of the upload request of the media type,
+// without the uuidFilename. The outputs replace the served version of the media file once the job completes,
+// under the same URLs, except for an image reprocessed into another format: its file is then served
+// with the extension of the new format (e.g. ".webp" instead of ".jpg"), returned in the fileUrl of the response,
+// and the file with the previous extension is removed.
+//
+// INFO: Only media files whose type is kept by KEEP_ORIGINALS of the consumers have an archived original.
+func Reprocess(w http.ResponseWriter, r *http.Request) {
+	mediaType := chi.URLParam(r, "type")
+	id := chi.URLParam(r, "id")
+	if mediaType != "video" && mediaType != "image" && mediaType != "audio" {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid media type", nil)
+		return
+	}
+	if err := validator.ValidateAndParseUUID(id); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid id", err)
+		return
+	}
+
+	// Only served media files can be reprocessed, their deletion also deletes the original
+	if _, err := pkg.ResolveStoredMedia(config.ServerEnv.STORAGE, mediaType, id); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusNotFound, mediaType+" "+id+" not found", nil)
+			return
+		}
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid "+mediaType+" "+id, err)
+		return
+	}
+
+	// The consumers verify the checksum of the original, reading the metadata is enough here.
+	// Originals are read from the storage, unless it is a local storage sharing OriginalStorage with the consumers.
+	_, originalPath, err := pkg.StatOriginal(config.ServerEnv.STORAGE, helper.Constants.OriginalStorage, mediaType, id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusConflict, "no original kept for "+mediaType+" "+id, nil)
+			return
+		}
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error reading original", err)
+		return
+	}
+
+	reprocess := &topics.Reprocess{JobId: uuid.New().String(), RequestedAt: time.Now().UTC()}
+
+	// Build the message of the job and the URLs of the media file from the options of the media type
+	var topic string
+	var message any
+	var data map[string]any
+	switch mediaType {
+	case "video":
+		var req videoReprocessRequest
+		if err := validator.ValidateRequest(r, &req); err != nil {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+			return
+		}
+		if !watermarkExists(req.Watermark) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "unknown watermark profile "+*req.Watermark, nil)
+			return
+		}
+		if !encryptionAvailable(req.Encryption) {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "encryption requires PLAYBACK_SECRET", nil)
+			return
+		}
+
+		if req.Resolutions {
+			if req.Quality != nil {
+				helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "quality can not be combined with resolutions", nil)
+				return
+			}
+			topic = "video-resolutions"
+			message = req.videoResolutionsOptions.message(originalPath, id, reprocess)
+			data = videoResolutionsResponse(id, req.videoResolutionsOptions)
+		} else {
+			topic = "video"
+			message = req.message(originalPath, id, reprocess)
+			data = videoResponse(id, req.videoResolutionsOptions)
+		}
+	case "image":
+		var req imageOptions
+		if err := validator.ValidateRequest(r, &req); err != nil {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+			return
+		}
+		if errMsg := req.check(); errMsg != "" {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, errMsg, nil)
+			return
+		}
+
+		imageMessage := req.message(originalPath, id, reprocess)
+		topic = "image"
+		message = imageMessage
+		data = imageResponse(imageMessage)
+	case "audio":
+		var req audioOptions
+		if err := validator.ValidateRequest(r, &req); err != nil {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid data", err)
+			return
+		}
+
+		topic = "audio"
+		message = req.message(originalPath, id, reprocess)
+		data = audioResponse(id, req)
+	}
+
+	// Pass the struct to the Kafka producer, the original is kept on error
+	if err := kafkahandler.KafkaProducer.Produce(topic, message); err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error sending Kafka message", err)
+		return
+	}
+
+	// Respond with success, providing the job id and the URLs of the media file once the job completes
+	data["jobId"] = reprocess.JobId
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusAccepted, mediaType+" reprocess job created successfully", data)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -11,12 +10,46 @@ import (
 	"github.com/nvj9singhnavjot/media-docker/pkg"
 	"github.com/nvj9singhnavjot/media-docker/topics"
 	"github.com/nvj9singhnavjot/media-docker/validator"
+	"github.com/rs/zerolog/log"
 )
 
+// videoOptions holds the processing options of a single rendition video, shared by upload and reprocess requests.
+type videoOptions struct {
+	Quality                 *int `json:"quality" validate:"omitempty,min=40,max=100"` // Quality must be >= 40 and <= 100
+	videoResolutionsOptions      // Options shared with videos converted into several resolutions
+}
+
 // videoRequest represents the structure of the request for video upload.
 type videoRequest struct {
-	UuidFilename string `json:"uuidFilename" validate:"required,uuid4"`
-	Quality      *int   `json:"quality" validate:"omitempty,min=40,max=100"` // Quality must be >= 40 and <= 100
+	UuidFilename string `json:"uuidFilename" validate:"required,customUuidFilename"`
+	videoOptions
+}
+
+// watermarkExists reports whether the optional watermark profile of a request is configured, a nil name exists.
+func watermarkExists(name *string) bool {
+	if name == nil {
+		return true
+	}
+	_, ok := config.ServerEnv.WATERMARKS[*name]
+	return ok
+}
+
+// encryptionAvailable reports whether the optional encryption of a request can be played, a nil method can.
+// The key of an encrypted video is only released to players holding a playback token, which requires PLAYBACK_SECRET.
+func encryptionAvailable(encryption *string) bool {
+	return encryption == nil || config.ServerEnv.PLAYBACK_SECRET != ""
+}
+
+// discardUpload removes the upload of a job which could not be sent to Kafka: the file at path,
+// or the object it was handed off to if the key upload is set, see pkg.HandOffUpload.
+func discardUpload(path, upload string) {
+	if upload == "" {
+		pkg.AddToFileDeleteChan(path)
+		return
+	}
+	if err := pkg.RemoveUpload(config.ServerEnv.STORAGE, upload); err != nil {
+		log.Warn().Err(err).Msgf("Warning: Could not remove handed off upload: %s", upload)
+	}
 }
 
 // Video handles video upload requests and sends processing messages to Kafka.
@@ -29,6 +62,18 @@ func Video(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the configured watermark profiles can be burned into the media
+	if !watermarkExists(req.Watermark) {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "unknown watermark profile "+*req.Watermark, nil)
+		return
+	}
+
+	// Encrypted videos can only be played with playback tokens
+	if !encryptionAvailable(req.Encryption) {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "encryption requires PLAYBACK_SECRET", nil)
+		return
+	}
+
 	path := helper.Constants.UploadStorage + "/" + req.UuidFilename
 
 	// Check if the file exists at the specified path
@@ -43,24 +88,69 @@ func Video(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := uuid.New().String()                                                    // Generate a new UUID for the video
-	outputPath := fmt.Sprintf("%s/videos/%s", helper.Constants.MediaStorage, id) // Define the output path for the video
-
-	// Create the VideoMessage struct to be passed to Kafka
-	message := topics.VideoMessage{
-		FilePath: path,        // Set the file path
-		NewId:    id,          // Set the new ID
-		Quality:  req.Quality, // Set the optional quality (can be nil)
+	// Hand off the upload through the storage, so consumers which do not share the disk of the server can fetch it
+	upload, err := pkg.HandOffUpload(config.ServerEnv.STORAGE, path)
+	if err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error handing off upload", err)
+		return
 	}
 
-	// Pass the struct to the Kafka producer
+	id := uuid.New().String() // Generate a new UUID for the video
+
+	// Pass the VideoMessage struct to the Kafka producer
+	message := req.message(path, id, nil)
+	message.Upload = upload // Set the key of the handed off upload (empty for a local storage)
 	if err := kafkahandler.KafkaProducer.Produce("video", message); err != nil {
-		pkg.AddToFileDeleteChan(path) // Add to deletion channel on error
+		discardUpload(path, upload) // Remove the upload on error
 		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error sending Kafka message", err)
 		return
 	}
 
 	// Respond with success, providing the video URL
-	videoUrl := fmt.Sprintf("%s/%s/index.m3u8", config.ServerEnv.BASE_URL, outputPath) // Construct the video file URL
-	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "video uploaded successfully", map[string]any{"id": id, "fileUrl": videoUrl})
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "video uploaded successfully", videoResponse(id, req.videoResolutionsOptions))
+}
+
+// message returns the VideoMessage of a job converting the video at path, reprocess is nil for uploads.
+func (o videoOptions) message(path, id string, reprocess *topics.Reprocess) topics.VideoMessage {
+	return topics.VideoMessage{
+		FilePath:          path,                // Set the file path
+		NewId:             id,                  // Set the new ID
+		Quality:           o.Quality,           // Set the optional quality (can be nil)
+		HLS:               o.HLS,               // Set the optional HLS overrides (can be nil)
+		Encryption:        o.Encryption,        // Set the optional encryption method (can be nil)
+		Preview:           o.Preview,           // Set the optional preview clip (can be nil)
+		NormalizeLoudness: o.NormalizeLoudness, // Set the optional loudness normalization (can be nil)
+		AudioTracks:       o.AudioTracks,       // Set the optional audio tracks (can be nil)
+		Watermark:         o.Watermark,         // Set the optional watermark profile (can be nil)
+		Reprocess:         reprocess,           // Set for reprocess jobs (nil for uploads)
+	}
+}
+
+// videoResponse returns the response data of a single rendition video: its id, video URL, poster URL and optional URLs.
+func videoResponse(id string, options videoResolutionsOptions) map[string]any {
+	outputPath := pkg.MediaDir(helper.Constants.MediaStorage, "video", id)
+	videoUrl := fileUrl(outputPath+"/index.m3u8", outputPath+"/") // Construct the video file URL, signed for the whole video directory
+	posterUrl := fileUrl(outputPath+"/"+pkg.PosterFileName, "")   // Construct the poster image URL
+	data := map[string]any{"id": id, "fileUrl": videoUrl, "posterUrl": posterUrl}
+	addVideoAssetUrls(data, outputPath, options)
+	return data
+}
+
+// addVideoAssetUrls adds the URLs of the optional preview and master playlist of a video to the response data.
+func addVideoAssetUrls(data map[string]any, outputPath string, options videoResolutionsOptions) {
+	if options.Preview != nil {
+		data["previewUrls"] = videoPreviewUrls(outputPath, options.Preview) // Provide the preview URLs by format
+	}
+	if options.AudioTracks != nil {
+		data["masterUrl"] = fileUrl(outputPath+"/"+pkg.VideoMasterPlaylist, outputPath+"/") // Master playlist listing the audio tracks
+	}
+}
+
+// videoPreviewUrls returns the URLs of the preview of a video by format, e.g. {"mp4": "<url>"}.
+func videoPreviewUrls(outputPath string, preview *topics.VideoPreview) map[string]string {
+	urls := map[string]string{}
+	for _, format := range pkg.VideoPreviewFromMessage(preview).Formats {
+		urls[format] = fileUrl(outputPath+"/"+pkg.VideoPreviewFileName(format), "")
+	}
+	return urls
 }
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -13,8 +12,20 @@ import (
 	"github.com/nvj9singhnavjot/media-docker/validator"
 )
 
+// videoResolutionsOptions holds the processing options of a video converted into several resolutions,
+// shared by upload and reprocess requests.
+type videoResolutionsOptions struct {
+	HLS               *topics.HLSOptions      `json:"hls" validate:"omitempty"`                      // Optional HLS overrides
+	Encryption        *string                 `json:"encryption" validate:"omitempty,oneof=AES-128"` // Optional HLS segment encryption, only AES-128 is supported (no SAMPLE-AES)
+	Preview           *topics.VideoPreview    `json:"preview" validate:"omitempty"`                  // Optional preview clip, an empty object uses the defaults
+	NormalizeLoudness *topics.LoudnessOptions `json:"normalizeLoudness" validate:"omitempty"`        // Optional loudness normalization, an empty object uses the defaults
+	AudioTracks       *topics.AudioTracks     `json:"audioTracks" validate:"omitempty"`              // Optional alternate audio renditions, an empty object keeps every audio stream
+	Watermark         *string                 `json:"watermark" validate:"omitempty,max=32"`         // Optional name of a watermark profile of WATERMARK_PROFILES
+}
+
 type videoResolutionsRequest struct {
-	UuidFilename string `json:"uuidFilename" validate:"required,uuid4"`
+	UuidFilename string `json:"uuidFilename" validate:"required,customUuidFilename"`
+	videoResolutionsOptions
 }
 
 // VideoResolutions handles video file upload requests and sends processing messages to Kafka for resolution conversion.
@@ -26,6 +37,18 @@ func VideoResolutions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only the configured watermark profiles can be burned into the media
+	if !watermarkExists(req.Watermark) {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "unknown watermark profile "+*req.Watermark, nil)
+		return
+	}
+
+	// Encrypted videos can only be played with playback tokens
+	if !encryptionAvailable(req.Encryption) {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "encryption requires PLAYBACK_SECRET", nil)
+		return
+	}
+
 	path := helper.Constants.UploadStorage + "/" + req.UuidFilename
 
 	// Check if the file exists at the specified path
@@ -40,30 +63,59 @@ func VideoResolutions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := uuid.New().String() // Generate a new UUID for the video
-
-	// Create the VideoResolutionsMessage struct to be passed to Kafka
-	message := topics.VideoResolutionsMessage{
-		FilePath: path, // Set the file path
-		NewId:    id,   // Set the new ID for the file URL
+	// Hand off the upload through the storage, so consumers which do not share the disk of the server can fetch it
+	upload, err := pkg.HandOffUpload(config.ServerEnv.STORAGE, path)
+	if err != nil {
+		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error handing off upload", err)
+		return
 	}
 
-	// Pass the struct to the Kafka producer
+	id := uuid.New().String() // Generate a new UUID for the video
+
+	// Pass the VideoResolutionsMessage struct to the Kafka producer
+	message := req.message(path, id, nil)
+	message.Upload = upload // Set the key of the handed off upload (empty for a local storage)
 	if err := kafkahandler.KafkaProducer.Produce("video-resolutions", message); err != nil {
-		pkg.AddToFileDeleteChan(path) // Add to deletion channel on error
+		discardUpload(path, upload) // Remove the upload on error
 		helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error sending Kafka message", err)
 		return
 	}
 
 	// Respond with success, providing URLs for different video resolutions
-	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "video uploaded successfully",
-		map[string]any{
-			"id": id,
-			"fileUrls": map[string]string{
-				"360":  fmt.Sprintf("%s/%s/videos/%s/360/index.m3u8", config.ServerEnv.BASE_URL, helper.Constants.MediaStorage, id),
-				"480":  fmt.Sprintf("%s/%s/videos/%s/480/index.m3u8", config.ServerEnv.BASE_URL, helper.Constants.MediaStorage, id),
-				"720":  fmt.Sprintf("%s/%s/videos/%s/720/index.m3u8", config.ServerEnv.BASE_URL, helper.Constants.MediaStorage, id),
-				"1080": fmt.Sprintf("%s/%s/videos/%s/1080/index.m3u8", config.ServerEnv.BASE_URL, helper.Constants.MediaStorage, id),
-			},
-		})
+	helper.SuccessResponse(w, helper.GetRequestID(r), http.StatusCreated, "video uploaded successfully", videoResolutionsResponse(id, req.videoResolutionsOptions))
+}
+
+// message returns the VideoResolutionsMessage of a job converting the video at path, reprocess is nil for uploads.
+func (o videoResolutionsOptions) message(path, id string, reprocess *topics.Reprocess) topics.VideoResolutionsMessage {
+	return topics.VideoResolutionsMessage{
+		FilePath:          path,                // Set the file path
+		NewId:             id,                  // Set the new ID for the file URL
+		HLS:               o.HLS,               // Set the optional HLS overrides (can be nil)
+		Encryption:        o.Encryption,        // Set the optional encryption method (can be nil)
+		Preview:           o.Preview,           // Set the optional preview clip (can be nil)
+		NormalizeLoudness: o.NormalizeLoudness, // Set the optional loudness normalization (can be nil)
+		AudioTracks:       o.AudioTracks,       // Set the optional audio tracks (can be nil)
+		Watermark:         o.Watermark,         // Set the optional watermark profile (can be nil)
+		Reprocess:         reprocess,           // Set for reprocess jobs (nil for uploads)
+	}
+}
+
+// videoResolutionsResponse returns the response data of a video converted into several resolutions:
+// its id, the URLs of every resolution, the poster URL and optional URLs.
+func videoResolutionsResponse(id string, options videoResolutionsOptions) map[string]any {
+	// All resolutions are signed for the whole video directory
+	outputPath := pkg.MediaDir(helper.Constants.MediaStorage, "video", id)
+
+	data := map[string]any{
+		"id": id,
+		"fileUrls": map[string]string{
+			"360":  fileUrl(outputPath+"/360/index.m3u8", outputPath+"/"),
+			"480":  fileUrl(outputPath+"/480/index.m3u8", outputPath+"/"),
+			"720":  fileUrl(outputPath+"/720/index.m3u8", outputPath+"/"),
+			"1080": fileUrl(outputPath+"/1080/index.m3u8", outputPath+"/"),
+		},
+		"posterUrl": fileUrl(outputPath+"/"+pkg.PosterFileName, ""),
+	}
+	addVideoAssetUrls(data, outputPath, options)
+	return data
 }
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/nvj9singhnavjot/media-docker/api"
 	"github.com/nvj9singhnavjot/media-docker/config"
 	"github.com/nvj9singhnavjot/media-docker/helper"
 	mw "github.com/nvj9singhnavjot/media-docker/middleware"
@@ -33,8 +35,11 @@ func main() {
 	// Set up the logger based on the current environment (development/production)
 	config.SetUpLogger(config.ClientEnv.ENVIRONMENT)
 
-	// Check if the media storage directory exists
-	pkg.DirExist(helper.Constants.MediaStorage)
+	// Check if the media storage directory exists, media files in an object storage are read through the storage
+	_, localStorage := config.ClientEnv.STORAGE.(*pkg.LocalStorage)
+	if localStorage {
+		pkg.DirExist(helper.Constants.MediaStorage)
+	}
 
 	// Initialize a new Chi router for handling HTTP requests
 	router := chi.NewRouter()
@@ -48,9 +53,61 @@ func main() {
 		./media_docker_files folder. The folder path is constructed based on the
 		current working directory and the constant for media storage.
 	*/
-	workDir, _ := os.Getwd()                                                    // Get the current working directory
-	filesDir := http.Dir(filepath.Join(workDir, helper.Constants.MediaStorage)) // Create an HTTP file system directory
-	mw.FileServer(router, "/"+helper.Constants.MediaStorage, filesDir)          // Register the file server with the router
+	workDir, _ := os.Getwd()                                                                       // Get the current working directory
+	var filesDir http.FileSystem = http.Dir(filepath.Join(workDir, helper.Constants.MediaStorage)) // Create an HTTP file system directory
+	if !localStorage {
+		filesDir = mw.StorageFileSystem(config.ClientEnv.STORAGE) // Serve the objects of the object storage by their key
+	}
+
+	// Create the image transformer, which resizes and converts images on request
+	imageTransformer, err := mw.NewImageTransformer(
+		"/"+helper.Constants.MediaStorage+"/images/",
+		"images/",
+		config.ClientEnv.STORAGE,
+		filepath.Join(workDir, helper.Constants.ImageCache),
+		config.ClientEnv.IMAGE_CACHE_SIZE,
+		config.ClientEnv.IMAGE_SIZES,
+		config.ClientEnv.MEDIA_MAX_AGE,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("error creating image transformer")
+		panic(err)
+	}
+
+	router.Group(func(router chi.Router) {
+		// Compress text manifests, registered first so that rewritten playlists are compressed as well
+		router.Use(mw.CompressManifests())
+
+		// Require signed URLs for all media files when enabled
+		if config.ClientEnv.SIGNED_URLS {
+			// NOTE: RealIP trusts the X-Real-IP and X-Forwarded-For headers set by the proxy,
+			// which is required for IP bound signatures behind nginx-proxy. Without TRUSTED_PROXY the headers
+			// could be spoofed, so the IP of the connection is used.
+			if config.ClientEnv.TRUSTED_PROXY {
+				router.Use(middleware.RealIP)
+			}
+			router.Use(mw.SignedURL(config.ClientEnv.PLAYBACK_SECRET))
+		}
+
+		// Serve resized and converted images for requests with transformation parameters
+		router.Use(imageTransformer.Handler)
+
+		// Redirect the other requests for stored files to presigned URLs of the object storage when enabled
+		if config.ClientEnv.STORAGE_REDIRECT {
+			router.Use(mw.StorageRedirect(config.ClientEnv.STORAGE, "/"+helper.Constants.MediaStorage+"/"))
+		}
+
+		mw.FileServer(router, "/"+helper.Constants.MediaStorage, filesDir, config.ClientEnv.PLAYLIST_MAX_AGE, config.ClientEnv.MEDIA_MAX_AGE) // Register the file server with the router
+
+		// Serve HLS encryption keys from KeyStorage, or from the storage, to players holding a valid playback token.
+		// The key endpoint is only enabled when a playback secret is configured.
+		if config.ClientEnv.PLAYBACK_SECRET != "" {
+			pkg.DirExist(helper.Constants.KeyStorage)
+			router.Get("/"+helper.Constants.MediaStorage+"/videos/{id}/"+pkg.HLSKeyName, api.HLSKey)
+		} else {
+			log.Warn().Msg("PLAYBACK_SECRET is not provided, HLS key endpoint is disabled")
+		}
+	})
 
 	// Define the index handler that responds with a simple message indicating the server is running
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
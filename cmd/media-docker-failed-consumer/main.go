@@ -37,6 +37,8 @@ func main() {
 
 	pkg.DirExist(helper.Constants.UploadStorage)
 	pkg.DirExist(helper.Constants.MediaStorage)
+	pkg.DirExist(helper.Constants.KeyStorage)
+	pkg.DirExist(helper.Constants.OriginalStorage)
 
 	// Check Kafka connection
 	err = kafkahandler.CheckAllKafkaConnections(config.FailedConsumeEnv.KAFKA_BROKERS)
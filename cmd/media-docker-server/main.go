@@ -52,7 +52,10 @@ func main() {
 	config.SetUpLogger(config.ServerEnv.ENVIRONMENT)
 
 	pkg.DirExist(helper.Constants.UploadStorage)
-	pkg.DirExist(helper.Constants.MediaStorage)
+	// Media files in an object storage are checked through the storage
+	if _, local := config.ServerEnv.STORAGE.(*pkg.LocalStorage); local {
+		pkg.DirExist(helper.Constants.MediaStorage)
+	}
 
 	err = kafkahandler.CheckAllKafkaConnections(config.ServerEnv.KAFKA_BROKERS)
 	if err != nil {
@@ -86,6 +89,9 @@ func main() {
 	router.Route("/api/v1/uploads", routes.UploadRoutes())
 	router.Route("/api/v1/destroys", routes.DestroyRoutes())
 	router.Route("/api/v1/connections", routes.ConnectionRoutes())
+	router.Route("/api/v1/playback", routes.PlaybackRoutes())
+	router.Route("/api/v1/edits", routes.EditRoutes())
+	router.Route("/api/v1/media", routes.MediaRoutes())
 
 	// Index handler
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
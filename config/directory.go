@@ -21,4 +21,13 @@ func CreateDirSetup() {
 
 	// Ensure the "audios" directory exists within MediaStorage.
 	pkg.DirExist(helper.Constants.MediaStorage+"/audios", true)
+
+	// Ensure the staging directory the jobs write into before their outputs are published exists within MediaStorage.
+	pkg.DirExist(helper.Constants.MediaStorage+"/"+pkg.StagingDir, true)
+
+	// Ensure the KeyStorage directory for HLS encryption keys exists.
+	pkg.DirExist(helper.Constants.KeyStorage, true)
+
+	// Ensure the OriginalStorage directory for archived original uploads exists.
+	pkg.DirExist(helper.Constants.OriginalStorage, true)
 }
@@ -5,6 +5,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
 )
 
 // Declare instances of the configuration structs for different environments
@@ -21,33 +24,58 @@ var (
 
 // clientConfig holds the configuration settings for the media-docker-client.
 type clientConfig struct {
-	ENVIRONMENT     string   // Current environment (e.g., development, production)
-	ALLOWED_ORIGINS []string // List of allowed origins for CORS to restrict access
-	CLIENT_PORT     string   // Port on which the client service will run
+	ENVIRONMENT      string      // Current environment (e.g., development, production)
+	ALLOWED_ORIGINS  []string    // List of allowed origins for CORS to restrict access
+	CLIENT_PORT      string      // Port on which the client service will run
+	PLAYBACK_SECRET  string      // Optional secret for validating playback tokens, enables the HLS key endpoint
+	SIGNED_URLS      bool        // Require signed URLs for all media files, requires PLAYBACK_SECRET
+	TRUSTED_PROXY    bool        // Read the client IP of IP bound signatures from the X-Real-IP and X-Forwarded-For headers of a proxy
+	PLAYLIST_MAX_AGE int         // Cache-Control max-age of HLS and DASH playlists in seconds
+	MEDIA_MAX_AGE    int         // Cache-Control max-age of segments and images in seconds
+	IMAGE_SIZES      []int       // Allowed widths and heights of image transformations
+	IMAGE_CACHE_SIZE int64       // Maximum size of the image derivative cache in bytes
+	STORAGE          pkg.Storage // Storage of the published media files
+	STORAGE_REDIRECT bool        // Redirect requests for stored files to presigned URLs of the storage instead of proxying them
 }
 
 // serverConfig holds the configuration settings for the media-docker-server.
 type serverConfig struct {
-	ENVIRONMENT     string   // Current environment (e.g., development, production)
-	ALLOWED_ORIGINS []string // List of allowed origins for CORS to restrict access
-	SERVER_KEY      string   // Authentication key for server communication
-	KAFKA_BROKERS   []string // List of Kafka broker addresses for message processing
-	BASE_URL        string   // Base URL for client access to media files
-	SERVER_PORT     string   // Port on which the server will run
+	ENVIRONMENT          string                          // Current environment (e.g., development, production)
+	ALLOWED_ORIGINS      []string                        // List of allowed origins for CORS to restrict access
+	SERVER_KEY           string                          // Authentication key for server communication
+	KAFKA_BROKERS        []string                        // List of Kafka broker addresses for message processing
+	BASE_URL             string                          // Base URL for client access to media files
+	SERVER_PORT          string                          // Port on which the server will run
+	PLAYBACK_SECRET      string                          // Optional secret for issuing playback tokens, must match the client
+	SIGNED_URLS          bool                            // Return signed fileUrls in responses, requires PLAYBACK_SECRET
+	SIGNED_URL_TTL       int                             // Lifetime of signed fileUrls in seconds
+	IMAGE_VARIANT_WIDTHS []int                           // Default widths of responsive image variants
+	WATERMARKS           map[string]pkg.WatermarkProfile // Watermark profiles by name, referenced by video and image jobs
+	STORAGE              pkg.Storage                     // Storage of the published media files
 }
 
 // kafkaConsumeConfig holds the configuration settings for the Kafka consumer.
 type kafkaConsumeConfig struct {
-	ENVIRONMENT         string         // Current environment (e.g., development, production)
-	KAFKA_BROKERS       []string       // List of Kafka broker addresses for message consumption
-	KAFKA_TOPIC_WORKERS map[string]int // Map of topics to the number of workers assigned for each topic
+	ENVIRONMENT         string                          // Current environment (e.g., development, production)
+	KAFKA_BROKERS       []string                        // List of Kafka broker addresses for message consumption
+	KAFKA_TOPIC_WORKERS map[string]int                  // Map of topics to the number of workers assigned for each topic
+	HLS                 pkg.HLSOptions                  // Global HLS options, overridable per job
+	ANIMATION           pkg.AnimationLimits             // Frame and duration limits of animated image uploads
+	WATERMARKS          map[string]pkg.WatermarkProfile // Watermark profiles by name, burned into video and image jobs
+	KEEP_ORIGINALS      []string                        // Media types whose uploads are archived as originals instead of deleted
+	STORAGE             pkg.Storage                     // Storage the outputs are published to
 }
 
 // failedConsumeConfig holds the configuration settings for the failed consumer.
 type failedConsumeConfig struct {
-	ENVIRONMENT          string   // Current environment (e.g., development, production)
-	KAFKA_BROKERS        []string // List of Kafka broker addresses for handling failed messages
-	KAFKA_FAILED_WORKERS int      // Number of workers assigned for processing failed messages
+	ENVIRONMENT          string                          // Current environment (e.g., development, production)
+	KAFKA_BROKERS        []string                        // List of Kafka broker addresses for handling failed messages
+	KAFKA_FAILED_WORKERS int                             // Number of workers assigned for processing failed messages
+	HLS                  pkg.HLSOptions                  // Global HLS options, overridable per job
+	ANIMATION            pkg.AnimationLimits             // Frame and duration limits of animated image uploads
+	WATERMARKS           map[string]pkg.WatermarkProfile // Watermark profiles by name, burned into video and image jobs
+	KEEP_ORIGINALS       []string                        // Media types whose uploads are archived as originals instead of deleted
+	STORAGE              pkg.Storage                     // Storage the outputs are published to
 }
 
 // getAndValidateWorkerCount retrieves and validates worker count from environment variables.
@@ -70,6 +98,211 @@ func getAndValidateWorkerCount(envVar string) (int, error) {
 	return workerCount, nil
 }
 
+// getHLSOptions retrieves the optional global HLS options from environment variables.
+// Every variable that is not provided keeps its value from pkg.DefaultHLSOptions.
+func getHLSOptions() (pkg.HLSOptions, error) {
+	options := pkg.DefaultHLSOptions
+
+	// HLS_SEGMENT_DURATION validation (1 to 60 seconds)
+	if value, exists := os.LookupEnv("HLS_SEGMENT_DURATION"); exists {
+		duration, err := strconv.Atoi(value)
+		if err != nil || duration < 1 || duration > 60 {
+			return options, fmt.Errorf("invalid HLS_SEGMENT_DURATION, must be between 1 and 60")
+		}
+		options.SegmentDuration = duration
+	}
+
+	// HLS_SEGMENT_NAME_WIDTH validation (0 to 12, 0 disables padding)
+	if value, exists := os.LookupEnv("HLS_SEGMENT_NAME_WIDTH"); exists {
+		width, err := strconv.Atoi(value)
+		if err != nil || width < 0 || width > 12 {
+			return options, fmt.Errorf("invalid HLS_SEGMENT_NAME_WIDTH, must be between 0 and 12")
+		}
+		options.SegmentNameWidth = width
+	}
+
+	// HLS_PLAYLIST_TYPE validation
+	if value, exists := os.LookupEnv("HLS_PLAYLIST_TYPE"); exists {
+		if value != "vod" && value != "event" {
+			return options, fmt.Errorf("invalid HLS_PLAYLIST_TYPE, must be vod or event")
+		}
+		options.PlaylistType = value
+	}
+
+	// HLS_FORCE_KEYFRAMES validation
+	if value, exists := os.LookupEnv("HLS_FORCE_KEYFRAMES"); exists {
+		forceKeyframes, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid HLS_FORCE_KEYFRAMES: %v", err)
+		}
+		options.ForceKeyframes = forceKeyframes
+	}
+
+	// HLS_INDEPENDENT_SEGMENTS validation
+	if value, exists := os.LookupEnv("HLS_INDEPENDENT_SEGMENTS"); exists {
+		independentSegments, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid HLS_INDEPENDENT_SEGMENTS: %v", err)
+		}
+		options.IndependentSegments = independentSegments
+	}
+
+	return options, nil
+}
+
+// getAnimationLimits retrieves the optional limits of animated image uploads from environment variables.
+// Every variable that is not provided keeps its value from pkg.DefaultAnimationLimits.
+func getAnimationLimits() (pkg.AnimationLimits, error) {
+	limits := pkg.DefaultAnimationLimits
+
+	// IMAGE_MAX_FRAMES validation (1 to 10000 frames)
+	if value, exists := os.LookupEnv("IMAGE_MAX_FRAMES"); exists {
+		frames, err := strconv.Atoi(value)
+		if err != nil || frames < 1 || frames > 10000 {
+			return limits, fmt.Errorf("invalid IMAGE_MAX_FRAMES, must be between 1 and 10000")
+		}
+		limits.MaxFrames = frames
+	}
+
+	// IMAGE_MAX_DURATION validation (1 to 600 seconds)
+	if value, exists := os.LookupEnv("IMAGE_MAX_DURATION"); exists {
+		duration, err := strconv.Atoi(value)
+		if err != nil || duration < 1 || duration > 600 {
+			return limits, fmt.Errorf("invalid IMAGE_MAX_DURATION, must be between 1 and 600")
+		}
+		limits.MaxDuration = duration
+	}
+
+	return limits, nil
+}
+
+// getWatermarkProfiles retrieves the optional watermark profiles from the JSON file of WATERMARK_PROFILES.
+// The consumers burn the profiles and check that their logo and font files exist, the server only checks the names of jobs.
+// If the variable is not set, no profile is available.
+func getWatermarkProfiles(checkFiles bool) (map[string]pkg.WatermarkProfile, error) {
+	path, exists := os.LookupEnv("WATERMARK_PROFILES")
+	if !exists || path == "" {
+		return map[string]pkg.WatermarkProfile{}, nil
+	}
+
+	profiles, err := pkg.LoadWatermarkProfiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATERMARK_PROFILES: %w", err)
+	}
+	if checkFiles {
+		if err := pkg.CheckWatermarkFiles(profiles); err != nil {
+			return nil, fmt.Errorf("invalid WATERMARK_PROFILES: %w", err)
+		}
+	}
+
+	return profiles, nil
+}
+
+// getKeepOriginals retrieves the optional retention policy of original uploads from KEEP_ORIGINALS,
+// a comma separated list of the media types (video, image, audio) whose uploads are archived after processing.
+// If the variable is not set, every upload is deleted after processing.
+func getKeepOriginals() ([]string, error) {
+	value, exists := os.LookupEnv("KEEP_ORIGINALS")
+	if !exists || strings.TrimSpace(value) == "" {
+		return []string{}, nil
+	}
+
+	mediaTypes := []string{}
+	for _, item := range strings.Split(value, ",") {
+		mediaType := strings.TrimSpace(item)
+		if mediaType != "video" && mediaType != "image" && mediaType != "audio" {
+			return nil, fmt.Errorf("invalid KEEP_ORIGINALS, values must be video, image or audio: %s", item)
+		}
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+
+	return mediaTypes, nil
+}
+
+// getSignedURLs retrieves the optional SIGNED_URLS flag from environment variables.
+// Signed URLs can only be enabled when a playback secret is provided, as it is used for signing.
+func getSignedURLs(playbackSecret string) (bool, error) {
+	value, exists := os.LookupEnv("SIGNED_URLS")
+	if !exists {
+		return false, nil
+	}
+
+	signedURLs, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid SIGNED_URLS: %v", err)
+	}
+
+	if signedURLs && playbackSecret == "" {
+		return false, fmt.Errorf("PLAYBACK_SECRET is required when SIGNED_URLS is enabled")
+	}
+
+	return signedURLs, nil
+}
+
+// getIntList parses the comma separated list of integers of the environment variable name,
+// every value must be between min and max. If the variable is not set, defaults is returned.
+func getIntList(name string, defaults []int, min, max int) ([]int, error) {
+	value, exists := os.LookupEnv(name)
+	if !exists {
+		return defaults, nil
+	}
+
+	list := []int{}
+	for _, item := range strings.Split(value, ",") {
+		parsed, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || parsed < min || parsed > max {
+			return nil, fmt.Errorf("invalid %s, values must be between %d and %d: %s", name, min, max, item)
+		}
+		list = append(list, parsed)
+	}
+
+	return list, nil
+}
+
+// getStorage retrieves the storage of the published media files from STORAGE, "local" (default) for the media storage
+// directory, or "s3" for a bucket of an S3-compatible object storage configured by S3_ENDPOINT, S3_BUCKET,
+// S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY, and the optional S3_REGION (default us-east-1) and S3_PATH_STYLE (default true).
+func getStorage() (pkg.Storage, error) {
+	value, exists := os.LookupEnv("STORAGE")
+	if !exists || value == "" || value == "local" {
+		return pkg.NewLocalStorage(helper.Constants.MediaStorage), nil
+	}
+	if value != "s3" {
+		return nil, fmt.Errorf("invalid STORAGE, must be local or s3")
+	}
+
+	settings := map[string]string{}
+	for _, name := range []string{"S3_ENDPOINT", "S3_BUCKET", "S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY"} {
+		setting, exists := os.LookupEnv(name)
+		if !exists || setting == "" {
+			return nil, fmt.Errorf("%s is required when STORAGE is s3", name)
+		}
+		settings[name] = setting
+	}
+
+	region := "us-east-1"
+	if value, exists := os.LookupEnv("S3_REGION"); exists && value != "" {
+		region = value
+	}
+
+	// MinIO and most S3-compatible storages only support path style requests
+	pathStyle := true
+	if value, exists := os.LookupEnv("S3_PATH_STYLE"); exists {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3_PATH_STYLE: %v", err)
+		}
+		pathStyle = parsed
+	}
+
+	storage, err := pkg.NewS3Storage(settings["S3_ENDPOINT"], region, settings["S3_BUCKET"],
+		settings["S3_ACCESS_KEY_ID"], settings["S3_SECRET_ACCESS_KEY"], pathStyle)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE: %w", err)
+	}
+	return storage, nil
+}
+
 // ValidateClientEnv validates the environment variables for the client configuration.
 func ValidateClientEnv() error {
 	environment, exists := os.LookupEnv("ENVIRONMENT")
@@ -85,6 +318,79 @@ func ValidateClientEnv() error {
 	ClientEnv.ENVIRONMENT = environment
 	ClientEnv.ALLOWED_ORIGINS = strings.Split(allowedOrigins, ",")
 	ClientEnv.CLIENT_PORT = "7000"
+	ClientEnv.PLAYBACK_SECRET = os.Getenv("PLAYBACK_SECRET") // Optional, empty disables the HLS key endpoint
+
+	// SIGNED_URLS validation
+	signedURLs, err := getSignedURLs(ClientEnv.PLAYBACK_SECRET)
+	if err != nil {
+		return err
+	}
+	ClientEnv.SIGNED_URLS = signedURLs
+
+	// TRUSTED_PROXY validation, the forwarded headers can be set by any caller reaching the client directly
+	if value, exists := os.LookupEnv("TRUSTED_PROXY"); exists {
+		trusted, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid TRUSTED_PROXY: %v", err)
+		}
+		ClientEnv.TRUSTED_PROXY = trusted
+	}
+
+	// PLAYLIST_MAX_AGE validation, defaults to 10 seconds
+	ClientEnv.PLAYLIST_MAX_AGE = 10
+	if value, exists := os.LookupEnv("PLAYLIST_MAX_AGE"); exists {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil || maxAge < 0 {
+			return fmt.Errorf("invalid PLAYLIST_MAX_AGE, must be 0 or more seconds")
+		}
+		ClientEnv.PLAYLIST_MAX_AGE = maxAge
+	}
+
+	// MEDIA_MAX_AGE validation, defaults to 1 hour. Segments and images are not immutable, reprocess jobs replace them
+	ClientEnv.MEDIA_MAX_AGE = 3600
+	if value, exists := os.LookupEnv("MEDIA_MAX_AGE"); exists {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil || maxAge < 0 {
+			return fmt.Errorf("invalid MEDIA_MAX_AGE, must be 0 or more seconds")
+		}
+		ClientEnv.MEDIA_MAX_AGE = maxAge
+	}
+
+	// IMAGE_SIZES validation, only these sizes can be requested, so derivatives cannot be created for arbitrary sizes
+	imageSizes, err := getIntList("IMAGE_SIZES", []int{64, 128, 256, 512, 1024, 1920}, 1, 8192)
+	if err != nil {
+		return err
+	}
+	ClientEnv.IMAGE_SIZES = imageSizes
+
+	// IMAGE_CACHE_SIZE validation in megabytes, defaults to 1024 MB
+	ClientEnv.IMAGE_CACHE_SIZE = 1024 * 1024 * 1024
+	if value, exists := os.LookupEnv("IMAGE_CACHE_SIZE"); exists {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 1 {
+			return fmt.Errorf("invalid IMAGE_CACHE_SIZE, minimum is 1 MB")
+		}
+		ClientEnv.IMAGE_CACHE_SIZE = size * 1024 * 1024
+	}
+
+	// STORAGE validation
+	storage, err := getStorage()
+	if err != nil {
+		return err
+	}
+	ClientEnv.STORAGE = storage
+
+	// STORAGE_REDIRECT validation, presigned URLs are only provided by object storages
+	if value, exists := os.LookupEnv("STORAGE_REDIRECT"); exists {
+		redirect, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid STORAGE_REDIRECT: %v", err)
+		}
+		if _, local := storage.(*pkg.LocalStorage); redirect && local {
+			return fmt.Errorf("STORAGE_REDIRECT requires STORAGE s3")
+		}
+		ClientEnv.STORAGE_REDIRECT = redirect
+	}
 
 	return nil
 }
@@ -128,6 +434,45 @@ func ValidateServerEnv() error {
 	ServerEnv.SERVER_PORT = "7007"
 	ServerEnv.BASE_URL = baseURL
 	ServerEnv.KAFKA_BROKERS = strings.Split(brokers, ",")
+	ServerEnv.PLAYBACK_SECRET = os.Getenv("PLAYBACK_SECRET") // Optional, empty disables playback tokens
+
+	// SIGNED_URLS validation
+	signedURLs, err := getSignedURLs(ServerEnv.PLAYBACK_SECRET)
+	if err != nil {
+		return err
+	}
+	ServerEnv.SIGNED_URLS = signedURLs
+
+	// SIGNED_URL_TTL validation, defaults to 1 day
+	ServerEnv.SIGNED_URL_TTL = 86400
+	if value, exists := os.LookupEnv("SIGNED_URL_TTL"); exists {
+		ttl, err := strconv.Atoi(value)
+		if err != nil || ttl < 60 {
+			return fmt.Errorf("invalid SIGNED_URL_TTL, minimum is 60 seconds")
+		}
+		ServerEnv.SIGNED_URL_TTL = ttl
+	}
+
+	// IMAGE_VARIANT_WIDTHS validation, used for image jobs requesting variants without widths
+	variantWidths, err := getIntList("IMAGE_VARIANT_WIDTHS", []int{320, 640, 960, 1280, 1920}, 16, 8192)
+	if err != nil {
+		return err
+	}
+	ServerEnv.IMAGE_VARIANT_WIDTHS = variantWidths
+
+	// WATERMARK_PROFILES validation, the files of the profiles are only read by the consumers
+	watermarks, err := getWatermarkProfiles(false)
+	if err != nil {
+		return err
+	}
+	ServerEnv.WATERMARKS = watermarks
+
+	// STORAGE validation, the server checks that media files exist before sending jobs
+	storage, err := getStorage()
+	if err != nil {
+		return err
+	}
+	ServerEnv.STORAGE = storage
 
 	return nil
 }
@@ -152,6 +497,9 @@ func ValidateKafkaConsumeEnv() error {
 		"video-resolutions": "KAFKA_VIDEO_RESOLUTIONS_WORKERS",
 		"image":             "KAFKA_IMAGE_WORKERS",
 		"audio":             "KAFKA_AUDIO_WORKERS",
+		"caption":           "KAFKA_CAPTION_WORKERS",
+		"clip":              "KAFKA_CLIP_WORKERS",
+		"concat":            "KAFKA_CONCAT_WORKERS",
 		"delete-file":       "KAFKA_DELETE_FILE_WORKERS",
 	}
 
@@ -165,10 +513,45 @@ func ValidateKafkaConsumeEnv() error {
 		workerCounts[topic] = workerCount
 	}
 
+	// Validate optional global HLS options
+	hlsOptions, err := getHLSOptions()
+	if err != nil {
+		return err
+	}
+
+	// Validate optional animation limits
+	animationLimits, err := getAnimationLimits()
+	if err != nil {
+		return err
+	}
+
+	// Validate optional watermark profiles
+	watermarks, err := getWatermarkProfiles(true)
+	if err != nil {
+		return err
+	}
+
+	// Validate the optional retention policy of original uploads
+	keepOriginals, err := getKeepOriginals()
+	if err != nil {
+		return err
+	}
+
+	// Validate the storage the outputs are published to
+	storage, err := getStorage()
+	if err != nil {
+		return err
+	}
+
 	// Set the validated environment variables in KafkaConsumeEnv
 	KafkaConsumeEnv.ENVIRONMENT = environment
 	KafkaConsumeEnv.KAFKA_BROKERS = strings.Split(brokers, ",")
 	KafkaConsumeEnv.KAFKA_TOPIC_WORKERS = workerCounts
+	KafkaConsumeEnv.HLS = hlsOptions
+	KafkaConsumeEnv.ANIMATION = animationLimits
+	KafkaConsumeEnv.WATERMARKS = watermarks
+	KafkaConsumeEnv.KEEP_ORIGINALS = keepOriginals
+	KafkaConsumeEnv.STORAGE = storage
 
 	return nil
 }
@@ -193,10 +576,45 @@ func ValidateFailedConsumeEnv() error {
 		return err
 	}
 
+	// Validate optional global HLS options
+	hlsOptions, err := getHLSOptions()
+	if err != nil {
+		return err
+	}
+
+	// Validate optional animation limits
+	animationLimits, err := getAnimationLimits()
+	if err != nil {
+		return err
+	}
+
+	// Validate optional watermark profiles
+	watermarks, err := getWatermarkProfiles(true)
+	if err != nil {
+		return err
+	}
+
+	// Validate the optional retention policy of original uploads
+	keepOriginals, err := getKeepOriginals()
+	if err != nil {
+		return err
+	}
+
+	// Validate the storage the outputs are published to
+	storage, err := getStorage()
+	if err != nil {
+		return err
+	}
+
 	// Set the validated environment variables in FailedConsumeEnv
 	FailedConsumeEnv.ENVIRONMENT = environment
 	FailedConsumeEnv.KAFKA_BROKERS = strings.Split(brokers, ",")
 	FailedConsumeEnv.KAFKA_FAILED_WORKERS = workerCount
+	FailedConsumeEnv.HLS = hlsOptions
+	FailedConsumeEnv.ANIMATION = animationLimits
+	FailedConsumeEnv.WATERMARKS = watermarks
+	FailedConsumeEnv.KEEP_ORIGINALS = keepOriginals
+	FailedConsumeEnv.STORAGE = storage
 
 	return nil
 }
@@ -8,8 +8,11 @@ require (
 	github.com/go-playground/validator/v10 v10.22.0
 	github.com/google/uuid v1.6.0
 	github.com/rs/zerolog v1.33.0
+	golang.org/x/sys v0.24.0
 )
 
+require github.com/andybalholm/brotli v1.1.1
+
 require (
 	github.com/gabriel-vasile/mimetype v1.4.5 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
@@ -22,6 +25,5 @@ require (
 	github.com/segmentio/kafka-go v0.4.47
 	golang.org/x/crypto v0.26.0 // indirect
 	golang.org/x/net v0.28.0 // indirect
-	golang.org/x/sys v0.24.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 )
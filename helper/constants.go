@@ -12,8 +12,11 @@ type FileConfig struct {
 // constConfig holds the overall configuration for file uploads,
 // including storage locations and file category settings.
 type constConfig struct {
-	UploadStorage string // Directory for storing uploaded files
-	MediaStorage  string // Directory for storing media files
+	UploadStorage   string // Directory for storing uploaded files
+	MediaStorage    string // Directory for storing media files
+	KeyStorage      string // Directory for storing HLS encryption keys, kept outside of MediaStorage
+	OriginalStorage string // Directory for archiving the original uploads, kept outside of MediaStorage
+	ImageCache      string // Directory for caching image derivatives created by media-docker-client
 	// MaxChunkSize defines the maximum size for each file chunk,
 	// set to 2 MB (2 * 1024 * 1024 bytes), in accordance with
 	// the MediaDocker module specifications.
@@ -42,16 +45,19 @@ func (c *constConfig) IsValidFileType(fileCategory, mimeType string) bool {
 
 // NOTE: do not change these values, project will break
 var Constants = &constConfig{
-	UploadStorage: "uploadStorage",      // Path to the directory where files will be uploaded
-	MediaStorage:  "media_docker_files", // Path to the directory for media storage
+	UploadStorage:   "uploadStorage",          // Path to the directory where files will be uploaded
+	MediaStorage:    "media_docker_files",     // Path to the directory for media storage
+	KeyStorage:      "media_docker_keys",      // Path to the directory for HLS encryption keys
+	OriginalStorage: "media_docker_originals", // Path to the directory for archived original uploads
+	ImageCache:      "media_docker_cache",     // Path to the directory for cached image derivatives
 	// maxChunkSize defines the maximum size for each file chunk,
 	// set to 2 MB (2 * 1024 * 1024 bytes), in accordance with
 	// the MediaDocker module specifications.
 	MaxChunkSize: 1024 * 1024 * 2, // 2 MB
 	Files: map[string]FileConfig{ // Configuration for different file types
 		"image": {
-			AllowedTypes: []string{"image/jpeg", "image/jpg", "image/png"}, // Allowed image MIME types
-			MaxSize:      1024 * 1024 * 50,                                 // Maximum size for image uploads (50 MB)
+			AllowedTypes: []string{"image/jpeg", "image/jpg", "image/png", "image/gif", "image/webp"}, // Allowed image MIME types, gif and webp may be animated
+			MaxSize:      1024 * 1024 * 50,                                                            // Maximum size for image uploads (50 MB)
 		},
 		"video": {
 			AllowedTypes: []string{"video/mp4", "video/webm", "video/ogg", "video/mkv"}, // Allowed video MIME types
@@ -61,5 +67,9 @@ var Constants = &constConfig{
 			AllowedTypes: []string{"audio/mp3", "audio/mpeg", "audio/wav"}, // Allowed audio MIME types
 			MaxSize:      1024 * 1024 * 50,                                 // Maximum size for audio uploads (50 MB)
 		},
+		"caption": {
+			AllowedTypes: []string{"text/vtt", "text/srt", "text/plain"}, // Allowed caption MIME types, SRT files are usually sent as text/plain
+			MaxSize:      1024 * 1024 * 5,                                // Maximum size for caption uploads (5 MB)
+		},
 	},
 }
@@ -2,8 +2,9 @@ package process
 
 import (
 	"fmt"
-	"os"
+	"time"
 
+	"github.com/nvj9singhnavjot/media-docker/config"
 	"github.com/nvj9singhnavjot/media-docker/helper"
 	"github.com/nvj9singhnavjot/media-docker/pkg"
 	"github.com/nvj9singhnavjot/media-docker/topics"
@@ -23,33 +24,70 @@ func processVideoMessage(workerName string, dlqMsg topics.DLQMessage) (string, e
 		return "", fmt.Errorf("error during message unmarshalling and validation: %s, %v", errMsg, err)
 	}
 
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the video.
+	var stagingStorage string
+	stagingStorage, videoMsg.FilePath, err = jobStorage(workerName, "video", videoMsg.NewId, videoMsg.FilePath, videoMsg.Upload, videoMsg.Reprocess)
+	if err != nil {
+		return videoMsg.NewId, err
+	}
+
+	// Remove the staging storage once the job completes, its outputs are published on success.
+	defer removeStaging(workerName, stagingStorage)
+
 	// Define the output path where the converted video will be stored.
-	outputPath := fmt.Sprintf("%s/videos/%s", helper.Constants.MediaStorage, videoMsg.NewId)
+	outputPath := fmt.Sprintf("%s/videos/%s", stagingStorage, videoMsg.NewId)
 
-	// Check if the output directory already exists.
-	if _, err = os.Stat(outputPath); !os.IsNotExist(err) {
-		// If it exists, clean up any existing files or subdirectories within it.
-		if err = cleanupOutputDirectory(workerName, outputPath); err != nil {
-			return videoMsg.NewId, err
-		}
-	} else {
-		// If the output directory does not exist, create it.
-		if err = createOutputDirectory(workerName, outputPath); err != nil {
+	// Ensure the removal of the original video file occurs after processing is complete, the archived original read by a reprocess job is kept.
+	if videoMsg.Reprocess == nil {
+		defer removeFile(workerName, videoMsg.FilePath)
+		defer removeUpload(workerName, videoMsg.Upload) // The upload handed off through the storage is removed as well.
+	}
+
+	// Resolve the watermark profile burned into the video, retrying would fail again.
+	watermark, err := pkg.WatermarkFromMessage(config.FailedConsumeEnv.WATERMARKS, videoMsg.Watermark)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("worker", workerName).
+			Msg("Invalid watermark profile")
+		return videoMsg.NewId, err
+	}
+
+	// Apply the job HLS overrides on top of the global HLS options.
+	hls := config.FailedConsumeEnv.HLS.Merge(videoMsg.HLS)
+
+	// Create a new AES-128 key and the key info file if encryption is requested.
+	if videoMsg.Encryption != nil {
+		hls.KeyInfoFile, err = pkg.PrepareHLSEncryption(config.FailedConsumeEnv.STORAGE, helper.Constants.KeyStorage, videoMsg.NewId)
+		if err != nil {
 			return videoMsg.NewId, err
 		}
+		// Ensure the removal of the key info file, as it is only needed during conversion.
+		defer removeFile(workerName, hls.KeyInfoFile)
 	}
 
-	// Ensure the removal of the original video file occurs after processing is complete.
-	defer removeFile(workerName, videoMsg.FilePath)
+	// Measure the loudness of the audio track if the job normalizes it.
+	loudness, err := measureLoudness(workerName, videoMsg.FilePath, videoMsg.NormalizeLoudness)
+	if err != nil {
+		removeHLSKey(workerName, videoMsg.NewId, videoMsg.Encryption, videoMsg.Reprocess)
+		return videoMsg.NewId, err
+	}
+
+	// Read the audio streams kept as alternate audio renditions, each measured if the job normalizes the loudness.
+	audioTracks, err := probeVideoAudioTracks(workerName, videoMsg.FilePath, videoMsg.AudioTracks, videoMsg.NormalizeLoudness)
+	if err != nil {
+		removeHLSKey(workerName, videoMsg.NewId, videoMsg.Encryption, videoMsg.Reprocess)
+		return videoMsg.NewId, err
+	}
 
 	// Attempt to convert the video file up to three times, retrying on failure.
 	for i := 1; i <= 3; i++ {
 		if videoMsg.Quality != nil {
 			// Use the specified video quality for conversion if provided in the message.
-			err = pkg.ConvertVideo(videoMsg.FilePath, outputPath, *videoMsg.Quality)
+			err = pkg.ConvertVideo(videoMsg.FilePath, outputPath, hls, loudness, watermark, *videoMsg.Quality)
 		} else {
 			// If no quality is specified, apply the default video quality for conversion.
-			err = pkg.ConvertVideo(videoMsg.FilePath, outputPath)
+			err = pkg.ConvertVideo(videoMsg.FilePath, outputPath, hls, loudness, watermark)
 		}
 
 		// Exit the retry loop if conversion is successful.
@@ -57,13 +95,13 @@ func processVideoMessage(workerName string, dlqMsg topics.DLQMessage) (string, e
 			break
 		}
 
-		// On the last attempt (third), log the failure and schedule deletion of the output directory.
+		// On the last attempt (third), log the failure and remove the AES-128 key, the staging storage is removed by the deferred removeStaging.
 		if i == 3 {
 			log.Error().
 				Err(err).
 				Str("worker", workerName).
 				Msgf("Attempt %d failed for video conversion", i)
-			RemoveDir(workerName, outputPath)
+			removeHLSKey(workerName, videoMsg.NewId, videoMsg.Encryption, videoMsg.Reprocess)
 			return videoMsg.NewId, fmt.Errorf("failed to convert video after 3 attempts: %v", err)
 		} else {
 			// Log a warning if the attempt fails but is not the last one.
@@ -80,6 +118,23 @@ func processVideoMessage(workerName string, dlqMsg topics.DLQMessage) (string, e
 		}
 	}
 
+	// Convert the alternate audio tracks next to the video.
+	if err = convertVideoAudioTracks(workerName, videoMsg.NewId, videoMsg.FilePath, audioTracks, hls, videoMsg.Encryption, videoMsg.Reprocess); err != nil {
+		return videoMsg.NewId, err
+	}
+
+	// Write the poster image, placeholder and optional preview of the video.
+	preview := pkg.VideoPreviewFromMessage(videoMsg.Preview)
+	if err = createVideoAssets(workerName, videoMsg.NewId, videoMsg.FilePath, preview, loudness, audioTracks, watermark, videoMsg.Encryption, videoMsg.Reprocess); err != nil {
+		return videoMsg.NewId, err
+	}
+
+	// Publish the outputs, and archive the upload as the original of the video.
+	if err = finishJob(workerName, "video", videoMsg.NewId, stagingStorage, videoMsg.FilePath, videoMsg.Reprocess); err != nil {
+		removeHLSKey(workerName, videoMsg.NewId, videoMsg.Encryption, videoMsg.Reprocess)
+		return videoMsg.NewId, err
+	}
+
 	return videoMsg.NewId, nil
 }
 
@@ -95,26 +150,38 @@ func processVideoResolutionsMessage(workerName string, dlqMsg topics.DLQMessage)
 		return "", fmt.Errorf("error during message unmarshalling and validation: %s, %v", errMsg, err)
 	}
 
-	// Ensure the removal of the original video file occurs after processing is complete.
-	defer removeFile(workerName, videoResolutionsMsg.FilePath)
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the video.
+	var stagingStorage string
+	stagingStorage, videoResolutionsMsg.FilePath, err = jobStorage(workerName, "video", videoResolutionsMsg.NewId, videoResolutionsMsg.FilePath, videoResolutionsMsg.Upload, videoResolutionsMsg.Reprocess)
+	if err != nil {
+		return videoResolutionsMsg.NewId, err
+	}
+
+	// Remove the staging storage once the job completes, its outputs are published on success.
+	defer removeStaging(workerName, stagingStorage)
 
-	// Define the output path where the converted video resolutions will be stored.
-	outputPath := fmt.Sprintf("%s/videos/%s", helper.Constants.MediaStorage, videoResolutionsMsg.NewId)
+	// Ensure the removal of the original video file occurs after processing is complete, the archived original read by a reprocess job is kept.
+	if videoResolutionsMsg.Reprocess == nil {
+		defer removeFile(workerName, videoResolutionsMsg.FilePath)
+		defer removeUpload(workerName, videoResolutionsMsg.Upload) // The upload handed off through the storage is removed as well.
+	}
 
-	// Check if the output directory already exists.
-	if _, err = os.Stat(outputPath); !os.IsNotExist(err) {
-		// If it exists, clean up any existing files or subdirectories within it.
-		if err = cleanupOutputDirectory(workerName, outputPath); err != nil {
-			return videoResolutionsMsg.NewId, err
-		}
+	// Resolve the watermark profile burned into every resolution, retrying would fail again.
+	watermark, err := pkg.WatermarkFromMessage(config.FailedConsumeEnv.WATERMARKS, videoResolutionsMsg.Watermark)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("worker", workerName).
+			Msg("Invalid watermark profile")
+		return videoResolutionsMsg.NewId, err
 	}
 
 	// Prepare the output directories for each resolution.
 	outputPaths := map[string]string{
-		"360":  fmt.Sprintf("%s/videos/%s/360", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
-		"480":  fmt.Sprintf("%s/videos/%s/480", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
-		"720":  fmt.Sprintf("%s/videos/%s/720", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
-		"1080": fmt.Sprintf("%s/videos/%s/1080", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
+		"360":  fmt.Sprintf("%s/videos/%s/360", stagingStorage, videoResolutionsMsg.NewId),
+		"480":  fmt.Sprintf("%s/videos/%s/480", stagingStorage, videoResolutionsMsg.NewId),
+		"720":  fmt.Sprintf("%s/videos/%s/720", stagingStorage, videoResolutionsMsg.NewId),
+		"1080": fmt.Sprintf("%s/videos/%s/1080", stagingStorage, videoResolutionsMsg.NewId),
 	}
 
 	// Create the output directories for each resolution.
@@ -124,23 +191,59 @@ func processVideoResolutionsMessage(workerName string, dlqMsg topics.DLQMessage)
 		}
 	}
 
+	// Apply the job HLS overrides on top of the global HLS options.
+	hls := config.FailedConsumeEnv.HLS.Merge(videoResolutionsMsg.HLS)
+
+	// Create a new AES-128 key once, all resolutions are encrypted with the same key.
+	if videoResolutionsMsg.Encryption != nil {
+		if err = pkg.CreateHLSKey(config.FailedConsumeEnv.STORAGE, helper.Constants.KeyStorage, videoResolutionsMsg.NewId); err != nil {
+			return videoResolutionsMsg.NewId, err
+		}
+	}
+
+	// Measure the loudness of the audio track once, all resolutions are normalized with the same measurement.
+	loudness, err := measureLoudness(workerName, videoResolutionsMsg.FilePath, videoResolutionsMsg.NormalizeLoudness)
+	if err != nil {
+		removeHLSKey(workerName, videoResolutionsMsg.NewId, videoResolutionsMsg.Encryption, videoResolutionsMsg.Reprocess)
+		return videoResolutionsMsg.NewId, err
+	}
+
+	// Read the audio streams kept as alternate audio renditions, each measured if the job normalizes the loudness.
+	audioTracks, err := probeVideoAudioTracks(workerName, videoResolutionsMsg.FilePath, videoResolutionsMsg.AudioTracks, videoResolutionsMsg.NormalizeLoudness)
+	if err != nil {
+		removeHLSKey(workerName, videoResolutionsMsg.NewId, videoResolutionsMsg.Encryption, videoResolutionsMsg.Reprocess)
+		return videoResolutionsMsg.NewId, err
+	}
+
 	// Loop through each resolution and attempt to convert the video with retry logic.
 	for res, outputPath := range outputPaths {
+		// Write the key info file of the resolution, its playlist is one directory below the key URI.
+		resHLS := hls
+		if videoResolutionsMsg.Encryption != nil {
+			resHLS.KeyInfoFile, err = pkg.WriteRenditionKeyInfo(helper.Constants.KeyStorage, videoResolutionsMsg.NewId, res, "../"+pkg.HLSKeyName)
+			if err != nil {
+				removeHLSKey(workerName, videoResolutionsMsg.NewId, videoResolutionsMsg.Encryption, videoResolutionsMsg.Reprocess)
+				return videoResolutionsMsg.NewId, err
+			}
+			// Ensure the removal of the key info file, as it is only needed during conversion.
+			defer removeFile(workerName, resHLS.KeyInfoFile)
+		}
+
 		// Retry conversion up to three times.
 		for i := 1; i <= 3; i++ {
 			// Execute the command to convert the video to the specified resolution.
-			err = pkg.ConvertVideoResolutions(videoResolutionsMsg.FilePath, outputPath, res)
+			err = pkg.ConvertVideoResolutions(videoResolutionsMsg.FilePath, outputPath, res, resHLS, loudness, watermark)
 			if err == nil {
 				break // Exit the loop if conversion is successful.
 			}
 
-			// On the last attempt (third), log the failure and schedule deletion of the output directory.
+			// On the last attempt (third), log the failure and remove the AES-128 key, the staging storage is removed by the deferred removeStaging.
 			if i == 3 {
 				log.Error().
 					Err(err).
 					Str("worker", workerName).
 					Msgf("Attempt %d failed for video resolution conversion", i)
-				RemoveDir(workerName, outputPath)
+				removeHLSKey(workerName, videoResolutionsMsg.NewId, videoResolutionsMsg.Encryption, videoResolutionsMsg.Reprocess)
 				return videoResolutionsMsg.NewId, fmt.Errorf("failed to convert video after 3 attempts: %v", err)
 			} else {
 				// Log a warning if the attempt fails but is not the last one.
@@ -152,6 +255,23 @@ func processVideoResolutionsMessage(workerName string, dlqMsg topics.DLQMessage)
 		}
 	}
 
+	// Convert the alternate audio tracks once, all resolutions share them.
+	if err = convertVideoAudioTracks(workerName, videoResolutionsMsg.NewId, videoResolutionsMsg.FilePath, audioTracks, hls, videoResolutionsMsg.Encryption, videoResolutionsMsg.Reprocess); err != nil {
+		return videoResolutionsMsg.NewId, err
+	}
+
+	// Write the poster image, placeholder and optional preview of the video.
+	preview := pkg.VideoPreviewFromMessage(videoResolutionsMsg.Preview)
+	if err = createVideoAssets(workerName, videoResolutionsMsg.NewId, videoResolutionsMsg.FilePath, preview, loudness, audioTracks, watermark, videoResolutionsMsg.Encryption, videoResolutionsMsg.Reprocess); err != nil {
+		return videoResolutionsMsg.NewId, err
+	}
+
+	// Publish the outputs, and archive the upload as the original of the video.
+	if err = finishJob(workerName, "video", videoResolutionsMsg.NewId, stagingStorage, videoResolutionsMsg.FilePath, videoResolutionsMsg.Reprocess); err != nil {
+		removeHLSKey(workerName, videoResolutionsMsg.NewId, videoResolutionsMsg.Encryption, videoResolutionsMsg.Reprocess)
+		return videoResolutionsMsg.NewId, err
+	}
+
 	return videoResolutionsMsg.NewId, nil
 }
 
@@ -167,16 +287,62 @@ func processImageMessage(workerName string, dlqMsg topics.DLQMessage) (string, e
 		return "", fmt.Errorf("error during message unmarshalling and validation: %s, %v", errMsg, err)
 	}
 
-	// Schedule the removal of the original image file after processing is complete.
-	defer removeFile(workerName, imageMsg.FilePath)
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the image.
+	var stagingStorage string
+	stagingStorage, imageMsg.FilePath, err = jobStorage(workerName, "image", imageMsg.NewId, imageMsg.FilePath, imageMsg.Upload, imageMsg.Reprocess)
+	if err != nil {
+		return imageMsg.NewId, err
+	}
+
+	// Remove the staging storage once the job completes, its outputs are published on success.
+	defer removeStaging(workerName, stagingStorage)
+
+	// Schedule the removal of the original image file after processing is complete, the archived original read by a reprocess job is kept.
+	if imageMsg.Reprocess == nil {
+		defer removeFile(workerName, imageMsg.FilePath)
+		defer removeUpload(workerName, imageMsg.Upload) // The upload handed off through the storage is removed as well.
+	}
+
+	// Resolve the watermark profile burned into the image, retrying would fail again.
+	watermark, err := pkg.WatermarkFromMessage(config.FailedConsumeEnv.WATERMARKS, imageMsg.Watermark)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("worker", workerName).
+			Msg("Invalid watermark profile")
+		return imageMsg.NewId, err
+	}
+
+	// Read the dimensions, orientation and camera metadata of the upload before they are stripped.
+	probe, err := pkg.ProbeImage(imageMsg.FilePath)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("worker", workerName).
+			Msg("Failed to probe image")
+		return imageMsg.NewId, fmt.Errorf("failed to probe image: %v", err)
+	}
+	source := pkg.ImageSourceFromProbe(probe, imageMsg.KeepCopyright)
+
+	// Reject animations with too many frames or a too long duration, retrying would fail again.
+	if err = config.FailedConsumeEnv.ANIMATION.Check(source); err != nil {
+		log.Error().
+			Err(err).
+			Str("worker", workerName).
+			Msg("Image animation exceeds the limits")
+		return imageMsg.NewId, fmt.Errorf("image animation exceeds the limits: %v", err)
+	}
+
+	// Resolve the output format, quality and size requested by the job.
+	image := pkg.ImageOptionsFromMessage(imageMsg, source, watermark)
 
 	// Construct the output path where the converted image will be saved.
-	outputPath := fmt.Sprintf("%s/images/%s.jpeg", helper.Constants.MediaStorage, imageMsg.NewId)
+	outputPath := fmt.Sprintf("%s/images/%s%s", stagingStorage, imageMsg.NewId, pkg.ImageExtension(image.Format))
 
 	// Attempt to process the image by executing the conversion command, retrying up to three times if necessary.
 	for i := 1; i <= 3; i++ {
 		// Call the image processing function, checking for successful conversion.
-		if err = pkg.ConvertImage(imageMsg.FilePath, outputPath, "1"); err == nil {
+		if err = pkg.ConvertImage(imageMsg.FilePath, outputPath, image); err == nil {
 			break // Exit the loop immediately if the conversion is successful.
 		}
 
@@ -196,6 +362,116 @@ func processImageMessage(workerName string, dlqMsg topics.DLQMessage) (string, e
 		}
 	}
 
+	// Compute the placeholder from the converted image, so it matches the orientation and crop of the served image.
+	// Animated WebP outputs can not be decoded by every ffmpeg build, the placeholder of animations uses the upload.
+	placeholderPath := outputPath
+	if image.Animated {
+		placeholderPath = imageMsg.FilePath
+	}
+	placeholder, err := pkg.CreatePlaceholder(placeholderPath)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("worker", workerName).
+			Msg("Failed to create image placeholder")
+		return imageMsg.NewId, fmt.Errorf("failed to create image placeholder: %v", err)
+	}
+
+	mediaDir := pkg.MediaDir(stagingStorage, "image", imageMsg.NewId)
+
+	// Write the GIF fallback into the media directory of the image, retrying up to three times if necessary.
+	// Only animated uploads need a fallback, still WebP images are decoded by the players without animated WebP support.
+	fallback := ""
+	if imageMsg.Fallback && image.Animated {
+		if err = createOutputDirectory(workerName, mediaDir); err != nil {
+			return imageMsg.NewId, err
+		}
+
+		fallbackImage := image
+		fallbackImage.Format = "gif"
+		for i := 1; i <= 3; i++ {
+			if err = pkg.ConvertImage(imageMsg.FilePath, mediaDir+"/"+pkg.ImageFallbackFileName, fallbackImage); err == nil {
+				break // Exit the loop immediately if the conversion is successful.
+			}
+
+			// On the last attempt (third), log the failure and return an error, the staging storage is removed by the deferred removeStaging.
+			if i == 3 {
+				log.Error().
+					Err(err).
+					Str("worker", workerName).
+					Msgf("Attempt %d failed for image fallback processing: %v", i, err)
+				return imageMsg.NewId, fmt.Errorf("failed to process image fallback after 3 attempts: %v", err)
+			} else {
+				// Log a warning if the attempt fails but is not the last one.
+				log.Warn().
+					Err(err).
+					Str("worker", workerName).
+					Msgf("Attempt %d failed for image fallback processing", i)
+			}
+		}
+		fallback = pkg.ImageFallbackFileName
+	}
+
+	// Write the responsive variants into the media directory of the image, retrying up to three times if necessary.
+	// Widths above the image are written with its width, the metadata records the written widths,
+	// and the requested files of these widths are linked to it.
+	requestedVariants := pkg.ImageVariantsFromMessage(imageMsg.Variants)
+	variants := pkg.ProducedImageVariants(requestedVariants, source.DisplayWidth())
+	if len(variants) > 0 {
+		if err = createOutputDirectory(workerName, mediaDir); err != nil {
+			return imageMsg.NewId, err
+		}
+
+		for i := 1; i <= 3; i++ {
+			if err = pkg.ConvertImageVariants(imageMsg.FilePath, mediaDir, image, variants); err == nil {
+				break // Exit the loop immediately if the conversion is successful.
+			}
+
+			// On the last attempt (third), log the failure and return an error, the staging storage is removed by the deferred removeStaging.
+			if i == 3 {
+				log.Error().
+					Err(err).
+					Str("worker", workerName).
+					Msgf("Attempt %d failed for image variants processing: %v", i, err)
+				return imageMsg.NewId, fmt.Errorf("failed to process image variants after 3 attempts: %v", err)
+			} else {
+				// Log a warning if the attempt fails but is not the last one.
+				log.Warn().
+					Err(err).
+					Str("worker", workerName).
+					Msgf("Attempt %d failed for image variants processing", i)
+			}
+		}
+
+		if err = pkg.LinkRequestedImageVariants(mediaDir, requestedVariants, source.DisplayWidth()); err != nil {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msg("Failed to link the requested image variants")
+			return imageMsg.NewId, fmt.Errorf("failed to link image variants: %v", err)
+		}
+	}
+
+	// Record the extension of the image, used to resolve the image for deletion and signed URLs.
+	if err = pkg.WriteMetadata(stagingStorage, &pkg.MediaMetadata{
+		ID:          imageMsg.NewId,
+		Type:        "image",
+		Extension:   pkg.ImageExtension(image.Format),
+		Variants:    variants,
+		Fallback:    fallback,
+		Source:      source,
+		Placeholder: placeholder,
+		Watermark:   watermark.ProfileName(),
+		CreatedAt:   time.Now(),
+	}); err != nil {
+		return imageMsg.NewId, fmt.Errorf("failed to write image metadata: %v", err)
+	}
+
+	// Publish the outputs, and archive the upload as the original of the image.
+	if err = finishJob(workerName, "image", imageMsg.NewId, stagingStorage, imageMsg.FilePath, imageMsg.Reprocess); err != nil {
+		return imageMsg.NewId, err
+	}
+
 	// Indicate successful processing by returning nil.
 	return imageMsg.NewId, nil
 }
@@ -212,20 +488,52 @@ func processAudioMessage(workerName string, dlqMsg topics.DLQMessage) (string, e
 		return "", fmt.Errorf("error during message unmarshalling and validation: %s, %v", errMsg, err)
 	}
 
-	// Schedule the removal of the original audio file after processing is complete.
-	defer removeFile(workerName, audioMsg.FilePath)
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the audio.
+	var stagingStorage string
+	stagingStorage, audioMsg.FilePath, err = jobStorage(workerName, "audio", audioMsg.NewId, audioMsg.FilePath, audioMsg.Upload, audioMsg.Reprocess)
+	if err != nil {
+		return audioMsg.NewId, err
+	}
+
+	// Remove the staging storage once the job completes, its outputs are published on success.
+	defer removeStaging(workerName, stagingStorage)
+
+	// Schedule the removal of the original audio file after processing is complete, the archived original read by a reprocess job is kept.
+	if audioMsg.Reprocess == nil {
+		defer removeFile(workerName, audioMsg.FilePath)
+		defer removeUpload(workerName, audioMsg.Upload) // The upload handed off through the storage is removed as well.
+	}
 
 	// Define the output path for the converted audio file.
-	outputPath := fmt.Sprintf("%s/audios/%s.mp3", helper.Constants.MediaStorage, audioMsg.NewId)
+	outputPath := fmt.Sprintf("%s/audios/%s.mp3", stagingStorage, audioMsg.NewId)
+	mediaDir := pkg.MediaDir(stagingStorage, "audio", audioMsg.NewId)
+
+	// Measure the loudness of the audio if the job normalizes it, every output is normalized with the same measurement.
+	loudness, err := measureLoudness(workerName, audioMsg.FilePath, audioMsg.NormalizeLoudness)
+	if err != nil {
+		return audioMsg.NewId, err
+	}
+
+	if err = createOutputDirectory(workerName, mediaDir); err != nil {
+		return audioMsg.NewId, err
+	}
+
+	// Read the tags of the upload and save its cover art into the media directory,
+	// the cover art is embedded into the outputs and used for the placeholder.
+	probe, placeholder, err := readAudioSource(workerName, audioMsg.FilePath, mediaDir+"/"+pkg.CoverArtFileName)
+	if err != nil {
+		return audioMsg.NewId, err
+	}
+
+	processing := pkg.AudioProcessing{Loudness: loudness, Tags: pkg.AudioTagsFromMessage(probe.Tags, audioMsg.Tags)}
+	if probe.CoverStream >= 0 {
+		processing.Cover = mediaDir + "/" + pkg.CoverArtFileName
+	}
 
 	// Attempt to execute the audio conversion command up to 3 times.
 	for i := 1; i <= 3; i++ {
 		// Execute the command for audio conversion using the provided bitrate, if available.
-		if audioMsg.Bitrate != nil {
-			err = pkg.ConvertAudio(audioMsg.FilePath, outputPath, *audioMsg.Bitrate)
-		} else {
-			err = pkg.ConvertAudio(audioMsg.FilePath, outputPath) // Call without bitrate
-		}
+		err = pkg.ConvertAudio(audioMsg.FilePath, outputPath, pkg.MainAudioOutput(audioMsg.Bitrate), processing)
 
 		// If the conversion is successful, exit the loop.
 		if err == nil {
@@ -248,6 +556,161 @@ func processAudioMessage(workerName string, dlqMsg topics.DLQMessage) (string, e
 		}
 	}
 
+	// Write the additional formats and bitrates, and the HLS audio renditions into the media directory of the audio,
+	// retrying up to three times if necessary.
+	outputs := pkg.AudioOutputsFromMessage(audioMsg.Outputs)
+	hlsPlaylist := ""
+	if len(outputs) > 0 || audioMsg.HLS != nil {
+		for i := 1; i <= 3; i++ {
+			err = nil
+			if len(outputs) > 0 {
+				err = pkg.ConvertAudioOutputs(audioMsg.FilePath, mediaDir, outputs, processing)
+			}
+			if err == nil && audioMsg.HLS != nil {
+				hls := config.FailedConsumeEnv.HLS.Merge(&topics.HLSOptions{SegmentDuration: audioMsg.HLS.SegmentDuration})
+				err = pkg.ConvertAudioHLS(audioMsg.FilePath, mediaDir+"/"+pkg.AudioHLSDir, audioMsg.HLS.Bitrates, hls, loudness)
+			}
+			if err == nil {
+				break // Exit the loop immediately if the conversion is successful.
+			}
+
+			// On the last attempt (third), log the failure and return an error, the staging storage is removed by the deferred removeStaging.
+			if i == 3 {
+				log.Error().
+					Err(err).
+					Str("worker", workerName).
+					Msgf("Attempt %d failed for audio outputs conversion: %v", i, err)
+				return audioMsg.NewId, fmt.Errorf("failed to convert audio outputs after 3 attempts: %v", err)
+			} else {
+				// Log a warning if the attempt fails but is not the last one.
+				log.Warn().
+					Err(err).
+					Str("worker", workerName).
+					Msgf("Attempt %d failed for audio outputs conversion", i)
+			}
+		}
+		if audioMsg.HLS != nil {
+			hlsPlaylist = pkg.AudioHLSPlaylist()
+		}
+	}
+
+	// Write the waveform peaks of the converted audio into the media directory of the audio, retrying up to three times if necessary.
+
+	waveform := pkg.WaveformFromMessage(audioMsg.Waveform)
+	for i := 1; i <= 3; i++ {
+		if err = pkg.CreateWaveform(outputPath, mediaDir+"/"+pkg.WaveformFileName, waveform); err == nil {
+			break // Exit the loop immediately if the waveform is written.
+		}
+
+		// On the last attempt (third), log the failure and return an error, the staging storage is removed by the deferred removeStaging.
+		if i == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for audio waveform creation: %v", i, err)
+			return audioMsg.NewId, fmt.Errorf("failed to create audio waveform after 3 attempts: %v", err)
+		} else {
+			// Log a warning if the attempt fails but is not the last one.
+			log.Warn().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for audio waveform creation", i)
+		}
+	}
+
+	// Record the extension, waveform, tags and cover art of the audio.
+	if err = pkg.WriteMetadata(stagingStorage, pkg.NewAudioMetadata(audioMsg.NewId, outputs, hlsPlaylist, processing, placeholder)); err != nil {
+		return audioMsg.NewId, fmt.Errorf("failed to write audio metadata: %v", err)
+	}
+
+	// Publish the outputs, and archive the upload as the original of the audio.
+	if err = finishJob(workerName, "audio", audioMsg.NewId, stagingStorage, audioMsg.FilePath, audioMsg.Reprocess); err != nil {
+		return audioMsg.NewId, err
+	}
+
 	// Return nil to indicate successful processing of the audio message.
 	return audioMsg.NewId, nil
 }
+
+// processCaptionMessage processes a caption message from the "failed-letter-queue".
+// It validates the message and attempts to add the caption track to the video up to 3 times,
+// logging warnings and errors as needed. A failed attempt leaves the existing tracks of the video unchanged.
+func processCaptionMessage(workerName string, dlqMsg topics.DLQMessage) (string, error) {
+	var captionMsg topics.CaptionMessage
+
+	// Unmarshal the Kafka message into the CaptionMessage struct and validate its contents.
+	errMsg, err := validator.UnmarshalAndValidate([]byte(dlqMsg.Value), &captionMsg)
+	if err != nil {
+		return "", fmt.Errorf("error during message unmarshalling and validation: %s, %v", errMsg, err)
+	}
+
+	// Schedule the removal of the subtitle file and its handed off copy after processing is complete.
+	defer removeFile(workerName, captionMsg.FilePath)
+	defer removeUpload(workerName, captionMsg.Upload)
+
+	// Fetch the subtitle file handed off by the server to its file path.
+	if captionMsg.Upload != "" {
+		if err = fetchUpload(workerName, captionMsg.Upload, captionMsg.FilePath); err != nil {
+			return captionMsg.NewId, err
+		}
+	}
+
+	track := pkg.CaptionTrack{Language: captionMsg.Language, Name: captionMsg.Name, Default: captionMsg.Default}
+
+	// A video in an object storage is read from and written to a work directory in the staging directory.
+	workStorage := jobStagingStorage(captionMsg.NewId, nil) + ".caption." + captionMsg.Language
+
+	// Attempt to add the caption track up to 3 times.
+	for i := 1; i <= 3; i++ {
+		err = pkg.AddStoredVideoCaption(config.FailedConsumeEnv.STORAGE, workStorage, captionMsg.NewId, captionMsg.FilePath, track, config.FailedConsumeEnv.HLS.SegmentDuration)
+		if err == nil {
+			break // Exit the loop immediately if the caption track is added.
+		}
+
+		// On the last attempt (third), log the failure and return an error.
+		if i == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for caption conversion: %v", i, err)
+			return captionMsg.NewId, fmt.Errorf("failed to convert caption after 3 attempts: %v", err)
+		} else {
+			// Log a warning if the attempt fails but is not the last one.
+			log.Warn().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for caption conversion", i)
+		}
+	}
+
+	// Return nil to indicate successful processing of the caption message.
+	return captionMsg.NewId, nil
+}
+
+// processClipMessage processes a clip message from the "failed-letter-queue".
+// It validates the message and attempts to cut the range of the existing media file into a new media file up to 3 times.
+func processClipMessage(workerName string, dlqMsg topics.DLQMessage) (string, error) {
+	var clipMsg topics.ClipMessage
+
+	// Unmarshal the Kafka message into the ClipMessage struct and validate its contents.
+	errMsg, err := validator.UnmarshalAndValidate([]byte(dlqMsg.Value), &clipMsg)
+	if err != nil {
+		return "", fmt.Errorf("error during message unmarshalling and validation: %s, %v", errMsg, err)
+	}
+
+	return clipMsg.NewId, createMediaEdit(workerName, clipMsg.NewId, clipMsg.MediaType, []topics.EditPart{clipMsg.Part}, clipMsg.HLS)
+}
+
+// processConcatMessage processes a concat message from the "failed-letter-queue".
+// It validates the message and attempts to join the ranges of the existing media files into a new media file up to 3 times.
+func processConcatMessage(workerName string, dlqMsg topics.DLQMessage) (string, error) {
+	var concatMsg topics.ConcatMessage
+
+	// Unmarshal the Kafka message into the ConcatMessage struct and validate its contents.
+	errMsg, err := validator.UnmarshalAndValidate([]byte(dlqMsg.Value), &concatMsg)
+	if err != nil {
+		return "", fmt.Errorf("error during message unmarshalling and validation: %s, %v", errMsg, err)
+	}
+
+	return concatMsg.NewId, createMediaEdit(workerName, concatMsg.NewId, concatMsg.MediaType, concatMsg.Parts, concatMsg.HLS)
+}
@@ -2,8 +2,10 @@
 package process
 
 import (
+	"github.com/nvj9singhnavjot/media-docker/config"
 	"github.com/nvj9singhnavjot/media-docker/kafkahandler"
 	"github.com/nvj9singhnavjot/media-docker/logger"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
 	"github.com/nvj9singhnavjot/media-docker/topics"
 	"github.com/nvj9singhnavjot/media-docker/validator"
 	"github.com/rs/zerolog/log"
@@ -22,6 +24,9 @@ var topicHandlers = map[string]topicHandler{
 	"video-resolutions": {fileType: "videoResolutions", processFunc: processVideoResolutionsMessage}, // Handler for video-resolutions topic.
 	"image":             {fileType: "image", processFunc: processImageMessage},                       // Handler for image topic.
 	"audio":             {fileType: "audio", processFunc: processAudioMessage},                       // Handler for audio topic.
+	"caption":           {fileType: "caption", processFunc: processCaptionMessage},                   // Handler for caption topic.
+	"clip":              {fileType: "clip", processFunc: processClipMessage},                         // Handler for clip topic.
+	"concat":            {fileType: "concat", processFunc: processConcatMessage},                     // Handler for concat topic.
 }
 
 // ProcessMessage processes the Kafka message based on its topic.
@@ -48,7 +53,7 @@ func ProcessMessage(msg kafka.Message, workerName string) {
 		if exists {
 			// Log the error, record the failed message processing, and send a failed response.
 			logger.LogErrorWithKafkaMessage(err, workerName, msg, errmsg+" DLQMessage")
-			kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "failed")
+			kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "failed", nil)
 			return
 		}
 	}
@@ -91,7 +96,8 @@ func handleDLQMessage(dlqMsg topics.DLQMessage, workerName string) {
 			Interface("dlq_message", dlqMsg).
 			Msg("DLQMessage processing completed successfully.")
 		// Send a success response to the consumer indicating the message processing is completed.
-		kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "completed")
+		kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "completed",
+			pkg.ReadPlaceholder(config.FailedConsumeEnv.STORAGE, handler.fileType, newId)) // Placeholder recorded by the handler, if any
 		return
 	}
 
@@ -121,5 +127,5 @@ func handleDLQMessage(dlqMsg topics.DLQMessage, workerName string) {
 		Interface("dlq_message", dlqMsg).
 		Msg("Failed to process DLQMessage.")
 	// Send a failure response to the consumer indicating that the processing has failed.
-	kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "failed")
+	kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "failed", nil)
 }
@@ -2,12 +2,15 @@ package process
 
 import (
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
+	"github.com/nvj9singhnavjot/media-docker/config"
+	"github.com/nvj9singhnavjot/media-docker/helper"
 	"github.com/nvj9singhnavjot/media-docker/pkg"
+	"github.com/nvj9singhnavjot/media-docker/topics"
 	"github.com/rs/zerolog/log"
 )
 
@@ -19,8 +22,8 @@ func removeFile(workerName, path string) {
 	for i := 1; i <= 3; i++ {
 		// Attempt to remove the file at the specified path.
 		err := os.Remove(path)
-		if err == nil {
-			// If the file is successfully deleted, return immediately.
+		if err == nil || os.IsNotExist(err) {
+			// If the file is successfully deleted or was already moved (e.g. archived as original), return immediately.
 			return
 		}
 
@@ -45,14 +48,14 @@ func removeFile(workerName, path string) {
 	}
 }
 
-// RemoveDir attempts to remove the specified directory.
+// removeStaging attempts to remove the staging storage of a job with its outputs, see jobStagingStorage.
 // It makes up to 3 attempts to delete the directory, logging warnings on failure
 // and retrying after a 2-second delay between attempts. If the directory cannot
 // be removed after 3 attempts, it logs an error.
-func RemoveDir(workerName, outputPath string) {
+func removeStaging(workerName, stagingStorage string) {
 	for i := 1; i <= 3; i++ {
-		// Attempt to remove the directory at the specified path.
-		err := os.Remove(outputPath)
+		// Attempt to remove the staging storage and everything in it.
+		err := os.RemoveAll(stagingStorage)
 		if err == nil {
 			// If the directory is successfully deleted, return immediately.
 			return
@@ -64,7 +67,7 @@ func RemoveDir(workerName, outputPath string) {
 			log.Warn().
 				Err(err).
 				Str("worker", workerName).
-				Msgf("Attempt %d to delete directory %s failed. Retrying in 2 seconds...", i, outputPath)
+				Msgf("Attempt %d to delete staging directory %s failed. Retrying in 2 seconds...", i, stagingStorage)
 
 			// Wait for 2 seconds before retrying.
 			time.Sleep(2 * time.Second)
@@ -73,8 +76,8 @@ func RemoveDir(workerName, outputPath string) {
 			log.Error().
 				Err(err).
 				Str("worker", workerName).
-				Str("dirPath", outputPath).
-				Msg("Failed to delete directory after 3 attempts")
+				Str("dirPath", stagingStorage).
+				Msg("Failed to delete staging directory after 3 attempts")
 		}
 	}
 }
@@ -114,38 +117,16 @@ func createOutputDirectory(workerName, outputPath string) error {
 	return nil
 }
 
-// cleanupOutputDirectory attempts to remove all files and subdirectories within the specified outputPath.
-// It does not remove the outputPath itself. The cleanup process is retried up to 3 times in case of failure.
+// cleanupOutputDirectory removes the partial outputs of a failed attempt by removing the outputPath in the staging storage
+// of the job and creating it again. Nothing in the staging storage is served, so no file has to be kept.
+// The cleanup process is retried up to 3 times in case of failure.
 func cleanupOutputDirectory(workerName, outputPath string) error {
 	// Retry the cleanup process for a maximum of 3 attempts.
 	for attempt := 1; attempt <= 3; attempt++ {
-		// Walk through the directory to delete files and subdirectories.
-		err := filepath.Walk(outputPath, func(path string, info fs.FileInfo, err error) error {
-			if err != nil {
-				return err // Return immediately if an error occurs while walking the directory.
-			}
-
-			// Skip the outputPath directory itself but continue cleaning its contents.
-			if path == outputPath {
-				return nil // Continue walking without deleting the root outputPath.
-			}
-
-			// Attempt to remove the file or directory.
-			if info.IsDir() {
-				// Remove the directory and its contents.
-				err = os.RemoveAll(path)
-				if err != nil {
-					return fmt.Errorf("failed to remove directory %s: %v", path, err)
-				}
-			} else {
-				// Remove the file.
-				err = os.Remove(path)
-				if err != nil {
-					return fmt.Errorf("failed to remove file %s: %v", path, err)
-				}
-			}
-			return nil
-		})
+		err := os.RemoveAll(outputPath)
+		if err == nil {
+			err = pkg.CreateDir(outputPath)
+		}
 
 		if err == nil {
 			// If no error occurred, the cleanup was successful. Return immediately.
@@ -173,3 +154,454 @@ func cleanupOutputDirectory(workerName, outputPath string) error {
 	// This point will not be reached, since the function either returns success or an error after 3 attempts.
 	return nil
 }
+
+// archiveOriginal archives the upload of a processed media file as its original if KEEP_ORIGINALS keeps the media type,
+// retrying up to three times. Uploads that are not archived are removed by the deferred removeFile of the handler.
+func archiveOriginal(workerName, mediaType, id, uploadPath string) error {
+	if !slices.Contains(config.FailedConsumeEnv.KEEP_ORIGINALS, mediaType) {
+		return nil
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		_, err := pkg.ArchiveOriginal(config.FailedConsumeEnv.STORAGE, helper.Constants.OriginalStorage, mediaType, id, uploadPath)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for archiving the original %s", attempt, mediaType)
+			return fmt.Errorf("failed to archive original after 3 attempts: %v", err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for archiving the original %s", attempt, mediaType)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil
+}
+
+// jobStagingStorage returns the staging storage a job writes into, see pkg.StagingStorage.
+// The ".retry" suffix keeps it apart from the staging storage of the failed attempt of the kafka consumer,
+// whose deletion may still be pending.
+func jobStagingStorage(id string, reprocess *topics.Reprocess) string {
+	return pkg.StagingStorage(helper.Constants.MediaStorage, id, reprocess) + ".retry"
+}
+
+// jobStorage returns the staging storage a job writes into, see jobStagingStorage, and the path of its source.
+// Reprocess jobs read the archived original of the media file, whose checksum is verified, retrying would fail again.
+// Other jobs read their upload at filePath. An upload handed off through the storage (see pkg.HandOffUpload),
+// or an original archived in it, is fetched into the staging storage. The outputs left by an earlier attempt
+// are removed, so every job starts from an empty staging storage.
+func jobStorage(workerName, mediaType, id, filePath, upload string, reprocess *topics.Reprocess) (string, string, error) {
+	stagingStorage := jobStagingStorage(id, reprocess)
+	if err := cleanupOutputDirectory(workerName, stagingStorage); err != nil {
+		return "", "", err
+	}
+
+	// Create the media directory, so the served file of images and audios can be written next to it.
+	if err := createOutputDirectory(workerName, pkg.MediaDir(stagingStorage, mediaType, id)); err != nil {
+		return "", "", err
+	}
+
+	sourcePath := filePath
+	if reprocess != nil {
+		_, originalPath, err := pkg.ReadOriginal(config.FailedConsumeEnv.STORAGE, helper.Constants.OriginalStorage, stagingStorage, mediaType, id)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Failed to read the original %s", mediaType)
+			removeStaging(workerName, stagingStorage)
+			return "", "", fmt.Errorf("failed to read original: %v", err)
+		}
+		sourcePath = originalPath
+	}
+
+	// The upload handed off by the server is read from its copy in the staging storage.
+	if reprocess == nil && upload != "" {
+		sourcePath = filepath.Join(stagingStorage, filepath.Base(filePath))
+		if err := fetchUpload(workerName, upload, sourcePath); err != nil {
+			removeStaging(workerName, stagingStorage)
+			return "", "", err
+		}
+	}
+	return stagingStorage, sourcePath, nil
+}
+
+// fetchUpload downloads the upload handed off through the storage at key into the file target, retrying up to three times.
+func fetchUpload(workerName, key, target string) error {
+	for attempt := 1; attempt <= 3; attempt++ {
+		err := pkg.FetchObject(config.FailedConsumeEnv.STORAGE, key, target)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for fetching the upload %s", attempt, key)
+			return fmt.Errorf("failed to fetch upload after 3 attempts: %v", err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for fetching the upload %s", attempt, key)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil
+}
+
+// removeUpload removes the upload handed off through the storage at key (see pkg.HandOffUpload) once its job completes,
+// the failed consumer is the last attempt of the job. Nothing is removed for an empty key.
+// It makes up to 3 attempts, logging warnings on failure and retrying after a 2-second delay between attempts.
+// If the upload cannot be removed after 3 attempts, it logs an error.
+func removeUpload(workerName, key string) {
+	for i := 1; i <= 3; i++ {
+		err := pkg.RemoveUpload(config.FailedConsumeEnv.STORAGE, key)
+		if err == nil {
+			return
+		}
+
+		if i < 3 {
+			log.Warn().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d to delete upload %s failed. Retrying in 2 seconds...", i, key)
+			time.Sleep(2 * time.Second)
+		} else {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Str("key", key).
+				Msg("Failed to delete upload after 3 attempts")
+		}
+	}
+}
+
+// publishOutputs publishes the outputs of a job from its staging storage, see pkg.PublishOutputs, retrying up to three times.
+func publishOutputs(workerName, stagingStorage, mediaType, id string, reprocess *topics.Reprocess) error {
+	for attempt := 1; attempt <= 3; attempt++ {
+		err := pkg.PublishOutputs(config.FailedConsumeEnv.STORAGE, stagingStorage, mediaType, id, reprocess)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for publishing the %s", attempt, mediaType)
+			return fmt.Errorf("failed to publish %s after 3 attempts: %v", mediaType, err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for publishing the %s", attempt, mediaType)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil
+}
+
+// finishJob publishes the outputs of a job from its staging storage, see publishOutputs, and archives the upload
+// of the processed media file as its original, see archiveOriginal. The original of a reprocess job is kept.
+// The outputs are moved back into the staging storage if archiving fails, so the media file is not served
+// while its job is reported as failed.
+func finishJob(workerName, mediaType, id, stagingStorage, uploadPath string, reprocess *topics.Reprocess) error {
+	if err := publishOutputs(workerName, stagingStorage, mediaType, id, reprocess); err != nil {
+		return err
+	}
+	if reprocess != nil {
+		return nil
+	}
+
+	if err := archiveOriginal(workerName, mediaType, id, uploadPath); err != nil {
+		if unpublishErr := pkg.UnpublishOutputs(config.FailedConsumeEnv.STORAGE, stagingStorage, mediaType, id); unpublishErr != nil {
+			log.Error().
+				Err(unpublishErr).
+				Str("worker", workerName).
+				Msgf("Failed to unpublish the %s", mediaType)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeHLSKey removes the AES-128 key of a video whose conversion failed, if encryption was requested,
+// from the key directory and the storage (see pkg.DeleteHLSKey).
+// The key of a reprocessed video is kept, as the served version of the video uses it.
+// It makes up to 3 attempts, logging warnings on failure and retrying after a 2-second delay between attempts.
+// If the key cannot be removed after 3 attempts, it logs an error.
+func removeHLSKey(workerName, id string, encryption *string, reprocess *topics.Reprocess) {
+	if encryption == nil || reprocess != nil {
+		return
+	}
+	for i := 1; i <= 3; i++ {
+		err := pkg.DeleteHLSKey(config.FailedConsumeEnv.STORAGE, helper.Constants.KeyStorage, id)
+		if err == nil {
+			return
+		}
+
+		if i < 3 {
+			log.Warn().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d to delete hls key of video %s failed. Retrying in 2 seconds...", i, id)
+			time.Sleep(2 * time.Second)
+		} else {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Str("id", id).
+				Msg("Failed to delete hls key after 3 attempts")
+		}
+	}
+}
+
+// createVideoAssets writes the poster image, placeholder and optional preview of a converted video
+// into the staging storage of the job (see jobStagingStorage), retrying up to three times. If the last attempt fails,
+// the AES-128 key of the video is removed, as the video is reported as failed.
+func createVideoAssets(workerName, id, videoPath string, preview *pkg.VideoPreviewOptions, loudness *pkg.Loudness, audioTracks []pkg.VideoAudioTrack, watermark *pkg.WatermarkProfile, encryption *string, reprocess *topics.Reprocess) error {
+	stagingStorage := jobStagingStorage(id, reprocess)
+	for attempt := 1; attempt <= 3; attempt++ {
+		err := pkg.CreateVideoAssets(stagingStorage, id, videoPath, preview, loudness, audioTracks, watermark)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for video poster and preview creation", attempt)
+			removeHLSKey(workerName, id, encryption, reprocess)
+			return fmt.Errorf("failed to create video poster and preview after 3 attempts: %v", err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for video poster and preview creation", attempt)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil
+}
+
+// measureLoudness measures the loudness of the media file for the loudness normalization of a job,
+// retrying up to three times. It returns nil without an error if the job does not normalize the loudness.
+func measureLoudness(workerName, path string, options *topics.LoudnessOptions) (*pkg.Loudness, error) {
+	for attempt := 1; attempt <= 3; attempt++ {
+		loudness, err := pkg.MeasureLoudness(path, options)
+		if err == nil {
+			return loudness, nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for loudness measurement", attempt)
+			return nil, fmt.Errorf("failed to measure loudness after 3 attempts: %v", err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for loudness measurement", attempt)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil, nil
+}
+
+// probeVideoAudioTracks reads the audio streams of a video kept as alternate audio renditions by the job,
+// retrying up to three times. It returns nil without an error if the job requests no audio tracks.
+func probeVideoAudioTracks(workerName, videoPath string, selection *topics.AudioTracks, loudness *topics.LoudnessOptions) ([]pkg.VideoAudioTrack, error) {
+	for attempt := 1; attempt <= 3; attempt++ {
+		tracks, err := pkg.ProbeVideoAudioTracks(videoPath, selection, loudness)
+		if err == nil {
+			return tracks, nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for video audio tracks probe", attempt)
+			return nil, fmt.Errorf("failed to probe video audio tracks after 3 attempts: %v", err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for video audio tracks probe", attempt)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil, nil
+}
+
+// convertVideoAudioTracks converts the alternate audio tracks of a video into its media directory in the staging storage of the job,
+// retrying up to three times.
+// Encrypted videos encrypt the tracks with the key of the video, whose URI is two directories above the track playlists.
+// If the last attempt fails, the AES-128 key of the video is removed, as the video is reported as failed.
+func convertVideoAudioTracks(workerName, id, videoPath string, tracks []pkg.VideoAudioTrack, hls pkg.HLSOptions, encryption *string, reprocess *topics.Reprocess) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+
+	outputPath := pkg.MediaDir(jobStagingStorage(id, reprocess), "video", id)
+
+	if encryption != nil {
+		keyInfoFile, err := pkg.WriteRenditionKeyInfo(helper.Constants.KeyStorage, id, pkg.VideoAudioDir, "../../"+pkg.HLSKeyName)
+		if err != nil {
+			removeHLSKey(workerName, id, encryption, reprocess)
+			return err
+		}
+		// Ensure the removal of the key info file, as it is only needed during conversion.
+		defer removeFile(workerName, keyInfoFile)
+		hls.KeyInfoFile = keyInfoFile
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		err := pkg.ConvertVideoAudioTracks(videoPath, outputPath, tracks, hls)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for video audio tracks conversion", attempt)
+			removeHLSKey(workerName, id, encryption, reprocess)
+			return fmt.Errorf("failed to convert video audio tracks after 3 attempts: %v", err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for video audio tracks conversion", attempt)
+
+		// Remove the partially written tracks before retrying.
+		if err = os.RemoveAll(filepath.Join(outputPath, pkg.VideoAudioDir)); err != nil {
+			return fmt.Errorf("failed to remove video audio tracks: %v", err)
+		}
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil
+}
+
+// readAudioSource reads the tags and cover art of an audio upload, retrying up to three times.
+// If the upload has cover art, it is saved to coverPath and its placeholder is returned.
+func readAudioSource(workerName, audioPath, coverPath string) (*pkg.AudioProbe, *topics.Placeholder, error) {
+	for attempt := 1; attempt <= 3; attempt++ {
+		probe, placeholder, err := readAudioSourceOnce(audioPath, coverPath)
+		if err == nil {
+			return probe, placeholder, nil
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for audio tags and cover art extraction", attempt)
+			return nil, nil, fmt.Errorf("failed to read audio tags and cover art after 3 attempts: %v", err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for audio tags and cover art extraction", attempt)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil, nil, nil
+}
+
+// readAudioSourceOnce is a single attempt of readAudioSource.
+func readAudioSourceOnce(audioPath, coverPath string) (*pkg.AudioProbe, *topics.Placeholder, error) {
+	probe, err := pkg.ProbeAudio(audioPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if probe.CoverStream < 0 {
+		return probe, nil, nil
+	}
+
+	if err := pkg.ExtractCoverArt(audioPath, probe.CoverStream, coverPath); err != nil {
+		return nil, nil, err
+	}
+	placeholder, err := pkg.CreatePlaceholder(coverPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return probe, placeholder, nil
+}
+
+// createMediaEdit writes the new video or audio id from the ranges of existing media files into the staging storage
+// of the job, retrying up to three times, and publishes it. The partial output of a failed attempt is removed before retrying,
+// and the staging storage is removed once the job completes. Sources in an object storage are downloaded
+// next to the staging storage first.
+func createMediaEdit(workerName, id, mediaType string, parts []topics.EditPart, jobHLS *topics.HLSOptions) error {
+	stagingStorage := jobStagingStorage(id, nil)
+	defer removeStaging(workerName, stagingStorage)
+	defer removeStaging(workerName, stagingStorage+".sources")
+
+	editParts := pkg.EditPartsFromMessage(parts)
+	for attempt := 1; attempt <= 3; attempt++ {
+		// Remove the partial output of an earlier attempt.
+		if err := cleanupOutputDirectory(workerName, stagingStorage); err != nil {
+			return err
+		}
+
+		sourceStorage, err := pkg.FetchEditSources(config.FailedConsumeEnv.STORAGE, stagingStorage+".sources", mediaType, editParts)
+		if err == nil {
+			if mediaType == "video" {
+				err = pkg.CreateVideoEdit(sourceStorage, stagingStorage, id, editParts, config.FailedConsumeEnv.HLS.Merge(jobHLS))
+			} else {
+				err = pkg.CreateAudioEdit(sourceStorage, stagingStorage, id, editParts)
+			}
+		}
+		if err == nil {
+			return publishOutputs(workerName, stagingStorage, mediaType, id, nil)
+		}
+
+		if attempt == 3 {
+			log.Error().
+				Err(err).
+				Str("worker", workerName).
+				Msgf("Attempt %d failed for %s edit", attempt, mediaType)
+			return fmt.Errorf("failed to edit %s after 3 attempts: %v", mediaType, err)
+		}
+
+		// Log a warning if the attempt fails but is not the last one.
+		log.Warn().
+			Err(err).
+			Str("worker", workerName).
+			Msgf("Attempt %d failed for %s edit", attempt, mediaType)
+	}
+
+	// This point will not be reached, since the function either returns success or an error after 3 attempts.
+	return nil
+}
@@ -3,13 +3,18 @@ package process
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"time"
 
 	"github.com/nvj9singhnavjot/media-docker/api"
+	"github.com/nvj9singhnavjot/media-docker/config"
 	"github.com/nvj9singhnavjot/media-docker/helper"
 	"github.com/nvj9singhnavjot/media-docker/logger"
 	"github.com/nvj9singhnavjot/media-docker/pkg"
 	"github.com/nvj9singhnavjot/media-docker/topics"
 	"github.com/nvj9singhnavjot/media-docker/validator"
+	"github.com/rs/zerolog/log"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -23,27 +28,80 @@ func processVideoMessage(kafkaMsg []byte) (string, string, error) {
 		return "", errMsg + " VideoMessage", err
 	}
 
-	outputPath := fmt.Sprintf("%s/videos/%s", helper.Constants.MediaStorage, videoMsg.NewId)
+	// Resolve the watermark profile burned into the video
+	watermark, err := pkg.WatermarkFromMessage(config.KafkaConsumeEnv.WATERMARKS, videoMsg.Watermark)
+	if err != nil {
+		return videoMsg.NewId, "Invalid watermark profile", err
+	}
+
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the video
+	var stagingStorage string
+	stagingStorage, videoMsg.FilePath, err = jobStorage("video", videoMsg.NewId, videoMsg.FilePath, videoMsg.Upload, videoMsg.Reprocess)
+	if err != nil {
+		return videoMsg.NewId, "Error creating staging directory, fetching upload or reading original video", err
+	}
+
+	// The staged outputs are published on success, the staging storage is removed either way
+	defer pkg.AddToDirDeleteChan(stagingStorage)
+
+	outputPath := fmt.Sprintf("%s/videos/%s", stagingStorage, videoMsg.NewId)
 
 	// Create the output directory
 	if err = pkg.CreateDir(outputPath); err != nil {
 		return videoMsg.NewId, "Error creating output directory", err
 	}
 
+	// Apply the job HLS overrides on top of the global HLS options
+	hls := config.KafkaConsumeEnv.HLS.Merge(videoMsg.HLS)
+
+	// Create the AES-128 key and the key info file if encryption is requested
+	if videoMsg.Encryption != nil {
+		hls.KeyInfoFile, err = pkg.PrepareHLSEncryption(config.KafkaConsumeEnv.STORAGE, helper.Constants.KeyStorage, videoMsg.NewId)
+		if err != nil {
+			return videoMsg.NewId, "Error preparing video encryption", err
+		}
+		defer pkg.AddToFileDeleteChan(hls.KeyInfoFile) // Key info file is only needed during conversion
+	}
+
+	// Measure the loudness of the audio track if the job normalizes it
+	loudness, err := pkg.MeasureLoudness(videoMsg.FilePath, videoMsg.NormalizeLoudness)
+	if err != nil {
+		return videoMsg.NewId, "Video loudness measurement failed", err
+	}
+
+	// Read the audio streams kept as alternate audio renditions, each measured if the job normalizes the loudness
+	audioTracks, err := pkg.ProbeVideoAudioTracks(videoMsg.FilePath, videoMsg.AudioTracks, videoMsg.NormalizeLoudness)
+	if err != nil {
+		return videoMsg.NewId, "Video audio tracks probe failed", err
+	}
+
 	// Execute the command for video conversion based on the quality
 	if videoMsg.Quality != nil {
 		// Use provided quality
-		err = pkg.ConvertVideo(videoMsg.FilePath, outputPath, *videoMsg.Quality)
+		err = pkg.ConvertVideo(videoMsg.FilePath, outputPath, hls, loudness, watermark, *videoMsg.Quality)
 	} else {
 		// Use default quality
-		err = pkg.ConvertVideo(videoMsg.FilePath, outputPath)
+		err = pkg.ConvertVideo(videoMsg.FilePath, outputPath, hls, loudness, watermark)
 	}
 	if err != nil {
-		pkg.AddToDirDeleteChan(outputPath) // Schedule directory for deletion on error
 		return videoMsg.NewId, "Video conversion failed", err
 	}
 
-	pkg.AddToFileDeleteChan(videoMsg.FilePath) // Ensure file is scheduled for deletion
+	// Convert the alternate audio tracks next to the video
+	if err = convertVideoAudioTracks(videoMsg.NewId, videoMsg.FilePath, outputPath, audioTracks, hls, videoMsg.Encryption); err != nil {
+		return videoMsg.NewId, "Video audio tracks conversion failed", err
+	}
+
+	// Write the poster image, placeholder and optional preview of the video
+	preview := pkg.VideoPreviewFromMessage(videoMsg.Preview)
+	if err = pkg.CreateVideoAssets(stagingStorage, videoMsg.NewId, videoMsg.FilePath, preview, loudness, audioTracks, watermark); err != nil {
+		return videoMsg.NewId, "Video poster or preview creation failed", err
+	}
+
+	// Publish the outputs, and archive the upload as the original of the video
+	if err = finishJob("video", videoMsg.NewId, stagingStorage, videoMsg.FilePath, videoMsg.Upload, videoMsg.Reprocess); err != nil {
+		return videoMsg.NewId, "Error publishing video or archiving original", err
+	}
 
 	// Return success: new ID and a success message
 	return videoMsg.NewId, "Video conversion completed successfully", nil
@@ -59,12 +117,28 @@ func processVideoResolutionsMessage(kafkaMsg []byte) (string, string, error) {
 		return "", errMsg + " VideoResolutionsMessage", err
 	}
 
+	// Resolve the watermark profile burned into every resolution
+	watermark, err := pkg.WatermarkFromMessage(config.KafkaConsumeEnv.WATERMARKS, videoResolutionsMsg.Watermark)
+	if err != nil {
+		return videoResolutionsMsg.NewId, "Invalid watermark profile", err
+	}
+
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the video
+	var stagingStorage string
+	stagingStorage, videoResolutionsMsg.FilePath, err = jobStorage("video", videoResolutionsMsg.NewId, videoResolutionsMsg.FilePath, videoResolutionsMsg.Upload, videoResolutionsMsg.Reprocess)
+	if err != nil {
+		return videoResolutionsMsg.NewId, "Error creating staging directory, fetching upload or reading original video", err
+	}
+
+	// The staged outputs are published on success, the staging storage is removed either way
+	defer pkg.AddToDirDeleteChan(stagingStorage)
+
 	// Prepare the output directories for each resolution
 	outputPaths := map[string]string{
-		"360":  fmt.Sprintf("%s/videos/%s/360", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
-		"480":  fmt.Sprintf("%s/videos/%s/480", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
-		"720":  fmt.Sprintf("%s/videos/%s/720", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
-		"1080": fmt.Sprintf("%s/videos/%s/1080", helper.Constants.MediaStorage, videoResolutionsMsg.NewId),
+		"360":  fmt.Sprintf("%s/videos/%s/360", stagingStorage, videoResolutionsMsg.NewId),
+		"480":  fmt.Sprintf("%s/videos/%s/480", stagingStorage, videoResolutionsMsg.NewId),
+		"720":  fmt.Sprintf("%s/videos/%s/720", stagingStorage, videoResolutionsMsg.NewId),
+		"1080": fmt.Sprintf("%s/videos/%s/1080", stagingStorage, videoResolutionsMsg.NewId),
 	}
 
 	// Create the output directories
@@ -72,16 +146,62 @@ func processVideoResolutionsMessage(kafkaMsg []byte) (string, string, error) {
 		return videoResolutionsMsg.NewId, "Error creating output directories", err
 	}
 
+	// Apply the job HLS overrides on top of the global HLS options
+	hls := config.KafkaConsumeEnv.HLS.Merge(videoResolutionsMsg.HLS)
+
+	// Create the AES-128 key once, all resolutions are encrypted with the same key
+	if videoResolutionsMsg.Encryption != nil {
+		if err = pkg.CreateHLSKey(config.KafkaConsumeEnv.STORAGE, helper.Constants.KeyStorage, videoResolutionsMsg.NewId); err != nil {
+			return videoResolutionsMsg.NewId, "Error preparing video encryption", err
+		}
+	}
+
+	// Measure the loudness of the audio track once, all resolutions are normalized with the same measurement
+	loudness, err := pkg.MeasureLoudness(videoResolutionsMsg.FilePath, videoResolutionsMsg.NormalizeLoudness)
+	if err != nil {
+		return videoResolutionsMsg.NewId, "Video loudness measurement failed", err
+	}
+
+	// Read the audio streams kept as alternate audio renditions, each measured if the job normalizes the loudness
+	audioTracks, err := pkg.ProbeVideoAudioTracks(videoResolutionsMsg.FilePath, videoResolutionsMsg.AudioTracks, videoResolutionsMsg.NormalizeLoudness)
+	if err != nil {
+		return videoResolutionsMsg.NewId, "Video audio tracks probe failed", err
+	}
+
 	// Assume outputPaths is a map with resolution as key and output path as value
 	for res, outputPath := range outputPaths {
+		// Write the key info file of the resolution, its playlist is one directory below the key URI
+		resHLS := hls
+		if videoResolutionsMsg.Encryption != nil {
+			resHLS.KeyInfoFile, err = pkg.WriteRenditionKeyInfo(helper.Constants.KeyStorage, videoResolutionsMsg.NewId, res, "../"+pkg.HLSKeyName)
+			if err != nil {
+				return videoResolutionsMsg.NewId, "Error preparing video encryption for resolution " + res, err
+			}
+			defer pkg.AddToFileDeleteChan(resHLS.KeyInfoFile) // Key info file is only needed during conversion
+		}
+
 		// Execute the command and check for errors
-		if err = pkg.ConvertVideoResolutions(videoResolutionsMsg.FilePath, outputPath, res); err != nil {
-			pkg.AddToDirDeleteChan(fmt.Sprintf("%s/videos/%s", helper.Constants.MediaStorage, videoResolutionsMsg.NewId))
+		if err = pkg.ConvertVideoResolutions(videoResolutionsMsg.FilePath, outputPath, res, resHLS, loudness, watermark); err != nil {
 			return videoResolutionsMsg.NewId, "Video conversion failed for resolution " + res, err
 		}
 	}
 
-	pkg.AddToFileDeleteChan(videoResolutionsMsg.FilePath) // Ensure file is scheduled for deletion
+	// Convert the alternate audio tracks once, all resolutions share them
+	videoPath := fmt.Sprintf("%s/videos/%s", stagingStorage, videoResolutionsMsg.NewId)
+	if err = convertVideoAudioTracks(videoResolutionsMsg.NewId, videoResolutionsMsg.FilePath, videoPath, audioTracks, hls, videoResolutionsMsg.Encryption); err != nil {
+		return videoResolutionsMsg.NewId, "Video audio tracks conversion failed", err
+	}
+
+	// Write the poster image, placeholder and optional preview of the video
+	preview := pkg.VideoPreviewFromMessage(videoResolutionsMsg.Preview)
+	if err = pkg.CreateVideoAssets(stagingStorage, videoResolutionsMsg.NewId, videoResolutionsMsg.FilePath, preview, loudness, audioTracks, watermark); err != nil {
+		return videoResolutionsMsg.NewId, "Video poster or preview creation failed", err
+	}
+
+	// Publish the outputs, and archive the upload as the original of the video
+	if err = finishJob("video", videoResolutionsMsg.NewId, stagingStorage, videoResolutionsMsg.FilePath, videoResolutionsMsg.Upload, videoResolutionsMsg.Reprocess); err != nil {
+		return videoResolutionsMsg.NewId, "Error publishing video or archiving original", err
+	}
 
 	// Return success: new ID and success message
 	return videoResolutionsMsg.NewId, "Video resolution conversion completed successfully", nil
@@ -97,14 +217,107 @@ func processImageMessage(kafkaMsg []byte) (string, string, error) {
 		return "", errMsg + " ImageMessage", err
 	}
 
-	outputPath := fmt.Sprintf("%s/images/%s.jpeg", helper.Constants.MediaStorage, imageMsg.NewId)
+	// Resolve the watermark profile burned into the image
+	watermark, err := pkg.WatermarkFromMessage(config.KafkaConsumeEnv.WATERMARKS, imageMsg.Watermark)
+	if err != nil {
+		return imageMsg.NewId, "Invalid watermark profile", err
+	}
+
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the image
+	var stagingStorage string
+	stagingStorage, imageMsg.FilePath, err = jobStorage("image", imageMsg.NewId, imageMsg.FilePath, imageMsg.Upload, imageMsg.Reprocess)
+	if err != nil {
+		return imageMsg.NewId, "Error creating staging directory, fetching upload or reading original image", err
+	}
+
+	// The staged outputs are published on success, the staging storage is removed either way
+	defer pkg.AddToDirDeleteChan(stagingStorage)
+
+	// Read the dimensions, orientation and camera metadata of the upload before they are stripped
+	probe, err := pkg.ProbeImage(imageMsg.FilePath)
+	if err != nil {
+		return imageMsg.NewId, "Image probe failed", err
+	}
+	source := pkg.ImageSourceFromProbe(probe, imageMsg.KeepCopyright)
+
+	// Reject animations with too many frames or a too long duration
+	if err = config.KafkaConsumeEnv.ANIMATION.Check(source); err != nil {
+		return imageMsg.NewId, "Image animation exceeds the limits", err
+	}
+
+	// Resolve the output format, quality and size requested by the job
+	image := pkg.ImageOptionsFromMessage(imageMsg, source, watermark)
+
+	outputPath := fmt.Sprintf("%s/images/%s%s", stagingStorage, imageMsg.NewId, pkg.ImageExtension(image.Format))
 
 	// Execute the command for image processing
-	if err = pkg.ConvertImage(imageMsg.FilePath, outputPath, "1"); err != nil {
+	if err = pkg.ConvertImage(imageMsg.FilePath, outputPath, image); err != nil {
 		return imageMsg.NewId, "Image conversion failed", err
 	}
 
-	pkg.AddToFileDeleteChan(imageMsg.FilePath) // Ensure file is scheduled for deletion
+	// Compute the placeholder from the converted image, so it matches the orientation and crop of the served image.
+	// Animated WebP outputs can not be decoded by every ffmpeg build, the placeholder of animations uses the upload.
+	placeholderPath := outputPath
+	if image.Animated {
+		placeholderPath = imageMsg.FilePath
+	}
+	placeholder, err := pkg.CreatePlaceholder(placeholderPath)
+	if err != nil {
+		return imageMsg.NewId, "Image placeholder creation failed", err
+	}
+
+	mediaDir := pkg.MediaDir(stagingStorage, "image", imageMsg.NewId)
+
+	// Write the GIF fallback into the media directory of the image, only animated uploads need a fallback,
+	// still WebP images are decoded by the players without animated WebP support
+	fallback := ""
+	if imageMsg.Fallback && image.Animated {
+		if err = pkg.CreateDir(mediaDir); err != nil {
+			return imageMsg.NewId, "Error creating image directory", err
+		}
+		fallbackImage := image
+		fallbackImage.Format = "gif"
+		if err = pkg.ConvertImage(imageMsg.FilePath, mediaDir+"/"+pkg.ImageFallbackFileName, fallbackImage); err != nil {
+			return imageMsg.NewId, "Image fallback conversion failed", err
+		}
+		fallback = pkg.ImageFallbackFileName
+	}
+
+	// Write the responsive variants into the media directory of the image, widths above the image are written with its width
+	// and the requested files of these widths are linked to it
+	requestedVariants := pkg.ImageVariantsFromMessage(imageMsg.Variants)
+	variants := pkg.ProducedImageVariants(requestedVariants, source.DisplayWidth())
+	if len(variants) > 0 {
+		if err = pkg.CreateDir(mediaDir); err != nil {
+			return imageMsg.NewId, "Error creating image directory", err
+		}
+		if err = pkg.ConvertImageVariants(imageMsg.FilePath, mediaDir, image, variants); err != nil {
+			return imageMsg.NewId, "Image variants conversion failed", err
+		}
+		if err = pkg.LinkRequestedImageVariants(mediaDir, requestedVariants, source.DisplayWidth()); err != nil {
+			return imageMsg.NewId, "Error linking image variants", err
+		}
+	}
+
+	// Record the extension of the image, used to resolve the image for deletion and signed URLs
+	if err = pkg.WriteMetadata(stagingStorage, &pkg.MediaMetadata{
+		ID:          imageMsg.NewId,
+		Type:        "image",
+		Extension:   pkg.ImageExtension(image.Format),
+		Variants:    variants,
+		Fallback:    fallback,
+		Source:      source,
+		Placeholder: placeholder,
+		Watermark:   watermark.ProfileName(),
+		CreatedAt:   time.Now(),
+	}); err != nil {
+		return imageMsg.NewId, "Error writing image metadata", err
+	}
+
+	// Publish the outputs, and archive the upload as the original of the image
+	if err = finishJob("image", imageMsg.NewId, stagingStorage, imageMsg.FilePath, imageMsg.Upload, imageMsg.Reprocess); err != nil {
+		return imageMsg.NewId, "Error publishing image or archiving original", err
+	}
 
 	// Return success: new ID and a success message
 	return imageMsg.NewId, "Image conversion completed successfully", nil
@@ -120,25 +333,188 @@ func processAudioMessage(kafkaMsg []byte) (string, string, error) {
 		return "", errMsg + " AudioMessage", err
 	}
 
-	outputPath := fmt.Sprintf("%s/audios/%s.mp3", helper.Constants.MediaStorage, audioMsg.NewId)
+	// Write the outputs into the staging storage of the job, reprocess jobs read the verified original of the audio
+	var stagingStorage string
+	stagingStorage, audioMsg.FilePath, err = jobStorage("audio", audioMsg.NewId, audioMsg.FilePath, audioMsg.Upload, audioMsg.Reprocess)
+	if err != nil {
+		return audioMsg.NewId, "Error creating staging directory, fetching upload or reading original audio", err
+	}
+
+	// The staged outputs are published on success, the staging storage is removed either way
+	defer pkg.AddToDirDeleteChan(stagingStorage)
 
-	// Execute the command for audio conversion using the provided bitrate (if any)
-	if audioMsg.Bitrate != nil {
-		err = pkg.ConvertAudio(audioMsg.FilePath, outputPath, *audioMsg.Bitrate)
-	} else {
-		err = pkg.ConvertAudio(audioMsg.FilePath, outputPath) // Call without bitrate
+	outputPath := fmt.Sprintf("%s/audios/%s.mp3", stagingStorage, audioMsg.NewId)
+	mediaDir := pkg.MediaDir(stagingStorage, "audio", audioMsg.NewId)
+
+	// Read the tags and cover art of the upload, which are rewritten into every output
+	probe, err := pkg.ProbeAudio(audioMsg.FilePath)
+	if err != nil {
+		return audioMsg.NewId, "Audio probe failed", err
 	}
 
+	// Measure the loudness of the audio if the job normalizes it, every output is normalized with the same measurement
+	loudness, err := pkg.MeasureLoudness(audioMsg.FilePath, audioMsg.NormalizeLoudness)
 	if err != nil {
+		return audioMsg.NewId, "Audio loudness measurement failed", err
+	}
+
+	if err = pkg.CreateDir(mediaDir); err != nil {
+		return audioMsg.NewId, "Error creating audio directory", err
+	}
+
+	processing := pkg.AudioProcessing{Loudness: loudness, Tags: pkg.AudioTagsFromMessage(probe.Tags, audioMsg.Tags)}
+
+	// Save the cover art into the media directory, it is embedded into the outputs and used for the placeholder
+	var placeholder *topics.Placeholder
+	if probe.CoverStream >= 0 {
+		processing.Cover = mediaDir + "/" + pkg.CoverArtFileName
+		if err = pkg.ExtractCoverArt(audioMsg.FilePath, probe.CoverStream, processing.Cover); err != nil {
+			return audioMsg.NewId, "Audio cover art extraction failed", err
+		}
+		if placeholder, err = pkg.CreatePlaceholder(processing.Cover); err != nil {
+			return audioMsg.NewId, "Audio cover art placeholder creation failed", err
+		}
+	}
+
+	// Execute the command for audio conversion using the provided bitrate (if any)
+	if err = pkg.ConvertAudio(audioMsg.FilePath, outputPath, pkg.MainAudioOutput(audioMsg.Bitrate), processing); err != nil {
 		return audioMsg.NewId, "Audio conversion failed", err
 	}
 
-	pkg.AddToFileDeleteChan(audioMsg.FilePath) // Ensure file is scheduled for deletion
+	// Write the additional formats and bitrates into the media directory of the audio
+	outputs := pkg.AudioOutputsFromMessage(audioMsg.Outputs)
+	if len(outputs) > 0 {
+		if err = pkg.ConvertAudioOutputs(audioMsg.FilePath, mediaDir, outputs, processing); err != nil {
+			return audioMsg.NewId, "Audio outputs conversion failed", err
+		}
+	}
+
+	// Write the HLS audio renditions into the media directory of the audio
+	hlsPlaylist := ""
+	if audioMsg.HLS != nil {
+		hls := config.KafkaConsumeEnv.HLS.Merge(&topics.HLSOptions{SegmentDuration: audioMsg.HLS.SegmentDuration})
+		if err = pkg.ConvertAudioHLS(audioMsg.FilePath, mediaDir+"/"+pkg.AudioHLSDir, audioMsg.HLS.Bitrates, hls, loudness); err != nil {
+			return audioMsg.NewId, "Audio HLS conversion failed", err
+		}
+		hlsPlaylist = pkg.AudioHLSPlaylist()
+	}
+
+	// Write the waveform peaks of the converted audio into the media directory of the audio
+	if err = pkg.CreateWaveform(outputPath, mediaDir+"/"+pkg.WaveformFileName, pkg.WaveformFromMessage(audioMsg.Waveform)); err != nil {
+		return audioMsg.NewId, "Audio waveform creation failed", err
+	}
+
+	// Record the extension, waveform, tags and cover art of the audio
+	if err = pkg.WriteMetadata(stagingStorage, pkg.NewAudioMetadata(audioMsg.NewId, outputs, hlsPlaylist, processing, placeholder)); err != nil {
+		return audioMsg.NewId, "Error writing audio metadata", err
+	}
+
+	// Publish the outputs, and archive the upload as the original of the audio
+	if err = finishJob("audio", audioMsg.NewId, stagingStorage, audioMsg.FilePath, audioMsg.Upload, audioMsg.Reprocess); err != nil {
+		return audioMsg.NewId, "Error publishing audio or archiving original", err
+	}
 
 	// Return success: new ID and a success message
 	return audioMsg.NewId, "Audio conversion completed successfully", nil
 }
 
+// processCaptionMessage adds a caption track to an existing video and returns the video ID, message, or an error
+func processCaptionMessage(kafkaMsg []byte) (string, string, error) {
+	var captionMsg topics.CaptionMessage
+
+	// Unmarshal and Validate the Kafka message into CaptionMessage struct
+	errMsg, err := validator.UnmarshalAndValidate(kafkaMsg, &captionMsg)
+	if err != nil {
+		return "", errMsg + " CaptionMessage", err
+	}
+
+	// Convert the subtitle file to a segmented WebVTT track and add it to the master playlist of the video,
+	// a video in an object storage is read from and written to a work directory in the staging directory
+	track := pkg.CaptionTrack{Language: captionMsg.Language, Name: captionMsg.Name, Default: captionMsg.Default}
+	workStorage := pkg.StagingStorage(helper.Constants.MediaStorage, captionMsg.NewId, nil) + ".caption." + captionMsg.Language
+
+	// Fetch the subtitle file handed off by the server to its file path
+	if captionMsg.Upload != "" {
+		if err = pkg.FetchObject(config.KafkaConsumeEnv.STORAGE, captionMsg.Upload, captionMsg.FilePath); err != nil {
+			return captionMsg.NewId, "Error fetching subtitle file", err
+		}
+	}
+
+	if err = pkg.AddStoredVideoCaption(config.KafkaConsumeEnv.STORAGE, workStorage, captionMsg.NewId, captionMsg.FilePath, track, config.KafkaConsumeEnv.HLS.SegmentDuration); err != nil {
+		if captionMsg.Upload != "" {
+			pkg.AddToFileDeleteChan(captionMsg.FilePath) // The failed consumer fetches the subtitle file again
+		}
+		return captionMsg.NewId, "Caption conversion failed", err
+	}
+
+	pkg.AddToFileDeleteChan(captionMsg.FilePath) // Ensure file is scheduled for deletion
+	removeUpload(captionMsg.Upload)              // Remove the handed off subtitle file
+
+	// Return success: video ID and a success message
+	return captionMsg.NewId, "Caption conversion completed successfully", nil
+}
+
+// processClipMessage cuts a range of an existing video or audio into a new media file and returns the new ID, message, or an error
+func processClipMessage(kafkaMsg []byte) (string, string, error) {
+	var clipMsg topics.ClipMessage
+
+	// Unmarshal and Validate the Kafka message into ClipMessage struct
+	errMsg, err := validator.UnmarshalAndValidate(kafkaMsg, &clipMsg)
+	if err != nil {
+		return "", errMsg + " ClipMessage", err
+	}
+
+	if err = createMediaEdit(clipMsg.NewId, clipMsg.MediaType, []topics.EditPart{clipMsg.Part}, clipMsg.HLS); err != nil {
+		return clipMsg.NewId, "Clip creation failed", err
+	}
+
+	// Return success: new ID and a success message
+	return clipMsg.NewId, "Clip created successfully", nil
+}
+
+// processConcatMessage joins ranges of existing videos or audios into a new media file and returns the new ID, message, or an error
+func processConcatMessage(kafkaMsg []byte) (string, string, error) {
+	var concatMsg topics.ConcatMessage
+
+	// Unmarshal and Validate the Kafka message into ConcatMessage struct
+	errMsg, err := validator.UnmarshalAndValidate(kafkaMsg, &concatMsg)
+	if err != nil {
+		return "", errMsg + " ConcatMessage", err
+	}
+
+	if err = createMediaEdit(concatMsg.NewId, concatMsg.MediaType, concatMsg.Parts, concatMsg.HLS); err != nil {
+		return concatMsg.NewId, "Concatenation failed", err
+	}
+
+	// Return success: new ID and a success message
+	return concatMsg.NewId, "Concatenation completed successfully", nil
+}
+
+// createMediaEdit writes the new video or audio id from the ranges of existing media files into the staging storage
+// of the job, and publishes it on success. The staging storage is scheduled for deletion either way.
+// Sources in an object storage are downloaded next to the staging storage first.
+func createMediaEdit(id, mediaType string, parts []topics.EditPart, jobHLS *topics.HLSOptions) error {
+	stagingStorage := pkg.StagingStorage(helper.Constants.MediaStorage, id, nil)
+	defer pkg.AddToDirDeleteChan(stagingStorage)
+	defer pkg.AddToDirDeleteChan(stagingStorage + ".sources")
+
+	editParts := pkg.EditPartsFromMessage(parts)
+	sourceStorage, err := pkg.FetchEditSources(config.KafkaConsumeEnv.STORAGE, stagingStorage+".sources", mediaType, editParts)
+	if err != nil {
+		return err
+	}
+
+	if mediaType == "video" {
+		err = pkg.CreateVideoEdit(sourceStorage, stagingStorage, id, editParts, config.KafkaConsumeEnv.HLS.Merge(jobHLS))
+	} else {
+		err = pkg.CreateAudioEdit(sourceStorage, stagingStorage, id, editParts)
+	}
+	if err != nil {
+		return err
+	}
+	return pkg.PublishOutputs(config.KafkaConsumeEnv.STORAGE, stagingStorage, mediaType, id, nil)
+}
+
 func processDeleteFileMessage(msg kafka.Message, workerName string) {
 	var deleteFileMsg api.DeleteFileRequest
 
@@ -150,22 +526,39 @@ func processDeleteFileMessage(msg kafka.Message, workerName string) {
 		return
 	}
 
-	// Construct the file path based on the media type and ID
-	path := fmt.Sprintf("%s/%ss/%s", helper.Constants.MediaStorage, deleteFileMsg.Type, deleteFileMsg.Id)
+	// Construct the key of the media directory based on the media type and ID
+	path := pkg.MediaKey(deleteFileMsg.Type, deleteFileMsg.Id)
 
 	/*
 		NOTE: No error is passed in the response, as file or directory deletion
 		logging is handled inside the deletion function only.
 	*/
 
-	// Determine the media type and call the appropriate deletion function
-	switch deleteFileMsg.Type {
-	case "image":
-		err = os.Remove(path + ".jpeg") // Delete the image file with a .jpeg extension
-	case "audio":
-		err = os.Remove(path + ".mp3") // Delete the audio file with a .mp3 extension
-	default:
-		err = os.RemoveAll(path) // Delete the directory for other types
+	// Delete the served file with its recorded extension (e.g. "<id>.png" or "<id>.mp3") of images and audios,
+	// and the media directory holding the metadata, variants, additional audio outputs, HLS renditions and cover art
+	err = pkg.DeleteMedia(config.KafkaConsumeEnv.STORAGE, deleteFileMsg.Type, deleteFileMsg.Id)
+
+	if deleteFileMsg.Type == "video" {
+		// Delete the HLS encryption key of the video from the key directory and the storage, if the video was encrypted
+		if keyErr := pkg.DeleteHLSKey(config.KafkaConsumeEnv.STORAGE, helper.Constants.KeyStorage, deleteFileMsg.Id); keyErr != nil {
+			logger.LogErrorWithKafkaMessage(keyErr, workerName, msg, "Error while deleting hls key, id: "+deleteFileMsg.Id)
+		}
+	}
+
+	// Delete the archived original of the media file, which does not exist if KEEP_ORIGINALS does not keep it
+	if originalErr := pkg.DeleteOriginal(config.KafkaConsumeEnv.STORAGE, helper.Constants.OriginalStorage, deleteFileMsg.Type, deleteFileMsg.Id); originalErr != nil {
+		logger.LogErrorWithKafkaMessage(originalErr, workerName, msg, "Error while deleting original, id: "+deleteFileMsg.Id)
+	}
+
+	// Delete the outputs of running jobs of the media file, e.g. reprocess jobs, which are not published
+	stagingStorages, stagingErr := pkg.StagingStorages(helper.Constants.MediaStorage, deleteFileMsg.Id)
+	if stagingErr != nil {
+		logger.LogErrorWithKafkaMessage(stagingErr, workerName, msg, "Error while listing staging directories, id: "+deleteFileMsg.Id)
+	}
+	for _, stagingStorage := range stagingStorages {
+		if stagingErr = os.RemoveAll(stagingStorage); stagingErr != nil {
+			logger.LogErrorWithKafkaMessage(stagingErr, workerName, msg, "Error while deleting staging directory, path: "+stagingStorage)
+		}
 	}
 
 	// Log any error that occurs during deletion, along with relevant details for troubleshooting
@@ -177,3 +570,101 @@ func processDeleteFileMessage(msg kafka.Message, workerName string) {
 			fmt.Sprintf("Error while deleting %s file, id: %s, path: %s", deleteFileMsg.Type, deleteFileMsg.Id, path))
 	}
 }
+
+// archiveOrDeleteUpload archives the upload of a processed media file as its original if KEEP_ORIGINALS keeps
+// the media type, so the media file can be processed again later, see pkg.ArchiveOriginal. Otherwise the upload
+// is scheduled for deletion, unless it was fetched into the staging storage of the job (the key upload is set),
+// which is removed with it.
+func archiveOrDeleteUpload(mediaType, id, uploadPath, upload string) error {
+	if !slices.Contains(config.KafkaConsumeEnv.KEEP_ORIGINALS, mediaType) {
+		if upload == "" {
+			pkg.AddToFileDeleteChan(uploadPath)
+		}
+		return nil
+	}
+	_, err := pkg.ArchiveOriginal(config.KafkaConsumeEnv.STORAGE, helper.Constants.OriginalStorage, mediaType, id, uploadPath)
+	return err
+}
+
+// jobStorage returns the staging storage a job writes into, see pkg.StagingStorage, and the path of its source.
+// Reprocess jobs read the archived original of the media file, whose checksum is verified,
+// other jobs read their upload at filePath. An upload handed off through the storage (see pkg.HandOffUpload),
+// or an original archived in it, is fetched into the staging storage, so it is removed with it if the job fails.
+func jobStorage(mediaType, id, filePath, upload string, reprocess *topics.Reprocess) (string, string, error) {
+	// Create the media directory, so the served file of images and audios can be written next to it
+	stagingStorage := pkg.StagingStorage(helper.Constants.MediaStorage, id, reprocess)
+	if err := pkg.CreateDir(pkg.MediaDir(stagingStorage, mediaType, id)); err != nil {
+		return "", "", err
+	}
+
+	sourcePath := filePath
+	if reprocess != nil {
+		_, originalPath, err := pkg.ReadOriginal(config.KafkaConsumeEnv.STORAGE, helper.Constants.OriginalStorage, stagingStorage, mediaType, id)
+		if err != nil {
+			pkg.AddToDirDeleteChan(stagingStorage)
+			return "", "", err
+		}
+		sourcePath = originalPath
+	}
+
+	// The upload handed off by the server is read from its copy in the staging storage
+	if reprocess == nil && upload != "" {
+		sourcePath = filepath.Join(stagingStorage, filepath.Base(filePath))
+		if err := pkg.FetchObject(config.KafkaConsumeEnv.STORAGE, upload, sourcePath); err != nil {
+			pkg.AddToDirDeleteChan(stagingStorage)
+			return "", "", fmt.Errorf("error fetching upload %s: %w", upload, err)
+		}
+	}
+	return stagingStorage, sourcePath, nil
+}
+
+// finishJob publishes the outputs of a job from its staging storage, see pkg.PublishOutputs, and archives or deletes
+// the upload of the processed media file, see archiveOrDeleteUpload. The original of a reprocess job is kept.
+// The outputs are moved back into the staging storage if archiving fails, so the media file is not served
+// while its job is reported as failed. An upload handed off through the storage is removed once the job completes,
+// failed jobs keep it for the failed consumer.
+func finishJob(mediaType, id, stagingStorage, uploadPath, upload string, reprocess *topics.Reprocess) error {
+	if err := pkg.PublishOutputs(config.KafkaConsumeEnv.STORAGE, stagingStorage, mediaType, id, reprocess); err != nil {
+		return err
+	}
+	if reprocess != nil {
+		return nil
+	}
+
+	if err := archiveOrDeleteUpload(mediaType, id, uploadPath, upload); err != nil {
+		if unpublishErr := pkg.UnpublishOutputs(config.KafkaConsumeEnv.STORAGE, stagingStorage, mediaType, id); unpublishErr != nil {
+			return fmt.Errorf("%w, and unpublishing the outputs failed: %v", err, unpublishErr)
+		}
+		return err
+	}
+	removeUpload(upload)
+	return nil
+}
+
+// removeUpload removes an upload handed off through the storage once its job completes, see pkg.HandOffUpload.
+// A failed removal only leaves an unreferenced object, so it is logged without failing the job.
+func removeUpload(upload string) {
+	if err := pkg.RemoveUpload(config.KafkaConsumeEnv.STORAGE, upload); err != nil {
+		log.Warn().Err(err).Msgf("Warning: Could not remove handed off upload: %s", upload)
+	}
+}
+
+// convertVideoAudioTracks converts the alternate audio tracks of a video into its media directory outputPath.
+// Encrypted videos encrypt the tracks with the key of the video, whose URI is two directories above the track playlists.
+// Nothing is written if the video has no audio tracks.
+func convertVideoAudioTracks(id, videoPath, outputPath string, tracks []pkg.VideoAudioTrack, hls pkg.HLSOptions, encryption *string) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+
+	if encryption != nil {
+		keyInfoFile, err := pkg.WriteRenditionKeyInfo(helper.Constants.KeyStorage, id, pkg.VideoAudioDir, "../../"+pkg.HLSKeyName)
+		if err != nil {
+			return err
+		}
+		defer pkg.AddToFileDeleteChan(keyInfoFile) // Key info file is only needed during conversion
+		hls.KeyInfoFile = keyInfoFile
+	}
+
+	return pkg.ConvertVideoAudioTracks(videoPath, outputPath, tracks, hls)
+}
@@ -4,8 +4,10 @@ package process
 import (
 	"time"
 
+	"github.com/nvj9singhnavjot/media-docker/config"
 	"github.com/nvj9singhnavjot/media-docker/kafkahandler"
 	"github.com/nvj9singhnavjot/media-docker/logger"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
 	"github.com/nvj9singhnavjot/media-docker/topics"
 	"github.com/nvj9singhnavjot/media-docker/validator"
 	"github.com/rs/zerolog/log"
@@ -36,6 +38,18 @@ var topicHandlers = map[string]topicHandler{
 		fileType:    "audio",             // File type for audio messages.
 		processFunc: processAudioMessage, // Function to process audio messages.
 	},
+	"caption": {
+		fileType:    "caption",             // File type for caption messages.
+		processFunc: processCaptionMessage, // Function to process caption messages.
+	},
+	"clip": {
+		fileType:    "clip",             // File type for clip messages.
+		processFunc: processClipMessage, // Function to process clip messages.
+	},
+	"concat": {
+		fileType:    "concat",             // File type for concat messages.
+		processFunc: processConcatMessage, // Function to process concat messages.
+	},
 }
 
 // handleErrorResponse processes errors from message consumption functions.
@@ -91,7 +105,7 @@ func handleErrorResponse(msg kafka.Message, workerName, fileType, newId, resMess
 			Str("worker", workerName).
 			Interface("dlq_message", dlqMessage).
 			Msg("Error producing message to failed-letter-queue.")
-		kafkahandler.SendConsumerResponse(workerName, newId, fileType, "failed")
+		kafkahandler.SendConsumerResponse(workerName, newId, fileType, "failed", nil)
 		return
 	}
 
@@ -135,5 +149,6 @@ func ProcessMessage(msg kafka.Message, workerName string) {
 	}
 
 	// If the message is processed successfully, send a success response
-	kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "completed")
+	kafkahandler.SendConsumerResponse(workerName, newId, handler.fileType, "completed",
+		pkg.ReadPlaceholder(config.KafkaConsumeEnv.STORAGE, handler.fileType, newId)) // Placeholder recorded by the handler, if any
 }
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/nvj9singhnavjot/media-docker/api"
+)
+
+func EditRoutes() func(router chi.Router) {
+	return func(router chi.Router) {
+		router.Post("/clip", api.Clip)
+		router.Post("/concat", api.Concat)
+	}
+}
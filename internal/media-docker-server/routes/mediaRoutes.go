@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/nvj9singhnavjot/media-docker/api"
+)
+
+func MediaRoutes() func(router chi.Router) {
+	return func(router chi.Router) {
+		router.Get("/image/{id}", api.ImageUrls)
+		router.Post("/{type}/{id}/reprocess", api.Reprocess)
+	}
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/nvj9singhnavjot/media-docker/api"
+)
+
+func PlaybackRoutes() func(router chi.Router) {
+	return func(router chi.Router) {
+		router.Post("/token", api.PlaybackToken)
+		router.Post("/sign", api.PlaybackSign)
+	}
+}
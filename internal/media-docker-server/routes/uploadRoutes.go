@@ -13,5 +13,6 @@ func UploadRoutes() func(router chi.Router) {
 		router.Post("/video-resolutions", api.VideoResolutions)
 		router.Post("/image", api.Image)
 		router.Post("/audio", api.Audio)
+		router.Post("/caption", api.Caption)
 	}
 }
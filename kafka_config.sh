@@ -7,6 +7,9 @@ declare -A topics_and_partitions=(
     ["video-resolutions"]=100
     ["image"]=100
     ["audio"]=50
+    ["caption"]=20
+    ["clip"]=20
+    ["concat"]=20
     ["delete-file"]=20
     ["media-docker-files-response"]=50
     ["failed-letter-queue"]=10
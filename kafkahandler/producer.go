@@ -27,12 +27,20 @@ func InitializeKafkaProducerManager(brokers []string) {
 	KafkaProducer.writer = &kafka.Writer{
 		Addr:        kafka.TCP(brokers...), // Address of Kafka brokers for message delivery
 		MaxAttempts: 10,                    // Max retry attempts in case message delivery fails
+		Balancer:    &kafka.Hash{},         // Messages with the same key go to the same partition, others are spread round robin
 	}
 }
 
 // Produce sends a message to the specified Kafka topic. The message value is marshaled to JSON format
 // before being sent. It returns an error if the marshaling or writing process fails.
 func (kp *kafkaProducerManager) Produce(topic string, value interface{}) error {
+	return kp.ProduceWithKey(topic, "", value)
+}
+
+// ProduceWithKey sends a message with the given key to the specified Kafka topic, see Produce.
+// Messages with the same key are written to the same partition, so they are consumed in order by a single worker
+// of a consumer group. An empty key sends the message without a key.
+func (kp *kafkaProducerManager) ProduceWithKey(topic, key string, value interface{}) error {
 	// Convert the message value to JSON format for sending
 	jsonValue, err := json.Marshal(value)
 	if err != nil {
@@ -45,6 +53,9 @@ func (kp *kafkaProducerManager) Produce(topic string, value interface{}) error {
 		Topic: topic,     // The target Kafka topic to produce the message to
 		Value: jsonValue, // Serialized message payload in JSON format
 	}
+	if key != "" {
+		message.Key = []byte(key) // Partition key of the message
+	}
 
 	// Write the message to the Kafka topic
 	return kp.writer.WriteMessages(context.Background(), message)
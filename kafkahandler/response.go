@@ -15,19 +15,26 @@ import (
 //   - "videoResolutions"
 //   - "image"
 //   - "audio"
+//   - "caption"
+//   - "clip"
+//   - "concat"
 //
 // - status: Status of the file processing. Allowed values:
 //   - "completed"
 //   - "failed"
 //
+// - placeholder: BlurHash and dominant colour of the image, video poster or audio cover art
+// (nil for audios without cover art and failed messages).
+//
 // CAUTION: Providing values outside the allowed range for fileType or status may cause
 // errors during further processing by client backend services.
-func SendConsumerResponse(workerName, newId, fileType, status string) {
-	// Create a response message object with the provided ID, FileType, and Status.
+func SendConsumerResponse(workerName, newId, fileType, status string, placeholder *topics.Placeholder) {
+	// Create a response message object with the provided ID, FileType, Status, and Placeholder.
 	message := topics.KafkaResponseMessage{
-		ID:       newId,
-		FileType: fileType,
-		Status:   status,
+		ID:          newId,
+		FileType:    fileType,
+		Status:      status,
+		Placeholder: placeholder,
 	}
 
 	// Produce the response message to the "media-docker-files-response" topic.
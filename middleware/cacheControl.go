@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/nvj9singhnavjot/media-docker/playback"
+)
+
+// mediaTypes maps the extensions served by media-docker-client to their MIME types.
+// The system mime database is not reliable for media files, e.g. ".ts" is often mapped to Qt translation files.
+var mediaTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl", // HLS playlist
+	".ts":   "video/mp2t",                    // HLS MPEG-TS segment
+	".m4s":  "video/iso.segment",             // fMP4 segment (HLS and DASH)
+	".mpd":  "application/dash+xml",          // DASH manifest
+	".vtt":  "text/vtt; charset=utf-8",       // WebVTT subtitles
+	".webp": "image/webp",                    // WebP image
+	".m4a":  "audio/mp4",                     // AAC audio
+	".opus": "audio/ogg; codecs=opus",        // Opus audio
+}
+
+// mediaExtensions are segments and images, which are cached for mediaMaxAge seconds and then revalidated with their ETag.
+// They are not immutable: a reprocess job replaces the files of a media file under the same URLs.
+var mediaExtensions = map[string]bool{
+	".ts":   true,
+	".m4s":  true,
+	".jpeg": true,
+	".jpg":  true,
+	".png":  true,
+	".webp": true,
+	".avif": true,
+	".gif":  true,
+}
+
+// playlistExtensions are manifests which can change while a stream is processed (e.g. "event" playlists).
+var playlistExtensions = map[string]bool{
+	".m3u8": true,
+	".mpd":  true,
+}
+
+// compressibleTypes are the text content types compressed by CompressManifests.
+var compressibleTypes = []string{
+	"application/vnd.apple.mpegurl",
+	"application/dash+xml",
+	"text/vtt",
+	"application/json",
+}
+
+var registerOnce sync.Once
+
+// registerMediaTypes registers the MIME types of mediaTypes, used by http.FileServer to set Content-Type.
+func registerMediaTypes() {
+	registerOnce.Do(func() {
+		for ext, mimeType := range mediaTypes {
+			mime.AddExtensionType(ext, mimeType)
+		}
+	})
+}
+
+// CompressManifests is a middleware function that compresses text manifests (playlists, subtitles, json)
+// with brotli or gzip, depending on the Accept-Encoding header of the request.
+// Segments and images are already compressed and are passed through unchanged.
+//
+// INFO: It must be registered before SignedURL, so that playlists are compressed after they are rewritten.
+func CompressManifests() func(http.Handler) http.Handler {
+	compressor := middleware.NewCompressor(5, compressibleTypes...)
+	// Encoders set later take precedence, so brotli is preferred over gzip when both are accepted
+	compressor.SetEncoder("br", func(w io.Writer, level int) io.Writer {
+		return brotli.NewWriterLevel(w, level)
+	})
+	return compressor.Handler
+}
+
+// acceptsCompression reports whether the request accepts an encoding used by CompressManifests.
+func acceptsCompression(r *http.Request) bool {
+	accept := r.Header.Get("Accept-Encoding")
+	return strings.Contains(accept, "br") || strings.Contains(accept, "gzip") || strings.Contains(accept, "deflate")
+}
+
+// setCacheHeaders sets the Cache-Control and ETag headers for the file at name in root.
+// Nothing is set if the file does not exist or is a directory, so error responses are never cached.
+func setCacheHeaders(w http.ResponseWriter, r *http.Request, root http.FileSystem, name string, playlistMaxAge, mediaMaxAge int) {
+	file, err := root.Open(name)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+
+	// The ETag is derived from size and modification time, which change whenever the file is replaced.
+	// It is strong, so it can be used for If-Range requests on segments, except for compressed manifests,
+	// whose encoded bytes differ from the file on disk.
+	etag := etagFromFileInfo(info)
+	if compressible(ext) && acceptsCompression(r) {
+		etag = "W/" + etag
+	}
+	w.Header().Set("ETag", etag)
+
+	var maxAge int
+	var cacheControl string
+	switch {
+	case mediaExtensions[ext]:
+		maxAge = mediaMaxAge
+		cacheControl = "public, max-age=%d"
+	case playlistExtensions[ext]:
+		maxAge = playlistMaxAge
+		cacheControl = "public, max-age=%d"
+	default:
+		// Other files (e.g. audio) are revalidated with the ETag on every request
+		w.Header().Set("Cache-Control", "public, no-cache")
+		return
+	}
+
+	// A signed URL must not be cached beyond the expiry of its signature
+	if expiresAt, signed := playback.SignedRequestExpiry(r.Context()); signed {
+		remaining := int(time.Until(expiresAt).Seconds())
+		if remaining < maxAge {
+			maxAge = max(remaining, 0)
+		}
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf(cacheControl, maxAge))
+}
+
+// etagFromFileInfo returns a quoted ETag built from the size and modification time of the file.
+func etagFromFileInfo(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// compressible reports whether files with the extension are compressed by CompressManifests.
+func compressible(ext string) bool {
+	mimeType := mime.TypeByExtension(ext)
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(mimeType, t) {
+			return true
+		}
+	}
+	return false
+}
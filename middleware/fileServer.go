@@ -10,7 +10,12 @@ import (
 
 // FileServer sets up a `http.FileServer` handler to serve static files from a given `http.FileSystem`.
 // It integrates with the Chi router and configures routes to serve files efficiently.
-func FileServer(r chi.Router, path string, root http.FileSystem) {
+// Every file is served with Cache-Control and ETag headers, segments and images are cached for mediaMaxAge seconds
+// and playlists for playlistMaxAge seconds. Hidden files and directories are not served.
+func FileServer(r chi.Router, path string, root http.FileSystem, playlistMaxAge, mediaMaxAge int) {
+	// Register the MIME types of media files served by the file server
+	registerMediaTypes()
+
 	// Check if the provided path contains URL parameters (e.g., `{}` or `*`)
 	// which are not allowed for static file serving.
 	if strings.ContainsAny(path, "{}*") {
@@ -34,10 +39,17 @@ func FileServer(r chi.Router, path string, root http.FileSystem) {
 
 	// Configure the router to handle requests to the specified path.
 	r.Get(path, func(w http.ResponseWriter, r *http.Request) {
+		// Hidden files and directories (e.g. the unpublished outputs in pkg.StagingDir) are never served.
+		if strings.Contains(r.URL.Path, "/.") {
+			http.NotFound(w, r)
+			return
+		}
 		// Extract the route context to get the route pattern used.
 		rctx := chi.RouteContext(r.Context())
 		// Remove the trailing wildcard from the route pattern to get the path prefix.
 		pathPrefix := strings.TrimSuffix(rctx.RoutePattern(), "/*")
+		// Set the caching headers before serving, so that conditional requests are answered with 304
+		setCacheHeaders(w, r, root, strings.TrimPrefix(r.URL.Path, pathPrefix), playlistMaxAge, mediaMaxAge)
 		// Create a file server handler with the correct prefix for serving files.
 		fs := http.StripPrefix(pathPrefix, http.FileServer(root))
 		// Serve the requested file.
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
+)
+
+// transformQuality is the encoder quality of image derivatives, between 1 (lowest) and 100 (highest).
+const transformQuality = 80
+
+// imageFormatTypes maps negotiable output formats to the MIME types checked in the Accept header,
+// in order of preference.
+var imageFormatTypes = []struct {
+	format   string
+	mimeType string
+}{
+	{"avif", "image/avif"},
+	{"webp", "image/webp"},
+}
+
+// ImageTransformer creates resized and converted derivatives of images on request.
+type ImageTransformer struct {
+	prefix    string          // URL path prefix of the images, e.g. "/media_docker_files/images/"
+	keyPrefix string          // Key prefix of the original images in the storage, e.g. "images/"
+	storage   pkg.Storage     // Storage of the original images
+	cache     *pkg.DiskCache  // Cache of created derivatives
+	sizes     []int           // Allowed values of the w and h query parameters
+	slots     chan struct{}   // Limits the number of concurrent ffmpeg processes
+	root      http.FileSystem // File system of the cache directory, used to set the caching headers
+	maxAge    int             // Cache-Control max-age of derivatives in seconds, the source image can be replaced by a reprocess job
+}
+
+// NewImageTransformer creates an ImageTransformer for the images stored below keyPrefix in storage and served below prefix.
+// Derivatives are cached in cacheDir, which is limited to cacheBytes, and served with a max-age of maxAge seconds.
+func NewImageTransformer(prefix, keyPrefix string, storage pkg.Storage, cacheDir string, cacheBytes int64, sizes []int, maxAge int) (*ImageTransformer, error) {
+	cache, err := pkg.NewDiskCache(cacheDir, cacheBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageTransformer{
+		prefix:    prefix,
+		keyPrefix: keyPrefix,
+		storage:   storage,
+		cache:     cache,
+		sizes:     sizes,
+		slots:     make(chan struct{}, runtime.NumCPU()),
+		root:      http.Dir(cacheDir),
+		maxAge:    maxAge,
+	}, nil
+}
+
+// Handler is a middleware function that serves derivatives of images when the request has any of
+// the query parameters "w", "h", "fit" or "format":
+//   - w, h: target width and height in pixels, must be one of the allowed sizes.
+//   - fit: "contain" (default), "cover" or "fill", only used if both w and h are set.
+//   - format: "jpeg", "png", "webp", "avif" or "auto" (default), which picks the best format
+//     supported by the Accept header of the request.
+//
+// Requests without these parameters, or for files which are not images, are passed to the next handler.
+func (t *ImageTransformer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name, isImage := strings.CutPrefix(r.URL.Path, t.prefix)
+		if !isImage || !hasAnyParam(query, "w", "h", "fit", "format") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only files directly inside the image directory can be transformed
+		sourceFormat, ok := pkg.ImageFormatFromExtension(filepath.Ext(name))
+		if !ok || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		transform, err := t.parseTransform(query)
+		if err != nil {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusBadRequest, "invalid image transformation", err)
+			return
+		}
+
+		// Negotiate the output format with the Accept header
+		if transform.Format == "auto" {
+			transform.Format = negotiateImageFormat(r.Header.Get("Accept"))
+			w.Header().Add("Vary", "Accept")
+		}
+
+		// Nothing to do, serve the original
+		if transform.Width == 0 && transform.Height == 0 && transform.Format == sourceFormat {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		source, err := t.storage.Stat(t.keyPrefix + name)
+		if err != nil {
+			next.ServeHTTP(w, r) // Let the file server respond with not found
+			return
+		}
+
+		// The cache key covers the modification time of the source, so replaced images are transformed again
+		key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%s|%s",
+			name, source.ModTime.UnixNano(), transform.Width, transform.Height, transform.Fit, transform.Format)))
+		cacheName := hex.EncodeToString(key[:]) + "." + transform.Format
+
+		cachePath, err := t.cache.GetOrCreate(cacheName, func(path string) error {
+			t.slots <- struct{}{}
+			defer func() { <-t.slots }()
+
+			sourcePath, err := t.sourcePath(source.Key)
+			if err != nil {
+				return err
+			}
+			if _, local := t.storage.(*pkg.LocalStorage); !local {
+				defer os.Remove(sourcePath)
+			}
+			return pkg.ConvertImage(sourcePath, path, transform)
+		})
+		if err != nil {
+			helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error transforming image", err)
+			return
+		}
+
+		setCacheHeaders(w, r, t.root, cacheName, 0, t.maxAge)
+		http.ServeFile(w, r, cachePath)
+	})
+}
+
+// sourcePath returns the path of the original image of key, which is read in place from a LocalStorage,
+// and downloaded into a temporary file from other storages.
+func (t *ImageTransformer) sourcePath(key string) (string, error) {
+	if local, ok := t.storage.(*pkg.LocalStorage); ok {
+		return filepath.Join(local.Root, filepath.FromSlash(key)), nil
+	}
+
+	file, err := os.CreateTemp("", "image-source-*"+filepath.Ext(key))
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	if err := pkg.FetchObject(t.storage, key, file.Name()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// parseTransform reads and validates the transformation from the query parameters.
+func (t *ImageTransformer) parseTransform(query url.Values) (pkg.ImageOptions, error) {
+	transform := pkg.ImageOptions{Fit: "contain", Format: "auto", Quality: transformQuality}
+
+	for param, size := range map[string]*int{"w": &transform.Width, "h": &transform.Height} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || !slices.Contains(t.sizes, parsed) {
+			return transform, fmt.Errorf("%s must be one of %v", param, t.sizes)
+		}
+		*size = parsed
+	}
+
+	if fit := query.Get("fit"); fit != "" {
+		if fit != "contain" && fit != "cover" && fit != "fill" {
+			return transform, fmt.Errorf("fit must be one of contain, cover, fill")
+		}
+		transform.Fit = fit
+	}
+
+	if format := query.Get("format"); format != "" {
+		if format != "auto" && !pkg.IsImageFormat(format) {
+			return transform, fmt.Errorf("format must be one of auto, jpeg, png, webp, avif")
+		}
+		transform.Format = format
+	}
+
+	return transform, nil
+}
+
+// negotiateImageFormat returns the image format accepted by the client with the highest quality value,
+// preferring the order of imageFormatTypes on ties, and falling back to jpeg. Formats are only negotiated
+// if they are listed explicitly, wildcards like "image/*" are sent by clients which can not decode them,
+// and media ranges with "q=0" are not acceptable.
+func negotiateImageFormat(accept string) string {
+	qualities := acceptQualities(accept)
+	format, best := "jpeg", 0.0
+	for _, candidate := range imageFormatTypes {
+		if q := qualities[candidate.mimeType]; q > best {
+			format, best = candidate.format, q
+		}
+	}
+	return format
+}
+
+// acceptQualities returns the quality values of the media ranges of an Accept header by media type,
+// e.g. {"image/avif": 1, "image/webp": 0.8} for "image/avif,image/webp;q=0.8". Invalid media ranges are skipped,
+// a missing or invalid quality value is 1.
+func acceptQualities(accept string) map[string]float64 {
+	qualities := map[string]float64{}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed >= 0 && parsed <= 1 {
+				q = parsed
+			}
+		}
+		qualities[mediaType] = max(qualities[mediaType], q)
+	}
+	return qualities
+}
+
+// hasAnyParam reports whether the query contains any of the parameters.
+func hasAnyParam(query url.Values, params ...string) bool {
+	for _, param := range params {
+		if query.Has(param) {
+			return true
+		}
+	}
+	return false
+}
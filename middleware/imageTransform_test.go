@@ -0,0 +1,27 @@
+package middleware
+
+import "testing"
+
+func TestNegotiateImageFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		format string
+	}{
+		{"", "jpeg"},
+		{"*/*", "jpeg"},
+		{"image/*,*/*;q=0.8", "jpeg"},
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "avif"},
+		{"image/webp,*/*", "webp"},
+		{"image/avif;q=0,image/webp", "webp"},
+		{"image/avif;q=0, image/webp;q=0", "jpeg"},
+		{"image/avif;q=0.5,image/webp;q=0.9", "webp"},
+		{"image/avif;q=0.9,image/webp;q=0.9", "avif"},
+		{"IMAGE/AVIF", "avif"},
+		{"image/avif;q=invalid", "avif"},
+	}
+	for _, test := range tests {
+		if format := negotiateImageFormat(test.accept); format != test.format {
+			t.Errorf("negotiateImageFormat(%q) = %q, want %q", test.accept, format, test.format)
+		}
+	}
+}
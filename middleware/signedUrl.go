@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/playback"
+)
+
+// bufferedResponse is an http.ResponseWriter that keeps the response in memory,
+// so that it can be modified before it is written to the client.
+type bufferedResponse struct {
+	header http.Header  // Response headers set by the wrapped handler
+	status int          // Response status code set by the wrapped handler
+	body   bytes.Buffer // Response body written by the wrapped handler
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// requestIP returns the IP of the client from the request's RemoteAddr.
+func requestIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr // RemoteAddr has no port, e.g. when set by middleware.RealIP
+	}
+	return ip
+}
+
+// SignedURL is a middleware function that only allows requests with a valid URL signature,
+// created by playback.SignURL with the given secret.
+//
+// HLS playlists (.m3u8) are rewritten on the fly, every URI in the playlist is signed
+// with the same expiry and IP binding as the playlist request, so segments, renditions
+// and keys referenced by the playlist inherit the signature of the playlist URL.
+func SignedURL(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Verify the signature for the requested path and client IP
+			expiresAt, opts, err := playback.VerifyURL(secret, r.URL.Path, r.URL.Query(), requestIP(r))
+			if err != nil {
+				helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusForbidden, "invalid url signature", err)
+				return
+			}
+
+			// Mark the request as verified for the following handlers
+			r = r.WithContext(playback.WithSignedRequest(r.Context(), expiresAt))
+
+			if !strings.HasSuffix(r.URL.Path, ".m3u8") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The playlist is rewritten for every request, so partial and conditional
+			// requests are served with the full rewritten playlist instead.
+			r.Header.Del("Range")
+			r.Header.Del("If-None-Match")
+			r.Header.Del("If-Modified-Since")
+
+			buffered := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+			next.ServeHTTP(buffered, r)
+
+			for key, values := range buffered.header {
+				w.Header()[key] = values
+			}
+
+			// Pass through anything that is not a playlist, e.g. not found responses
+			if buffered.status != http.StatusOK {
+				w.WriteHeader(buffered.status)
+				w.Write(buffered.body.Bytes())
+				return
+			}
+
+			playlist := signPlaylist(secret, r.URL.Path, buffered.body.Bytes(), expiresAt, opts.IP)
+
+			// The rewritten playlist differs per signature, validators of the file no longer apply
+			w.Header().Del("ETag")
+			w.Header().Del("Last-Modified")
+			w.Header().Del("Accept-Ranges")
+			w.Header().Set("Cache-Control", "private, no-cache")
+			w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(playlist)
+		})
+	}
+}
+
+// signPlaylist signs every relative URI of the HLS playlist at playlistPath.
+// URIs are either lines not starting with "#", or URI="..." attributes of tags like #EXT-X-KEY.
+// Absolute URIs are left unchanged, as they are not served by media-docker-client.
+func signPlaylist(secret, playlistPath string, playlist []byte, expiresAt time.Time, ip string) []byte {
+	var out bytes.Buffer
+	dir := path.Dir(playlistPath)
+
+	// signURI resolves the URI against the playlist directory and appends its signature query.
+	signURI := func(uri string) string {
+		if uri == "" || strings.Contains(uri, "://") || strings.HasPrefix(uri, "data:") {
+			return uri
+		}
+
+		uriPath, _, _ := strings.Cut(uri, "?")
+		resolved := uriPath
+		if !strings.HasPrefix(uriPath, "/") {
+			resolved = path.Join(dir, uriPath)
+		}
+
+		separator := "?"
+		if strings.Contains(uri, "?") {
+			separator = "&"
+		}
+		return uri + separator + playback.SignURL(secret, resolved, expiresAt, playback.SignOptions{IP: ip}).Encode()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			// Keep empty lines as they are
+		case !strings.HasPrefix(line, "#"):
+			line = signURI(line)
+		default:
+			// Rewrite the URI attribute of tags like #EXT-X-KEY, #EXT-X-MEDIA or #EXT-X-MAP
+			if start := strings.Index(line, `URI="`); start != -1 {
+				start += len(`URI="`)
+				if end := strings.Index(line[start:], `"`); end != -1 {
+					line = line[:start] + signURI(line[start:start+end]) + line[start+end:]
+				}
+			}
+		}
+
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+
+	return out.Bytes()
+}
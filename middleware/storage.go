@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/helper"
+	"github.com/nvj9singhnavjot/media-docker/pkg"
+	"github.com/nvj9singhnavjot/media-docker/playback"
+)
+
+// maxRedirectExpiry is the lifetime of the presigned URLs of StorageRedirect,
+// shortened to the expiry of the URL signature of signed requests.
+const maxRedirectExpiry = time.Hour
+
+// storageFileSystem is an http.FileSystem of the objects of a pkg.Storage, used by FileServer
+// to serve media files from an object storage. Directories are never opened, so they are not listed.
+type storageFileSystem struct {
+	storage pkg.Storage
+}
+
+// StorageFileSystem returns an http.FileSystem serving the objects of storage by their key,
+// e.g. "/videos/<id>/index.m3u8" serves the object "videos/<id>/index.m3u8".
+func StorageFileSystem(storage pkg.Storage) http.FileSystem {
+	return storageFileSystem{storage: storage}
+}
+
+// Open stats the object of name, its content is only read once the file is read.
+func (s storageFileSystem) Open(name string) (http.File, error) {
+	key := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if key == "" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	info, err := s.storage.Stat(key)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &storageFile{storage: s.storage, info: info}, nil
+}
+
+// storageFile is an object of a pkg.Storage opened by storageFileSystem. It is read with a single request
+// from the current offset, a seek closes the request, so ranges are only downloaded from their start.
+type storageFile struct {
+	storage pkg.Storage
+	info    *pkg.ObjectInfo
+	offset  int64         // Offset of the next read
+	body    io.ReadCloser // Body of the running request, nil before the first read and after seeks
+}
+
+func (f *storageFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.Size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.storage.Get(f.info.Key, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *storageFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the object")
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *storageFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *storageFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.info.Key, Err: fs.ErrInvalid}
+}
+
+func (f *storageFile) Stat() (fs.FileInfo, error) {
+	return storageFileInfo{f.info}, nil
+}
+
+// storageFileInfo is the fs.FileInfo of an object, objects are regular files.
+type storageFileInfo struct {
+	info *pkg.ObjectInfo
+}
+
+func (i storageFileInfo) Name() string       { return path.Base(i.info.Key) }
+func (i storageFileInfo) Size() int64        { return i.info.Size }
+func (i storageFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i storageFileInfo) ModTime() time.Time { return i.info.ModTime }
+func (i storageFileInfo) IsDir() bool        { return false }
+func (i storageFileInfo) Sys() any           { return nil }
+
+// StorageRedirect is a middleware function that redirects requests for media files below prefix
+// to presigned URLs of the storage, so segments and images are downloaded from the object storage directly.
+// Playlists are passed to the next handler, so they can be rewritten by SignedURL and keep relative URIs
+// to the client, as are other text manifests compressed by CompressManifests, and HLS keys, which are not stored in the storage.
+//
+// INFO: It must be registered after SignedURL, only verified requests are redirected.
+func StorageRedirect(storage pkg.Storage, prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, found := strings.CutPrefix(r.URL.Path, prefix)
+			ext := strings.ToLower(path.Ext(key))
+			if !found || playlistExtensions[ext] || compressible(ext) || path.Base(key) == pkg.HLSKeyName || strings.Contains(r.URL.Path, "/.") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// A presigned URL must not outlive the URL signature of the request
+			expiry := maxRedirectExpiry
+			if expiresAt, signed := playback.SignedRequestExpiry(r.Context()); signed {
+				expiry = min(expiry, time.Until(expiresAt))
+			}
+			if expiry < time.Second {
+				helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusForbidden, "url signature expired", nil)
+				return
+			}
+
+			// Objects are not checked, missing objects are answered by the object storage
+			presigned, err := storage.Presign(key, expiry)
+			if err != nil {
+				helper.ErrorResponse(w, helper.GetRequestID(r), http.StatusInternalServerError, "error presigning url", err)
+				return
+			}
+
+			// The presigned URL expires, so the redirect itself is not cached
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, presigned, http.StatusFound)
+		})
+	}
+}
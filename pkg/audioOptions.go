@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// audioFormat holds the file extension and the ffmpeg encoder and muxer arguments of an audio format.
+type audioFormat struct {
+	extension  string
+	args       []string
+	cover      bool // The muxer embeds cover art
+	streamTags bool // The muxer writes the tags of the audio stream instead of the global tags
+}
+
+// audioFormats holds every supported audio output format.
+//
+// INFO: Opus only supports 48 kHz (and lower) sample rates, all other formats are written at 44.1 kHz.
+// The ogg muxer of ffmpeg does not write cover art, Opus outputs only keep the tags.
+var audioFormats = map[string]audioFormat{
+	"mp3":  {".mp3", []string{"-c:a", "libmp3lame", "-ar", "44100", "-f", "mp3"}, true, false},
+	"aac":  {".m4a", []string{"-c:a", "aac", "-ar", "44100", "-movflags", "+faststart", "-f", "mp4"}, true, false},
+	"opus": {".opus", []string{"-c:a", "libopus", "-ar", "48000", "-f", "ogg"}, false, true},
+}
+
+// AudioProcessing holds the per-job processing applied to every audio output of an audio job.
+type AudioProcessing struct {
+	Loudness *Loudness // Optional loudness normalization, nil keeps the audio level
+	Tags     AudioTags // Tags written into the outputs, replacing the metadata of the source
+	Cover    string    // Optional path of the jpeg cover art embedded into the outputs
+}
+
+// inputArgs returns the ffmpeg input arguments of the audio and the optional cover art image.
+func (p AudioProcessing) inputArgs(audioPath string) []string {
+	args := []string{"-i", audioPath} // Input audio file path
+	if p.Cover != "" {
+		args = append(args, "-i", p.Cover) // Input cover art image
+	}
+	return args
+}
+
+// AudioHLSDir is the directory of the HLS audio renditions in the media directory of an audio,
+// every bitrate is written to "hls/<bitrate>/index.m3u8" and referenced by "hls/master.m3u8".
+const AudioHLSDir = "hls"
+
+// AudioHLSMasterPlaylist is the name of the master playlist of the HLS audio renditions.
+const AudioHLSMasterPlaylist = "master.m3u8"
+
+// AudioOutput is an audio output of an audio job, written to "audios/<id>/<bitrate>.<ext>".
+type AudioOutput struct {
+	Format  string `json:"format"`  // Format of the output: "mp3", "aac" or "opus"
+	Bitrate string `json:"bitrate"` // Bitrate of the output, e.g. "128k", empty uses the encoder default
+}
+
+// FileName returns the file name of the output inside the media directory, e.g. "128k.m4a".
+func (o AudioOutput) FileName() string {
+	return o.Bitrate + audioFormats[o.Format].extension
+}
+
+// AudioOutputsFromMessage returns the additional outputs of an audio job, or nil if the job has none.
+func AudioOutputsFromMessage(outputs []topics.AudioOutput) []AudioOutput {
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	list := make([]AudioOutput, len(outputs))
+	for i, output := range outputs {
+		list[i] = AudioOutput{Format: output.Format, Bitrate: output.Bitrate}
+	}
+	return list
+}
+
+// AudioHLSPlaylist returns the path of the HLS master playlist inside the media directory, "hls/master.m3u8".
+func AudioHLSPlaylist() string {
+	return AudioHLSDir + "/" + AudioHLSMasterPlaylist
+}
+
+// MainAudioOutput returns the main output of an audio job, the mp3 served as "audios/<id>.mp3",
+// with the optional bitrate of the job.
+func MainAudioOutput(bitrate *string) AudioOutput {
+	output := AudioOutput{Format: "mp3"}
+	if bitrate != nil {
+		output.Bitrate = *bitrate
+	}
+	return output
+}
+
+// NewAudioMetadata returns the metadata of a converted audio, recording its outputs, loudness, tags and cover art.
+// The placeholder is the one of the cover art, nil if the audio has no cover art.
+func NewAudioMetadata(id string, outputs []AudioOutput, hlsPlaylist string, processing AudioProcessing, placeholder *topics.Placeholder) *MediaMetadata {
+	metadata := &MediaMetadata{
+		ID:          id,
+		Type:        "audio",
+		Extension:   ".mp3",
+		Waveform:    WaveformFileName,
+		Outputs:     outputs,
+		HLS:         hlsPlaylist,
+		Loudness:    processing.Loudness,
+		Placeholder: placeholder,
+		CreatedAt:   time.Now(),
+	}
+	if !processing.Tags.IsEmpty() {
+		metadata.Tags = &processing.Tags
+	}
+	if processing.Cover != "" {
+		metadata.Cover = CoverArtFileName
+	}
+	return metadata
+}
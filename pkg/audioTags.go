@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"strings"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// CoverArtFileName is the name of the cover art image in the media directory of an audio.
+const CoverArtFileName = "cover.jpeg"
+
+// AudioTags holds the tags of an audio, read from the upload and written into every output.
+type AudioTags struct {
+	Title       string `json:"title,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	AlbumArtist string `json:"albumArtist,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+	Date        string `json:"date,omitempty"`  // Release date or year, e.g. "2024"
+	Track       string `json:"track,omitempty"` // Track number, optionally with the total, e.g. "3/12"
+	Disc        string `json:"disc,omitempty"`  // Disc number, optionally with the total, e.g. "1/2"
+	Composer    string `json:"composer,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// audioTagField is a tag of AudioTags with its ffmpeg metadata key.
+type audioTagField struct {
+	key   string
+	value *string
+}
+
+// fields returns every tag of t with its ffmpeg metadata key, in a stable order.
+func (t *AudioTags) fields() []audioTagField {
+	return []audioTagField{
+		{"title", &t.Title},
+		{"artist", &t.Artist},
+		{"album", &t.Album},
+		{"album_artist", &t.AlbumArtist},
+		{"genre", &t.Genre},
+		{"date", &t.Date},
+		{"track", &t.Track},
+		{"disc", &t.Disc},
+		{"composer", &t.Composer},
+		{"comment", &t.Comment},
+	}
+}
+
+// audioTagAliases maps the keys of Vorbis comments and ID3 frames not converted by ffmpeg
+// to the ffmpeg metadata key of the tag.
+var audioTagAliases = map[string]string{
+	"albumartist":  "album_artist",
+	"album artist": "album_artist",
+	"tracknumber":  "track",
+	"discnumber":   "disc",
+	"year":         "date",
+	"description":  "comment",
+}
+
+// IsEmpty reports whether t has no tag.
+func (t AudioTags) IsEmpty() bool {
+	return t == AudioTags{}
+}
+
+// metadataArgs returns the ffmpeg arguments writing the tags into an output, replacing the metadata of the source.
+// With streamTags the tags are written on the audio stream too, as the ogg muxer writes the Vorbis comments of the stream.
+func (t AudioTags) metadataArgs(streamTags bool) []string {
+	args := []string{"-map_metadata", "-1"} // Drop the metadata of the source, only the tags are written
+	for _, field := range t.fields() {
+		if *field.value == "" {
+			continue
+		}
+		tag := field.key + "=" + *field.value
+		args = append(args, "-metadata", tag)
+		if streamTags {
+			args = append(args, "-metadata:s:a:0", tag)
+		}
+	}
+	return args
+}
+
+// AudioProbe holds the tags and cover art of an audio read by ProbeAudio.
+type AudioProbe struct {
+	Tags        AudioTags // Tags of the audio
+	CoverStream int       // Index of the stream holding the cover art, -1 if the audio has no cover art
+}
+
+// ProbeAudio reads the tags and the cover art stream of the audio at audioPath with ffprobe.
+//
+// The tags are read from the container (ID3, MP4 and FLAC), and missing tags from the first audio stream,
+// as Ogg files store their Vorbis comments on the stream. Keys are matched case-insensitively.
+func ProbeAudio(audioPath string) (*AudioProbe, error) {
+	var probe struct {
+		Streams []struct {
+			Index       int               `json:"index"`
+			CodecType   string            `json:"codec_type"`
+			Tags        map[string]string `json:"tags"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+
+	if err := runProbe(&probe,
+		"-show_entries", "stream=index,codec_type:stream_tags:stream_disposition=attached_pic:format_tags",
+		audioPath,
+	); err != nil {
+		return nil, err
+	}
+
+	audio := &AudioProbe{CoverStream: -1}
+	audio.Tags.apply(probe.Format.Tags)
+
+	audioStreamTagged := false
+	for _, stream := range probe.Streams {
+		switch {
+		case stream.CodecType == "audio" && !audioStreamTagged:
+			audio.Tags.apply(stream.Tags)
+			audioStreamTagged = true
+		case stream.CodecType == "video" && stream.Disposition.AttachedPic == 1 && audio.CoverStream < 0:
+			audio.CoverStream = stream.Index
+		}
+	}
+
+	return audio, nil
+}
+
+// apply sets every tag of t which is still empty from the ffprobe tags.
+func (t *AudioTags) apply(tags map[string]string) {
+	values := make(map[string]string, len(tags))
+	for key, value := range tags {
+		key = strings.ToLower(key)
+		if alias, ok := audioTagAliases[key]; ok {
+			key = alias
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			values[key] = value
+		}
+	}
+
+	for _, field := range t.fields() {
+		if *field.value == "" {
+			*field.value = values[field.key]
+		}
+	}
+}
+
+// AudioTagsFromMessage returns the tags of the upload with every non-nil tag of the job applied,
+// an empty string removes the tag.
+func AudioTagsFromMessage(source AudioTags, tags *topics.AudioTags) AudioTags {
+	if tags == nil {
+		return source
+	}
+
+	overrides := []*string{
+		tags.Title, tags.Artist, tags.Album, tags.AlbumArtist, tags.Genre,
+		tags.Date, tags.Track, tags.Disc, tags.Composer, tags.Comment,
+	}
+	for i, field := range source.fields() {
+		if overrides[i] != nil {
+			*field.value = strings.TrimSpace(*overrides[i])
+		}
+	}
+	return source
+}
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// base83Chars is the alphabet of the base 83 encoding used by BlurHash.
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBase83 encodes value with exactly length base 83 digits.
+func encodeBase83(value, length int) string {
+	var sb strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		sb.WriteByte(base83Chars[digit])
+	}
+	return sb.String()
+}
+
+// sRGBToLinear converts an sRGB channel value to linear light.
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear light value to an sRGB channel value.
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow raises the absolute value to exp, keeping the sign of value.
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+// EncodeBlurHash encodes the RGB pixels (3 bytes per pixel, row by row) of an image as a BlurHash
+// with xComponents x yComponents components, each between 1 and 9.
+//
+// See https://github.com/woltapp/blurhash/blob/master/Algorithm.md for the algorithm.
+func EncodeBlurHash(pixels []byte, width, height, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9")
+	}
+	if len(pixels) != width*height*3 {
+		return "", fmt.Errorf("invalid pixel data for %dx%d image", width, height)
+	}
+
+	// Compute the DCT factors of every component in linear light
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					p := (y*width + x) * 3
+					r += basis * sRGBToLinear(pixels[p])
+					g += basis * sRGBToLinear(pixels[p+1])
+					b += basis * sRGBToLinear(pixels[p+2])
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	dc, ac := factors[0], factors[1:]
+	hash := encodeBase83((xComponents-1)+(yComponents-1)*9, 1)
+
+	// Quantise the maximum AC value, which scales all AC components
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			for _, value := range factor {
+				actualMaximum = math.Max(actualMaximum, math.Abs(value))
+			}
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash += encodeBase83(quantisedMaximum, 1)
+	} else {
+		hash += encodeBase83(0, 1)
+	}
+
+	// The DC component is the average colour in sRGB
+	hash += encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+
+	for _, factor := range ac {
+		quantised := [3]int{}
+		for c, value := range factor {
+			quantised[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		hash += encodeBase83(quantised[0]*19*19+quantised[1]*19+quantised[2], 2)
+	}
+
+	return hash, nil
+}
+
+// DominantColor returns the most common colour of the RGB pixels (3 bytes per pixel) as "#rrggbb".
+// Pixels are grouped into buckets of similar colours, and the average colour of the largest bucket is returned.
+func DominantColor(pixels []byte) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+
+	// 4 bits per channel, 4096 buckets
+	buckets := map[int]*bucket{}
+	var largest *bucket
+	for p := 0; p+2 < len(pixels); p += 3 {
+		r, g, b := int(pixels[p]), int(pixels[p+1]), int(pixels[p+2])
+		key := (r>>4)<<8 | (g>>4)<<4 | b>>4
+
+		current, ok := buckets[key]
+		if !ok {
+			current = &bucket{}
+			buckets[key] = current
+		}
+		current.count++
+		current.r += r
+		current.g += g
+		current.b += b
+
+		if largest == nil || current.count > largest.count {
+			largest = current
+		}
+	}
+
+	if largest == nil {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", largest.r/largest.count, largest.g/largest.count, largest.b/largest.count)
+}
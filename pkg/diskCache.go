@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// cacheEntry is a file stored in a DiskCache.
+type cacheEntry struct {
+	name string // File name inside the cache directory
+	size int64  // Size of the file in bytes
+}
+
+// cacheCall is an in-flight creation of a cache entry, shared by concurrent requests for the same key.
+type cacheCall struct {
+	done chan struct{} // Closed once the creation has finished
+	err  error         // Error returned by the creation
+}
+
+// DiskCache is a size bounded least recently used cache of files in a directory.
+// Files are created on demand by GetOrCreate, and the least recently used files
+// are removed once the total size of the cache exceeds maxBytes.
+type DiskCache struct {
+	dir      string                   // Directory where the cached files are stored
+	maxBytes int64                    // Maximum total size of the cached files
+	mu       sync.Mutex               // Guards all fields below
+	size     int64                    // Current total size of the cached files
+	order    *list.List               // Cache entries, most recently used first
+	entries  map[string]*list.Element // Cache entries by file name
+	inflight map[string]*cacheCall    // Creations in progress by file name
+}
+
+// NewDiskCache creates a DiskCache in dir, creating the directory if it does not exist.
+// Files already present in dir are added to the cache, ordered by their modification time,
+// so the cache survives restarts of the service.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %w", err)
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*cacheCall),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache directory: %w", err)
+	}
+
+	files := make([]os.FileInfo, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// Remove leftovers of creations interrupted by a restart
+		if filepath.Ext(info.Name()) == ".tmp" {
+			os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+		files = append(files, info)
+	}
+
+	// Oldest files first, so the most recently written file ends up at the front
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		c.add(info.Name(), info.Size())
+	}
+	c.evict()
+
+	return c, nil
+}
+
+// GetOrCreate returns the path of the cached file name, creating it with create if it is not cached.
+// create receives a temporary path to write the file to, which is moved into the cache on success.
+// Concurrent calls for the same name share a single creation.
+func (c *DiskCache) GetOrCreate(name string, create func(path string) error) (string, error) {
+	path := filepath.Join(c.dir, name)
+
+	c.mu.Lock()
+	if element, ok := c.entries[name]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return path, nil
+	}
+	if call, ok := c.inflight[name]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return path, call.err
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[name] = call
+	c.mu.Unlock()
+
+	call.err = c.create(name, path, create)
+
+	c.mu.Lock()
+	delete(c.inflight, name)
+	c.mu.Unlock()
+	close(call.done)
+
+	return path, call.err
+}
+
+// create runs create on a temporary file and moves the result to path.
+func (c *DiskCache) create(name, path string, create func(path string) error) error {
+	tmpPath := path + ".tmp"
+	if err := create(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error reading created cache file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error moving created cache file: %w", err)
+	}
+
+	c.mu.Lock()
+	c.add(name, info.Size())
+	c.evict()
+	c.mu.Unlock()
+
+	return nil
+}
+
+// add adds the file to the front of the cache. c.mu must be held.
+func (c *DiskCache) add(name string, size int64) {
+	c.entries[name] = c.order.PushFront(&cacheEntry{name: name, size: size})
+	c.size += size
+}
+
+// evict removes the least recently used files until the cache fits into maxBytes.
+// The most recently used file is always kept, even if it exceeds maxBytes on its own. c.mu must be held.
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes && c.order.Len() > 1 {
+		element := c.order.Back()
+		entry := element.Value.(*cacheEntry)
+		c.order.Remove(element)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+		// NOTE: A file which is being served while it is removed stays readable until it is closed.
+		os.Remove(filepath.Join(c.dir, entry.name))
+	}
+}
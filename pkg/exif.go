@@ -0,0 +1,100 @@
+package pkg
+
+import "strconv"
+
+// orientationFilters maps the EXIF orientation values to the ffmpeg filters displaying the image upright.
+// Orientation 1 (or a missing orientation) needs no correction.
+var orientationFilters = map[int]string{
+	2: "hflip",       // Mirrored horizontally
+	3: "hflip,vflip", // Rotated 180°
+	4: "vflip",       // Mirrored vertically
+	5: "transpose=0", // Mirrored horizontally and rotated 270° clockwise
+	6: "transpose=1", // Rotated 90° clockwise
+	7: "transpose=3", // Mirrored horizontally and rotated 90° clockwise
+	8: "transpose=2", // Rotated 270° clockwise
+}
+
+// cameraTags are the EXIF fields recorded as camera metadata of an image, in the hidden CameraFileName.
+//
+// INFO: GPS fields are never recorded.
+var cameraTags = []string{
+	"Make",
+	"Model",
+	"LensMake",
+	"LensModel",
+	"Software",
+	"DateTimeOriginal",
+	"ExposureTime",
+	"FNumber",
+	"ISOSpeedRatings",
+	"FocalLength",
+	"Flash",
+}
+
+// CameraFileName is the name of the file holding the camera metadata of an image in its media directory.
+// It is hidden, so it is never served by media-docker-client, unlike metadata.json, and is only returned by the server.
+const CameraFileName = ".camera.json"
+
+// copyrightTags are the EXIF fields recorded when an image job opts in to keep the copyright.
+// They are only recorded in the metadata, the image muxers of ffmpeg do not write EXIF into the outputs.
+var copyrightTags = []string{"Copyright", "Artist"}
+
+// ImageSourceMetadata holds the metadata of an uploaded image, recorded before it is stripped from the output.
+type ImageSourceMetadata struct {
+	Width       int               `json:"width"`               // Width of the upload in pixels, as stored
+	Height      int               `json:"height"`              // Height of the upload in pixels, as stored
+	Orientation int               `json:"orientation"`         // EXIF orientation of the upload, 1 is upright
+	Frames      int               `json:"frames,omitempty"`    // Number of frames of an animated upload, 0 for still images
+	Duration    float64           `json:"duration,omitempty"`  // Duration of an animated upload in seconds
+	Camera      map[string]string `json:"-"`                   // Camera EXIF fields, e.g. Make, Model, ExposureTime, written to CameraFileName by WriteMetadata
+	Copyright   map[string]string `json:"copyright,omitempty"` // Copyright and Artist, only if the job keeps the copyright
+}
+
+// ImageSourceFromProbe extracts the source metadata of an image from its probe.
+// The copyright fields are only included if keepCopyright is set.
+func ImageSourceFromProbe(probe *ImageProbe, keepCopyright bool) *ImageSourceMetadata {
+	source := &ImageSourceMetadata{
+		Width:       probe.Width,
+		Height:      probe.Height,
+		Orientation: 1,
+		Camera:      pickTags(probe.Tags, cameraTags),
+	}
+
+	if probe.Frames > 1 {
+		source.Frames = probe.Frames
+		source.Duration = probe.Duration
+	}
+
+	if orientation, err := strconv.Atoi(probe.Tags["Orientation"]); err == nil && orientation >= 1 && orientation <= 8 {
+		source.Orientation = orientation
+	}
+
+	if keepCopyright {
+		source.Copyright = pickTags(probe.Tags, copyrightTags)
+	}
+
+	return source
+}
+
+// DisplayWidth returns the width of the upload once its EXIF orientation is applied,
+// orientations 5 to 8 rotate the image by 90 degrees, so its height becomes the width.
+func (s *ImageSourceMetadata) DisplayWidth() int {
+	if s.Orientation >= 5 {
+		return s.Height
+	}
+	return s.Width
+}
+
+// pickTags returns the non-empty values of the keys in tags, or nil if none of the keys is set.
+func pickTags(tags map[string]string, keys []string) map[string]string {
+	var picked map[string]string
+	for _, key := range keys {
+		if value := tags[key]; value != "" {
+			if picked == nil {
+				picked = map[string]string{}
+			}
+			picked[key] = value
+		}
+	}
+	return picked
+}
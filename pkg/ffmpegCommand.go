@@ -5,6 +5,8 @@ import (
 	// "io"
 	// "os"
 	"os/exec"
+	"strconv"
+	"strings"
 )
 
 // runCommand runs the provided command and returns an error if it fails.
@@ -52,17 +54,25 @@ func runCommand(cmd *exec.Cmd) error {
 // It accepts the following parameters:
 //   - videoPath: the path to the input video file to be converted.
 //   - outputPath: the directory where the converted video segments and playlist will be saved.
+//   - hls: the HLS options used for segment duration, segment naming and playlist type.
+//   - loudness: the optional loudness normalization of the audio, nil keeps the audio level.
+//   - watermark: the optional watermark burned into the video, nil writes no watermark.
 //   - quality: an optional parameter that adjusts the video and audio bitrates.
 //     If a quality value between 40 and 100 is provided, it calculates the corresponding
 //     bitrates for video and audio. If no quality is specified, the video retains its existing quality.
 //
-// The function generates a playlist (index.m3u8) and segments the video into 10-second chunks.
-func ConvertVideo(videoPath, outputPath string, quality ...int) error {
+// The function generates a playlist (index.m3u8) and segments the video into hls.SegmentDuration chunks.
+func ConvertVideo(videoPath, outputPath string, hls HLSOptions, loudness *Loudness, watermark *WatermarkProfile, quality ...int) error {
 	var args []string
 
 	// Add input video file, video codec (libx264), and audio codec (aac) to the arguments
 	args = append(args, "-i", videoPath, "-codec:v", "libx264", "-codec:a", "aac")
 
+	// Burn the watermark into every frame
+	if watermark != nil {
+		args = append(args, "-vf", watermark.filter("w"))
+	}
+
 	// If quality is specified, calculate the video and audio bitrates based on the quality value
 	if len(quality) > 0 {
 		q := quality[0]
@@ -71,14 +81,15 @@ func ConvertVideo(videoPath, outputPath string, quality ...int) error {
 		args = append(args, "-b:v", videoBitrate, "-b:a", audioBitrate)
 	}
 
-	// Add arguments specific to HLS (HTTP Live Streaming) format
-	args = append(args,
-		"-hls_time", "10", // Split video into segments of 10 seconds each
-		"-hls_playlist_type", "vod", // Define the playlist as Video on Demand (VOD)
-		"-hls_segment_filename", fmt.Sprintf("%s/segment%%03d.ts", outputPath), // Define segment file name pattern
-		"-start_number", "0", // Start segment numbering from 0
-		fmt.Sprintf("%s/index.m3u8", outputPath), // Output the HLS playlist file as index.m3u8
-	)
+	// Normalize the loudness of the audio, resampled to 48000 Hz after loudnorm
+	if loudness != nil {
+		args = append(args, loudness.audioFilterArgs()...)
+		args = append(args, "-ar", "48000")
+	}
+
+	// Add encoder arguments (forced keyframes) and arguments specific to HLS (HTTP Live Streaming) format
+	args = append(args, hls.encoderArgs()...)
+	args = append(args, hls.muxerArgs(outputPath)...)
 
 	// Execute the ffmpeg command with the constructed arguments
 	return runCommand(exec.Command("ffmpeg", args...))
@@ -93,64 +104,384 @@ var heights = map[string]string{"360": "740", "480": "854", "720": "1280", "1080
 //   - videoPath: the path to the input video file to be converted.
 //   - outputPath: the directory where the converted video segments and playlist will be saved.
 //   - resolution: the desired resolution to which the video will be scaled.
+//   - hls: the HLS options used for segment duration, segment naming and playlist type.
+//   - loudness: the optional loudness normalization of the audio, nil keeps the audio level.
+//   - watermark: the optional watermark burned into the video, nil writes no watermark.
 //
 // The video is scaled to the specified resolution using a video filter and converted to HLS format.
-func ConvertVideoResolutions(videoPath, outputPath string, resolution string) error {
-	return runCommand(exec.Command("ffmpeg",
+// The watermark is burned after scaling, so it has the same relative size in every resolution.
+func ConvertVideoResolutions(videoPath, outputPath string, resolution string, hls HLSOptions, loudness *Loudness, watermark *WatermarkProfile) error {
+	filters := []string{fmt.Sprintf("scale=%s:%s", heights[resolution], resolution)} // Scale the video to the specified resolution
+	if watermark != nil {
+		filters = append(filters, watermark.filter("w")) // Burn the watermark into every frame
+	}
+
+	args := []string{
 		"-i", videoPath, // Input video file path
 		"-codec:v", "libx264", // Use the H.264 video codec for video conversion
 		"-codec:a", "aac", // Use AAC for audio codec
-		"-vf", fmt.Sprintf("scale=%s:%s", heights[resolution], resolution), // Scale the video to the specified resolution
-		"-hls_time", "10", // Split video into 10-second segments
-		"-hls_playlist_type", "vod", // Define the playlist as Video on Demand (VOD)
-		"-hls_segment_filename", fmt.Sprintf("%s/segment%%03d.ts", outputPath), // Define segment file name pattern
-		"-start_number", "0", // Start segment numbering from 0
-		fmt.Sprintf("%s/index.m3u8", outputPath), // Output the HLS playlist file
-	))
+		"-vf", strings.Join(filters, ","), // Scale the video and burn the watermark
+	}
+
+	// Normalize the loudness of the audio, resampled to 48000 Hz after loudnorm
+	if loudness != nil {
+		args = append(args, loudness.audioFilterArgs()...)
+		args = append(args, "-ar", "48000")
+	}
+
+	// Add encoder arguments (forced keyframes) and arguments specific to HLS (HTTP Live Streaming) format
+	args = append(args, hls.encoderArgs()...)
+	args = append(args, hls.muxerArgs(outputPath)...)
+
+	// Execute the ffmpeg command with the constructed arguments
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// ConvertVideoPart converts a time range of a video file to HLS format using ffmpeg.
+// It accepts the following parameters:
+//   - videoPath: the path to the input video file, e.g. the HLS playlist of a stored video.
+//   - outputPath: the directory where the converted video segments and playlist will be saved.
+//   - start, end: the range of the video in seconds.
+//   - hls: the HLS options used for segment duration, segment naming and playlist type.
+//
+// The input is seeked to the start, so only the range is decoded. Only the first video and audio stream are kept,
+// and the timestamps of the output start at 0.
+func ConvertVideoPart(videoPath, outputPath string, start, end float64, hls HLSOptions) error {
+	args := []string{
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64), // Seek to the start of the range
+		"-t", strconv.FormatFloat(end-start, 'f', 3, 64), // Read the range only
+		"-i", videoPath, // Input video file
+		"-map", "0:v:0", // First video stream
+		"-map", "0:a:0?", // First audio stream, if the video has one
+		"-codec:v", "libx264", // Use the H.264 video codec for video conversion
+		"-codec:a", "aac", // Use AAC for audio codec
+	}
+
+	// Add encoder arguments (forced keyframes) and arguments specific to HLS (HTTP Live Streaming) format
+	args = append(args, hls.encoderArgs()...)
+	args = append(args, hls.muxerArgs(outputPath)...)
+
+	return runCommand(exec.Command("ffmpeg", args...))
 }
 
-// ConvertImage converts an image file using ffmpeg by applying compression.
+// ConvertImage converts an image file using ffmpeg by applying the image options.
 // It accepts the following parameters:
 //   - imagePath: the path to the input image file.
-//   - outputPath: the path where the compressed image will be saved.
-//   - compression: a string representing the compression level, ranging from 1 (highest quality) to 31 (lowest quality).
+//   - outputPath: the path where the converted image will be saved.
+//   - image: the output format, quality and optional resizing of the image.
 //
-// The function applies the specified compression level and generates the output image.
-func ConvertImage(imagePath, outputPath, compression string) error {
-	return runCommand(exec.Command("ffmpeg",
+// The output format is taken from image.Format, not from the extension of outputPath.
+// Images are never upscaled with the "contain" fit, so Width and Height act as maximum dimensions.
+// PNG, WebP and GIF keep the transparency of the source image.
+// Animated sources (image.Animated) stay animated in WebP and GIF, other formats use the first frame.
+// The EXIF orientation of image.Orientation is applied to the pixels, and all metadata of the source
+// (EXIF, XMP, IPTC) is stripped, so phone photos are upright and do not leak their location.
+func ConvertImage(imagePath, outputPath string, image ImageOptions) error {
+	encoder, err := image.encoderArgs(image.Format)
+	if err != nil {
+		return err
+	}
+
+	quality := image.Quality
+	if quality == 0 {
+		quality = DefaultImageQuality
+	}
+
+	args := []string{
+		"-noautorotate", // The EXIF orientation is applied by the filters of the options
 		"-i", imagePath, // Input image file
-		"-q:v", compression, // Set the image compression level
-		outputPath, // Output image file path
-	))
+	}
+
+	if filters := image.filters(image.scaleFilter(), "p"); len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ",")) // Orient, resize and convert the image
+	}
+
+	args = append(args, image.metadataArgs()...)                    // Strip the metadata of the source
+	args = append(args, encoder...)                                 // Set the encoder and muxer of the output format
+	args = append(args, imageQualityArgs(image.Format, quality)...) // Set the encoder quality
+	args = append(args, "-y", outputPath)                           // Output image file, overwriting leftovers
+
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// ConvertImageVariants writes responsive variants of an image in a single ffmpeg run.
+// It accepts the following parameters:
+//   - imagePath: the path to the input image file.
+//   - outputDir: the directory where the variants are saved, as "<width>.<ext>".
+//   - image: the quality, orientation and watermark of the variants, its format and size are ignored.
+//   - variants: the widths and formats of the variants.
+//
+// The image is decoded once and split into one scaled stream per variant. Variants keep the aspect ratio
+// of the image and are never upscaled, so a variant of a smaller image has the width of the image.
+func ConvertImageVariants(imagePath, outputDir string, image ImageOptions, variants []ImageVariant) error {
+	quality := image.Quality
+	if quality == 0 {
+		quality = DefaultImageQuality
+	}
+
+	// Split the decoded image into one labelled stream per variant
+	labels := make([]string, len(variants))
+	for i := range variants {
+		labels[i] = fmt.Sprintf("[s%d]", i)
+	}
+	filters := []string{fmt.Sprintf("[0:v]split=%d%s", len(variants), strings.Join(labels, ""))}
+
+	outputs := []string{}
+	for i, variant := range variants {
+		encoder, err := image.encoderArgs(variant.Format)
+		if err != nil {
+			return err
+		}
+
+		// Orient, scale and convert the stream of the variant
+		options := image
+		options.Format = variant.Format
+		variantFilters := options.filters(ImageOptions{Width: variant.Width}.scaleFilter(), fmt.Sprintf("p%d", i))
+		filters = append(filters, fmt.Sprintf("[s%d]%s[v%d]", i, strings.Join(variantFilters, ","), i))
+
+		outputs = append(outputs, "-map", fmt.Sprintf("[v%d]", i))              // Map the scaled stream of the variant
+		outputs = append(outputs, image.metadataArgs()...)                      // Strip the metadata of the source
+		outputs = append(outputs, encoder...)                                   // Set the encoder and muxer of the variant
+		outputs = append(outputs, imageQualityArgs(variant.Format, quality)...) // Set the encoder quality
+		outputs = append(outputs, "-y", fmt.Sprintf("%s/%s", outputDir, variant.FileName()))
+	}
+
+	args := []string{
+		"-noautorotate", // The EXIF orientation is applied by the filters of the options
+		"-i", imagePath, // Input image file
+		"-filter_complex", strings.Join(filters, ";"), // Scale every variant from the same decoded image
+	}
+	args = append(args, outputs...)
+
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// videoPreviewEncoders holds the ffmpeg encoder and muxer arguments for every preview format.
+var videoPreviewEncoders = map[string][]string{
+	"mp4":  {"-c:v", "libx264", "-preset", "veryfast", "-crf", "28", "-movflags", "+faststart", "-f", "mp4"},
+	"webp": {"-c:v", "libwebp_anim", "-loop", "0", "-quality", "60", "-f", "webp"},
+}
+
+// ConvertVideoPreview writes a short preview of a video using ffmpeg.
+// It accepts the following parameters:
+//   - videoPath: the path to the input video file.
+//   - outputDir: the directory where the preview is saved, as "preview.<ext>" for every format.
+//   - duration: the duration of the video in seconds, used to spread the clips over the video.
+//   - preview: the number and duration of the clips, the width and the formats of the preview.
+//
+// Every clip is read by seeking its own input, so only the clips are decoded instead of the whole video.
+// The clips are scaled, concatenated and written once per format. Previews are muted and run at 15 fps.
+func ConvertVideoPreview(videoPath, outputDir string, duration float64, preview VideoPreviewOptions) error {
+	starts, clipDuration := preview.clips(duration)
+
+	args := []string{}
+	filters := []string{}
+	concat := ""
+	for i, start := range starts {
+		args = append(args,
+			"-ss", strconv.FormatFloat(start, 'f', 3, 64), // Seek to the start of the clip
+			"-t", strconv.FormatFloat(clipDuration, 'f', 3, 64), // Read a single clip
+			"-i", videoPath, // Input video file
+		)
+		// Scale every clip to the same size and frame rate, which concat requires
+		filters = append(filters, fmt.Sprintf("[%d:v]fps=15,scale=%d:-2,setsar=1,format=yuv420p[c%d]", i, preview.Width, i))
+		concat += fmt.Sprintf("[c%d]", i)
+	}
+	filters = append(filters, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[preview]", concat, len(starts)))
+
+	// Split the preview into one stream per format
+	outputs := []string{}
+	labels := ""
+	for i, format := range preview.Formats {
+		encoder, ok := videoPreviewEncoders[format]
+		if !ok {
+			return fmt.Errorf("unsupported preview format: %s", format)
+		}
+		labels += fmt.Sprintf("[p%d]", i)
+
+		outputs = append(outputs, "-map", fmt.Sprintf("[p%d]", i)) // Map the preview stream of the format
+		outputs = append(outputs, "-an", "-map_metadata", "-1")    // Muted, without the metadata of the source
+		outputs = append(outputs, encoder...)                      // Set the encoder and muxer of the format
+		outputs = append(outputs, "-y", fmt.Sprintf("%s/%s", outputDir, VideoPreviewFileName(format)))
+	}
+	filters = append(filters, fmt.Sprintf("[preview]split=%d%s", len(preview.Formats), labels))
+
+	args = append(args, "-filter_complex", strings.Join(filters, ";")) // Scale, concatenate and split the clips
+	args = append(args, outputs...)
+
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// ExtractPoster writes a poster image of a video as jpeg using ffmpeg.
+// It accepts the following parameters:
+//   - videoPath: the path to the input video file.
+//   - outputPath: the path where the poster image will be saved.
+//
+// The thumbnail filter picks the most representative frame of the first 100 frames,
+// which skips black or faded frames at the start of most videos.
+func ExtractPoster(videoPath, outputPath string) error {
+	args := []string{
+		"-i", videoPath, // Input video file
+		"-vf", "thumbnail=100", // Pick the most representative of the first 100 frames
+		"-frames:v", "1", // Write a single image
+		"-map_metadata", "-1", // Strip the metadata of the source
+	}
+	args = append(args, imageEncoders["jpeg"]...)                         // Set the jpeg encoder and muxer
+	args = append(args, imageQualityArgs("jpeg", DefaultImageQuality)...) // Set the encoder quality
+	args = append(args, "-y", outputPath)                                 // Output image file, overwriting leftovers
+
+	return runCommand(exec.Command("ffmpeg", args...))
 }
 
-// ConvertAudio converts an audio file to a standard format using ffmpeg.
+// ExtractCoverArt writes the cover art stream of an audio as jpeg using ffmpeg.
+// It accepts the following parameters:
+//   - audioPath: the path to the input audio file.
+//   - stream: the index of the stream holding the cover art, see AudioProbe.CoverStream.
+//   - outputPath: the path where the cover art image will be saved.
+func ExtractCoverArt(audioPath string, stream int, outputPath string) error {
+	args := []string{
+		"-i", audioPath, // Input audio file
+		"-map", fmt.Sprintf("0:%d", stream), // Only the cover art stream
+		"-frames:v", "1", // Write a single image
+		"-map_metadata", "-1", // Strip the metadata of the source
+	}
+	args = append(args, imageEncoders["jpeg"]...)                         // Set the jpeg encoder and muxer
+	args = append(args, imageQualityArgs("jpeg", DefaultImageQuality)...) // Set the encoder quality
+	args = append(args, "-y", outputPath)                                 // Output image file, overwriting leftovers
+
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// ConvertAudio converts an audio file to a single audio output using ffmpeg.
 // It accepts the following parameters:
 //   - audioPath: the path to the input audio file to be converted.
 //   - outputPath: the path where the converted audio file will be saved.
-//   - bitrate: an optional parameter to specify the audio bitrate for conversion.
-//     If a bitrate is provided, it will be used; otherwise, ffmpeg defaults will apply.
+//   - output: the format ("mp3", "aac" or "opus") and the optional bitrate of the output.
+//     If no bitrate is provided, the encoder default applies.
+//   - processing: the loudness normalization, tags and cover art applied to the output.
 //
-// The audio is converted with a sample rate of 44100 Hz and 2 channels (stereo).
-// It can handle various audio bitrates such as 128 Kbps for low quality, 192 Kbps for standard quality,
-// 256 Kbps for high quality, and 320 Kbps for maximum quality.
-func ConvertAudio(audioPath, outputPath string, bitrate ...string) error {
-	// Prepare the base arguments for the ffmpeg command
-	args := []string{
-		"-i", audioPath, // Input audio file path
-		"-vn",          // Disable video processing (audio-only conversion)
+// The audio is converted to 2 channels (stereo), at 44100 Hz or 48000 Hz for Opus.
+// The metadata of the source is replaced by processing.Tags, and processing.Cover is embedded into MP3 and AAC outputs.
+// Video streams are dropped.
+func ConvertAudio(audioPath, outputPath string, output AudioOutput, processing AudioProcessing) error {
+	args := processing.inputArgs(audioPath)
+
+	outputArgs, err := audioOutputArgs(output, processing)
+	if err != nil {
+		return err
+	}
+	args = append(args, outputArgs...)
+	args = append(args, "-y", outputPath) // Output audio file, overwriting leftovers
+
+	// Execute the ffmpeg command with the constructed arguments
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// ConvertAudioOutputs writes several audio outputs in a single ffmpeg run, decoding the audio once.
+// It accepts the following parameters:
+//   - audioPath: the path to the input audio file to be converted.
+//   - outputDir: the directory where the outputs are saved, as "<bitrate>.<ext>".
+//   - outputs: the formats and bitrates of the outputs.
+//   - processing: the loudness normalization, tags and cover art applied to every output.
+func ConvertAudioOutputs(audioPath, outputDir string, outputs []AudioOutput, processing AudioProcessing) error {
+	args := processing.inputArgs(audioPath)
+
+	for _, output := range outputs {
+		outputArgs, err := audioOutputArgs(output, processing)
+		if err != nil {
+			return err
+		}
+		args = append(args, outputArgs...)
+		args = append(args, "-y", fmt.Sprintf("%s/%s", outputDir, output.FileName()))
+	}
+
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// ConvertAudioHLS converts an audio file to audio-only HLS renditions using ffmpeg.
+// It accepts the following parameters:
+//   - audioPath: the path to the input audio file to be converted.
+//   - outputPath: the directory where the renditions are saved, as "<bitrate>/index.m3u8",
+//     next to the master playlist AudioHLSMasterPlaylist.
+//   - bitrates: the AAC bitrates of the renditions, e.g. "64k", "128k".
+//   - hls: the HLS options used for segment duration, segment naming and playlist type.
+//   - loudness: the optional loudness normalization of the audio, nil keeps the audio level.
+//
+// All renditions are encoded in a single run and listed in the master playlist,
+// so players can switch between the bitrates of long podcasts.
+func ConvertAudioHLS(audioPath, outputPath string, bitrates []string, hls HLSOptions, loudness *Loudness) error {
+	args := []string{"-i", audioPath} // Input audio file path
+
+	streamMap := make([]string, len(bitrates))
+	for i, bitrate := range bitrates {
+		args = append(args,
+			"-map", "0:a:0", // One audio stream per rendition
+			fmt.Sprintf("-b:a:%d", i), bitrate, // Bitrate of the rendition
+		)
+		streamMap[i] = fmt.Sprintf("a:%d,name:%s", i, bitrate) // The rendition directory is named by its bitrate
+	}
+
+	args = append(args,
+		"-c:a", "aac", // AAC is supported by every HLS player
 		"-ar", "44100", // Set the audio sample rate to 44100 Hz
 		"-ac", "2", // Set the number of audio channels to 2 (stereo)
+	)
+	args = append(args, loudness.audioFilterArgs()...) // Normalize the loudness, resampled by "-ar" above
+	args = append(args,
+		"-var_stream_map", strings.Join(streamMap, " "), // Write one playlist per rendition
+		"-master_pl_name", AudioHLSMasterPlaylist, // Write the master playlist above the rendition directories
+	)
+
+	// Add arguments specific to HLS (HTTP Live Streaming) format, "%v" is replaced by the rendition name
+	args = append(args, hls.muxerArgs(outputPath+"/%v")...)
+
+	return runCommand(exec.Command("ffmpeg", args...))
+}
+
+// audioOutputArgs returns the ffmpeg arguments of an audio output, without the output path.
+// The inputs must be the ones of processing.inputArgs.
+func audioOutputArgs(output AudioOutput, processing AudioProcessing) ([]string, error) {
+	format, ok := audioFormats[output.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported audio format: %s", output.Format)
 	}
 
-	// Append the bitrate option if the user provides one
-	if len(bitrate) > 0 {
-		args = append(args, "-b:a", bitrate[0]) // Set the specified audio bitrate
+	args := []string{"-map", "0:a:0"} // Read the first audio stream, video streams are dropped
+	if processing.Cover != "" && format.cover {
+		args = append(args,
+			"-map", "1:v:0", // Embed the cover art image
+			"-c:v", "copy", // The cover art is already a jpeg
+			"-disposition:v:0", "attached_pic", // Mark the image as cover art
+		)
 	}
+	args = append(args, "-ac", "2")                                         // Set the number of audio channels to 2 (stereo)
+	args = append(args, format.args...)                                     // Set the encoder, sample rate and muxer of the format
+	args = append(args, processing.Loudness.audioFilterArgs()...)           // Normalize the loudness, resampled by the sample rate of the format
+	args = append(args, processing.Tags.metadataArgs(format.streamTags)...) // Write the tags, replacing the metadata of the source
 
-	// Add the output file path to the command arguments
-	args = append(args, outputPath)
+	// Append the bitrate option if the job provides one
+	if output.Bitrate != "" {
+		args = append(args, "-b:a", output.Bitrate) // Set the specified audio bitrate
+	}
+
+	return args, nil
+}
+
+// ConcatAudio joins the ranges of the audio files listed in an ffmpeg concat list without re-encoding, using ffmpeg.
+// It accepts the following parameters:
+//   - listPath: the path to the concat list, with a "file", "inpoint" and optional "outpoint" line per range.
+//   - outputPath: the path where the joined audio file will be saved, in the format of the listed files.
+//
+// Only the audio streams are kept, cover art streams of the listed files are dropped.
+func ConcatAudio(listPath, outputPath string) error {
+	args := []string{
+		"-f", "concat", // Read the ranges with the concat demuxer
+		"-safe", "0", // The list holds absolute paths
+		"-i", listPath, // Input concat list
+		"-map", "0:a", // Only the audio streams
+		"-c", "copy", // Copy the audio frames without re-encoding
+		"-y", outputPath, // Output audio file, overwriting leftovers
+	}
 
-	// Execute the ffmpeg command with the constructed arguments
 	return runCommand(exec.Command("ffmpeg", args...))
 }
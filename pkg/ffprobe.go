@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// ImageProbe holds the properties of an image read by ProbeImage.
+type ImageProbe struct {
+	Width  int               // Width of the stored image in pixels, before the EXIF orientation is applied
+	Height int               // Height of the stored image in pixels, before the EXIF orientation is applied
+	Tags   map[string]string // Metadata of the decoded image, including the EXIF fields (e.g. "Orientation", "Make")
+
+	Frames   int     // Number of frames, more than 1 for animated GIF and WebP images
+	Duration float64 // Duration of an animated image in seconds, 0 if unknown
+}
+
+// runProbe runs ffprobe with the arguments and decodes its json output into target.
+func runProbe(target any, args ...string) error {
+	cmd := exec.Command("ffprobe", append([]string{"-v", "error", "-of", "json"}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("command: %s, %s", cmd.String(), err)
+	}
+
+	if err := json.Unmarshal(output, target); err != nil {
+		return fmt.Errorf("error decoding ffprobe output: %w", err)
+	}
+	return nil
+}
+
+// ProbeImage reads the dimensions, metadata and frame count of the image at imagePath with ffprobe.
+//
+// The metadata is read from the first decoded frame, as the image decoders of ffmpeg
+// export the EXIF fields of jpeg, png and webp images as frame metadata.
+func ProbeImage(imagePath string) (*ImageProbe, error) {
+	var probe struct {
+		Frames []struct {
+			Width  int               `json:"width"`
+			Height int               `json:"height"`
+			Tags   map[string]string `json:"tags"`
+		} `json:"frames"`
+	}
+
+	if err := runProbe(&probe,
+		"-select_streams", "v:0", // Only the image stream
+		"-read_intervals", "%+#1", // Only decode the first frame
+		"-show_entries", "frame=width,height:frame_tags", // Dimensions and metadata of the frame
+		"-show_frames",
+		imagePath,
+	); err != nil {
+		return nil, err
+	}
+
+	if len(probe.Frames) == 0 {
+		return nil, fmt.Errorf("no image frame found: %s", imagePath)
+	}
+
+	frame := probe.Frames[0]
+	if frame.Tags == nil {
+		frame.Tags = map[string]string{}
+	}
+
+	// Count the frames of animated images without decoding them, every frame of an image is one packet
+	var animation struct {
+		Streams []struct {
+			Packets string `json:"nb_read_packets"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := runProbe(&animation,
+		"-select_streams", "v:0", // Only the image stream
+		"-count_packets",                                          // Read all packets of the stream
+		"-show_entries", "stream=nb_read_packets:format=duration", // Frame count and duration of the image
+		imagePath,
+	); err != nil {
+		return nil, err
+	}
+
+	image := &ImageProbe{Width: frame.Width, Height: frame.Height, Tags: frame.Tags, Frames: 1}
+	if len(animation.Streams) > 0 {
+		if frames, err := strconv.Atoi(animation.Streams[0].Packets); err == nil && frames > 1 {
+			image.Frames = frames
+		}
+	}
+	if duration, err := strconv.ParseFloat(animation.Format.Duration, 64); err == nil && image.Frames > 1 {
+		image.Duration = duration
+	}
+
+	return image, nil
+}
+
+// ProbeDuration reads the duration in seconds of the media file at path with ffprobe.
+// It returns 0 if the container does not store a duration.
+func ProbeDuration(path string) (float64, error) {
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+
+	if err := runProbe(&probe, "-show_entries", "format=duration", path); err != nil {
+		return 0, err
+	}
+
+	duration, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return 0, nil
+	}
+	return duration, nil
+}
+
+// ProbeStartTime reads the start time in seconds of the media file at path with ffprobe,
+// e.g. the presentation time of the first frame of an MPEG-TS segment.
+func ProbeStartTime(path string) (float64, error) {
+	var probe struct {
+		Format struct {
+			StartTime string `json:"start_time"`
+		} `json:"format"`
+	}
+
+	if err := runProbe(&probe, "-show_entries", "format=start_time", path); err != nil {
+		return 0, err
+	}
+
+	startTime, err := strconv.ParseFloat(probe.Format.StartTime, 64)
+	if err != nil {
+		return 0, fmt.Errorf("no start time found: %s", path)
+	}
+	return startTime, nil
+}
+
+// ProbeVideoSize reads the width and height in pixels of the first video stream of the media file at path with ffprobe.
+func ProbeVideoSize(path string) (int, int, error) {
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+	}
+
+	if err := runProbe(&probe, "-select_streams", "v:0", "-show_entries", "stream=width,height", path); err != nil {
+		return 0, 0, err
+	}
+
+	if len(probe.Streams) == 0 || probe.Streams[0].Width == 0 {
+		return 0, 0, fmt.Errorf("no video stream found: %s", path)
+	}
+	return probe.Streams[0].Width, probe.Streams[0].Height, nil
+}
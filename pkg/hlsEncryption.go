@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// HLSKeyName is the file name under which the AES-128 key of a video is requested by players.
+// The key itself is never stored under this name, media-docker-client serves it with ReadHLSKey.
+const HLSKeyName = "hls.key"
+
+// KeysDir is the hidden directory of the storage the AES-128 keys are stored in, hidden keys are never served by the client.
+const KeysDir = ".keys"
+
+// HLSKeyPath returns the path of the AES-128 key for the media id inside keyDir.
+func HLSKeyPath(keyDir, id string) string {
+	return filepath.Join(keyDir, id+".key")
+}
+
+// HLSKeyObject returns the key of the AES-128 key of the video id in a storage which is not a LocalStorage,
+// ".keys/<id>.key". A LocalStorage shares its disk with the client, so its keys are only kept in the key directory.
+func HLSKeyObject(id string) string {
+	return KeysDir + "/" + id + ".key"
+}
+
+// CreateHLSKey generates a random 16 byte AES-128 key for the video id and writes it into keyDir, where ffmpeg reads it.
+// The key is also stored in storages which are not a LocalStorage, so the client and other consumers can read it.
+// An existing key is kept, so the served version of a reprocessed video stays playable while the new version
+// is encrypted with the same key, the stored key is fetched into keyDir.
+func CreateHLSKey(storage Storage, keyDir, id string) error {
+	keyPath := HLSKeyPath(keyDir, id)
+	_, local := localRoot(storage)
+	if local {
+		if _, err := os.Stat(keyPath); err == nil {
+			return nil
+		}
+	} else {
+		key, err := ReadHLSKey(storage, keyDir, id)
+		if err == nil {
+			return writeHLSKey(keyPath, key)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("error generating hls key: %w", err)
+	}
+	if err := writeHLSKey(keyPath, key); err != nil {
+		return err
+	}
+	if !local {
+		if err := storage.Put(HLSKeyObject(id), bytes.NewReader(key), int64(len(key)), "application/octet-stream"); err != nil {
+			return fmt.Errorf("error storing hls key: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeHLSKey writes the key to keyPath.
+func writeHLSKey(keyPath string, key []byte) error {
+	// Only the owner needs to read the key, media-docker-client reads it through the same user.
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return fmt.Errorf("error writing hls key: %w", err)
+	}
+	return nil
+}
+
+// ReadHLSKey reads the AES-128 key of the video id, from keyDir for a LocalStorage and from the storage otherwise.
+// A missing key is an error wrapping os.ErrNotExist.
+func ReadHLSKey(storage Storage, keyDir, id string) ([]byte, error) {
+	if _, local := localRoot(storage); local {
+		return os.ReadFile(HLSKeyPath(keyDir, id))
+	}
+
+	body, err := storage.Get(HLSKeyObject(id), 0)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// DeleteHLSKey removes the AES-128 key of the video id from keyDir, and from storages which are not a LocalStorage.
+// A missing key is not an error, videos are only encrypted on request.
+func DeleteHLSKey(storage Storage, keyDir, id string) error {
+	keyPath := HLSKeyPath(keyDir, id)
+	if err := os.Remove(keyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, local := localRoot(storage); local {
+		return nil
+	}
+	return storage.Delete(HLSKeyObject(id))
+}
+
+// WriteHLSKeyInfo writes an ffmpeg key info file to keyInfoPath.
+//
+// The file contains the key URI written into #EXT-X-KEY of the playlist, followed by the
+// path of the key used by ffmpeg for encrypting the segments. No IV is written, so ffmpeg
+// uses the segment sequence number as IV, as defined by the HLS specification.
+func WriteHLSKeyInfo(keyInfoPath, keyURI, keyPath string) error {
+	content := fmt.Sprintf("%s\n%s\n", keyURI, keyPath)
+	if err := os.WriteFile(keyInfoPath, []byte(content), 0600); err != nil {
+		return fmt.Errorf("error writing hls key info: %w", err)
+	}
+	return nil
+}
+
+// HLSKeyInfoPath returns the path of the ffmpeg key info file for the media id inside keyDir.
+// The rendition (e.g. "360") keeps the key info files of different renditions of the same video apart,
+// an empty rendition is used for single rendition videos.
+func HLSKeyInfoPath(keyDir, id, rendition string) string {
+	if rendition == "" {
+		return filepath.Join(keyDir, id+".keyinfo")
+	}
+	return filepath.Join(keyDir, id+"-"+rendition+".keyinfo")
+}
+
+// PrepareHLSEncryption creates the AES-128 key of the video id in keyDir, see CreateHLSKey, and writes the key info file
+// of a single rendition video, whose playlist references the key by HLSKeyName. It returns the path of the key info file.
+func PrepareHLSEncryption(storage Storage, keyDir, id string) (string, error) {
+	if err := CreateHLSKey(storage, keyDir, id); err != nil {
+		return "", err
+	}
+	return WriteRenditionKeyInfo(keyDir, id, "", HLSKeyName)
+}
+
+// WriteRenditionKeyInfo writes the key info file of a rendition of the video id into keyDir, see HLSKeyInfoPath,
+// referencing the existing key of the video with the keyURI relative to the rendition playlist.
+// It returns the path of the key info file.
+func WriteRenditionKeyInfo(keyDir, id, rendition, keyURI string) (string, error) {
+	keyInfoPath := HLSKeyInfoPath(keyDir, id, rendition)
+	if err := WriteHLSKeyInfo(keyInfoPath, keyURI, HLSKeyPath(keyDir, id)); err != nil {
+		return "", err
+	}
+	return keyInfoPath, nil
+}
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// HLSOptions holds the settings passed to the ffmpeg HLS muxer when videos are segmented.
+type HLSOptions struct {
+	SegmentDuration     int    // Target duration of each segment in seconds
+	SegmentNameWidth    int    // Zero-padded width of the segment number, 0 means no padding
+	PlaylistType        string // Playlist type, either "vod" or "event"
+	ForceKeyframes      bool   // Force keyframes at segment boundaries so that every segment starts with a GOP
+	IndependentSegments bool   // Add #EXT-X-INDEPENDENT-SEGMENTS to the playlist
+	KeyInfoFile         string // Optional ffmpeg key info file, enables AES-128 encryption of the segments
+}
+
+// DefaultHLSOptions are the HLS settings used when nothing is configured globally or per job.
+//
+// INFO: SegmentNameWidth is 0 by default, which writes segment names as "segment0.ts", "segment1.ts", ...
+// without any padding. A fixed width like "%03d" stops sorting correctly once a video
+// has more segments than the width allows (1000 segments of 10 seconds is ~2.7 hours).
+var DefaultHLSOptions = HLSOptions{
+	SegmentDuration:     10,
+	SegmentNameWidth:    0,
+	PlaylistType:        "vod",
+	ForceKeyframes:      true,
+	IndependentSegments: true,
+}
+
+// Merge returns a copy of the options with every non-nil field of the job overrides applied.
+// If job is nil, the options are returned unchanged.
+func (o HLSOptions) Merge(job *topics.HLSOptions) HLSOptions {
+	if job == nil {
+		return o
+	}
+	if job.SegmentDuration != nil {
+		o.SegmentDuration = *job.SegmentDuration
+	}
+	if job.SegmentNameWidth != nil {
+		o.SegmentNameWidth = *job.SegmentNameWidth
+	}
+	if job.PlaylistType != nil {
+		o.PlaylistType = *job.PlaylistType
+	}
+	if job.ForceKeyframes != nil {
+		o.ForceKeyframes = *job.ForceKeyframes
+	}
+	if job.IndependentSegments != nil {
+		o.IndependentSegments = *job.IndependentSegments
+	}
+	return o
+}
+
+// segmentPattern returns the segment file name pattern for ffmpeg, e.g. "segment%d.ts" or "segment%05d.ts".
+func (o HLSOptions) segmentPattern() string {
+	if o.SegmentNameWidth <= 0 {
+		return "segment%d.ts"
+	}
+	return fmt.Sprintf("segment%%0%dd.ts", o.SegmentNameWidth)
+}
+
+// encoderArgs returns the ffmpeg encoder arguments required by the options.
+// These must be placed before the output options, as they apply to the video encoder.
+func (o HLSOptions) encoderArgs() []string {
+	if !o.ForceKeyframes {
+		return nil
+	}
+	// Force a keyframe at every segment boundary, so segments are cut exactly at SegmentDuration
+	// and renditions of the same video share the same segment boundaries.
+	return []string{"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", o.SegmentDuration)}
+}
+
+// muxerArgs returns the ffmpeg HLS muxer arguments for the given output directory,
+// including the playlist file name as the last argument.
+func (o HLSOptions) muxerArgs(outputPath string) []string {
+	args := []string{
+		"-f", "hls", // Use the HLS muxer
+		"-hls_time", strconv.Itoa(o.SegmentDuration), // Split video into segments of SegmentDuration seconds each
+		"-hls_playlist_type", o.PlaylistType, // Define the playlist type (vod or event)
+		"-hls_segment_filename", fmt.Sprintf("%s/%s", outputPath, o.segmentPattern()), // Define segment file name pattern
+		"-start_number", "0", // Start segment numbering from 0
+	}
+
+	if o.IndependentSegments {
+		args = append(args, "-hls_flags", "independent_segments") // Mark every segment as independently decodable
+	}
+
+	if o.KeyInfoFile != "" {
+		args = append(args, "-hls_key_info_file", o.KeyInfoFile) // Encrypt every segment with AES-128
+	}
+
+	return append(args, fmt.Sprintf("%s/index.m3u8", outputPath)) // Output the HLS playlist file as index.m3u8
+}
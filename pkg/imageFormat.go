@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// imageEncoders holds the ffmpeg encoder and muxer arguments for every supported image format.
+// The muxer is always set explicitly, so images can be written to files without an image extension.
+//
+// INFO: AVIF is written without an alpha channel, transparent sources should use png or webp.
+var imageEncoders = map[string][]string{
+	"jpeg": {"-c:v", "mjpeg", "-f", "mjpeg"},
+	"png":  {"-c:v", "png", "-f", "image2", "-update", "1"},
+	"webp": {"-c:v", "libwebp", "-f", "webp"},
+	"avif": {"-c:v", "libaom-av1", "-cpu-used", "6", "-still-picture", "1", "-f", "avif"},
+	"gif":  {"-c:v", "gif", "-f", "gif"},
+}
+
+// animatedImageEncoders holds the ffmpeg encoder and muxer arguments for the formats able to store
+// an animated image. Animations loop forever, like the GIF and WebP uploads they are converted from.
+var animatedImageEncoders = map[string][]string{
+	"webp": {"-c:v", "libwebp_anim", "-loop", "0", "-f", "webp"},
+	"gif":  {"-c:v", "gif", "-loop", "0", "-f", "gif"},
+}
+
+// imageExtensions maps file extensions to their image format.
+var imageExtensions = map[string]string{
+	".jpeg": "jpeg",
+	".jpg":  "jpeg",
+	".png":  "png",
+	".webp": "webp",
+	".avif": "avif",
+	".gif":  "gif",
+}
+
+// IsImageFormat reports whether the image format is supported, one of "jpeg", "png", "webp", "avif" or "gif".
+func IsImageFormat(format string) bool {
+	_, ok := imageEncoders[format]
+	return ok
+}
+
+// ImageFormatFromExtension returns the image format of a file extension like ".png" or ".jpg".
+// The boolean is false if the extension is not a supported image format.
+func ImageFormatFromExtension(ext string) (string, bool) {
+	format, ok := imageExtensions[strings.ToLower(ext)]
+	return format, ok
+}
+
+// ImageExtension returns the file extension of stored images of the format, e.g. ".png".
+func ImageExtension(format string) string {
+	return "." + format
+}
+
+// IsAnimatedImageFormat reports whether the image format can store an animation, "webp" or "gif".
+func IsAnimatedImageFormat(format string) bool {
+	_, ok := animatedImageEncoders[format]
+	return ok
+}
+
+// gifPaletteFilter returns the filter graph converting the stream to a GIF with a palette generated
+// from the stream itself, instead of the fixed 256 colour palette used by the gif encoder.
+// The label prefixes the intermediate streams, so the graph can be used more than once in a filter_complex.
+func gifPaletteFilter(label string) string {
+	return fmt.Sprintf(
+		"split[%[1]sa][%[1]sb];[%[1]sa]palettegen=stats_mode=diff[%[1]sp];[%[1]sb][%[1]sp]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle",
+		label)
+}
+
+// imageQualityArgs returns the encoder arguments for a quality between 1 (lowest) and 100 (highest).
+// PNG and GIF are lossless and ignore the quality.
+func imageQualityArgs(format string, quality int) []string {
+	switch format {
+	case "jpeg":
+		// mjpeg uses a quantizer scale from 2 (best) to 31 (worst)
+		return []string{"-q:v", strconv.Itoa(2 + (100-quality)*29/100)}
+	case "webp":
+		return []string{"-quality", strconv.Itoa(quality)}
+	case "avif":
+		// libaom uses a constant rate factor from 0 (best) to 63 (worst)
+		return []string{"-crf", strconv.Itoa((100 - quality) * 63 / 100)}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// ImageOptions holds the settings used by ConvertImage to resize and encode an image.
+type ImageOptions struct {
+	Width   int    // Target (or maximum) width in pixels, 0 keeps the aspect ratio of the height
+	Height  int    // Target (or maximum) height in pixels, 0 keeps the aspect ratio of the width
+	Fit     string // How the image fits into Width x Height: "contain" (default), "cover" or "fill"
+	Format  string // Output format: "jpeg", "png", "webp", "avif" or "gif"
+	Quality int    // Encoder quality between 1 (lowest) and 100 (highest), 0 uses DefaultImageQuality
+
+	Animated bool // The source is animated, kept for webp and gif outputs, other formats use the first frame
+
+	Orientation int // EXIF orientation of the source, applied before resizing, 0 or 1 is upright
+
+	Watermark *WatermarkProfile // Optional watermark burned into the image after resizing, nil writes no watermark
+}
+
+// DefaultImageQuality is the encoder quality used when no quality is provided.
+const DefaultImageQuality = 90
+
+// ImageFallbackFileName is the name of the GIF fallback of an animated WebP image in its media directory.
+const ImageFallbackFileName = "fallback.gif"
+
+// ImageVariant is a responsive variant of an image, written to "images/<id>/<width>.<ext>".
+type ImageVariant struct {
+	Width  int    `json:"width"`  // Maximum width of the variant in pixels
+	Format string `json:"format"` // Format of the variant, e.g. "webp"
+}
+
+// FileName returns the file name of the variant inside the media directory, e.g. "640.webp".
+func (v ImageVariant) FileName() string {
+	return fmt.Sprintf("%d%s", v.Width, ImageExtension(v.Format))
+}
+
+// ImageVariantsFromMessage returns every combination of the widths and formats of the job variants.
+// It returns nil if the job has no variants.
+func ImageVariantsFromMessage(variants *topics.ImageVariants) []ImageVariant {
+	if variants == nil {
+		return nil
+	}
+
+	list := make([]ImageVariant, 0, len(variants.Widths)*len(variants.Formats))
+	for _, format := range variants.Formats {
+		for _, width := range variants.Widths {
+			list = append(list, ImageVariant{Width: width, Format: format})
+		}
+	}
+	return list
+}
+
+// ProducedImageVariants returns the variants ConvertImageVariants writes for a source of the displayed width.
+// Variants are never upscaled, so every width at or above the width of the source is written with the width of the source,
+// and its variants are collapsed into one variant per format. The returned variants record the widths of the written files,
+// they are used for the srcset of the image. The variants are returned unchanged if the width of the source is unknown.
+func ProducedImageVariants(variants []ImageVariant, sourceWidth int) []ImageVariant {
+	if sourceWidth <= 0 {
+		return variants
+	}
+
+	produced := make([]ImageVariant, 0, len(variants))
+	for _, variant := range variants {
+		variant.Width = min(variant.Width, sourceWidth)
+		if !slices.Contains(produced, variant) {
+			produced = append(produced, variant)
+		}
+	}
+	return produced
+}
+
+// LinkRequestedImageVariants links every requested variant which ProducedImageVariants collapsed into the variant
+// of the source width to the written variant of its format, e.g. "1920.webp" to "800.webp" of an image 800 pixels wide,
+// so the variant URLs returned with the upload request all resolve. The links are not recorded in the metadata,
+// whose variants list the written widths. Nothing is linked if the width of the source is unknown.
+func LinkRequestedImageVariants(mediaDir string, requested []ImageVariant, sourceWidth int) error {
+	if sourceWidth <= 0 {
+		return nil
+	}
+
+	for _, variant := range requested {
+		if variant.Width <= sourceWidth {
+			continue
+		}
+		written := ImageVariant{Width: sourceWidth, Format: variant.Format}
+		if err := linkOrCopyFile(filepath.Join(mediaDir, written.FileName()), filepath.Join(mediaDir, variant.FileName())); err != nil {
+			return fmt.Errorf("error linking image variant %s: %w", variant.FileName(), err)
+		}
+	}
+	return nil
+}
+
+// ImageOptionsFromMessage returns the image options requested by an image job for the probed source,
+// with the watermark profile of the job, nil if the job has none.
+// Messages produced before the format was added are converted to jpeg.
+func ImageOptionsFromMessage(msg topics.ImageMessage, source *ImageSourceMetadata, watermark *WatermarkProfile) ImageOptions {
+	image := ImageOptions{Format: msg.Format, Fit: "contain", Orientation: source.Orientation, Animated: source.Frames > 1, Watermark: watermark}
+	if image.Format == "" {
+		image.Format = "jpeg"
+	}
+	if msg.Quality != nil {
+		image.Quality = *msg.Quality
+	}
+	if msg.MaxWidth != nil {
+		image.Width = *msg.MaxWidth
+	}
+	if msg.MaxHeight != nil {
+		image.Height = *msg.MaxHeight
+	}
+	if msg.Fit != nil {
+		image.Fit = *msg.Fit
+	}
+
+	return image
+}
+
+// filters returns the ffmpeg filters of the image: orientation, resizing, watermark and pixel format.
+// The scale parameter overrides the resizing of the options, e.g. for responsive variants.
+// The label prefixes the intermediate streams of the watermark and the GIF palette, it must be unique within a filter graph.
+func (t ImageOptions) filters(scale, label string) []string {
+	filters := []string{}
+	if orientation, ok := orientationFilters[t.Orientation]; ok {
+		filters = append(filters, orientation) // Display the image upright before it is resized
+	}
+	if scale != "" {
+		filters = append(filters, scale) // Resize the image
+	}
+	if t.Watermark != nil {
+		// Burn the watermark after resizing, so its size and margins are relative to the output
+		filters = append(filters, t.Watermark.filter(label+"w"))
+	}
+	if t.Format == "jpeg" {
+		// JPEG has no alpha channel, convert transparent sources (e.g. png) to a full range yuv format
+		filters = append(filters, "format=yuvj420p")
+	}
+	if t.Format == "gif" {
+		// Generate the palette from the image, the fixed palette of the gif encoder bands photos and gradients
+		filters = append(filters, gifPaletteFilter(label))
+	}
+	return filters
+}
+
+// encoderArgs returns the ffmpeg arguments of the encoder and muxer writing the image in the format.
+// Animated sources stay animated in webp and gif, all other outputs are limited to the first frame.
+func (t ImageOptions) encoderArgs(format string) ([]string, error) {
+	if t.Animated {
+		if encoder, ok := animatedImageEncoders[format]; ok {
+			return encoder, nil
+		}
+	}
+
+	encoder, ok := imageEncoders[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image format: %s", format)
+	}
+	return append([]string{"-frames:v", "1"}, encoder...), nil
+}
+
+// metadataArgs returns the ffmpeg arguments stripping all metadata of the source.
+// The image muxers of ffmpeg do not write EXIF, so the output carries no metadata at all,
+// kept copyright fields are only recorded in the metadata of the image, see ImageSourceMetadata.
+func (t ImageOptions) metadataArgs() []string {
+	return []string{"-map_metadata", "-1"} // Strip EXIF, XMP and IPTC metadata of the source
+}
+
+// scaleFilter returns the ffmpeg filter graph resizing the image according to the transform.
+// Images are never upscaled, except for "cover" and "fill" which must produce the exact size.
+func (t ImageOptions) scaleFilter() string {
+	switch {
+	case t.Width > 0 && t.Height > 0 && t.Fit == "cover":
+		// Fill the box and crop the overflowing part around the center
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d", t.Width, t.Height, t.Width, t.Height)
+	case t.Width > 0 && t.Height > 0 && t.Fit == "fill":
+		// Stretch the image to the box, ignoring the aspect ratio
+		return fmt.Sprintf("scale=%d:%d", t.Width, t.Height)
+	case t.Width > 0 && t.Height > 0:
+		// Fit the image inside the box, keeping the aspect ratio
+		return fmt.Sprintf("scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease", t.Width, t.Height)
+	case t.Width > 0:
+		return fmt.Sprintf("scale='min(%d,iw)':-2", t.Width)
+	case t.Height > 0:
+		return fmt.Sprintf("scale=-2:'min(%d,ih)'", t.Height)
+	default:
+		return ""
+	}
+}
+
+// AnimationLimits holds the limits of animated image uploads, larger animations fail the image job.
+type AnimationLimits struct {
+	MaxFrames   int // Maximum number of frames
+	MaxDuration int // Maximum duration in seconds
+}
+
+// DefaultAnimationLimits are the animation limits used when nothing is configured.
+var DefaultAnimationLimits = AnimationLimits{
+	MaxFrames:   500,
+	MaxDuration: 60,
+}
+
+// Check returns an error if the animated source exceeds the limits. Still images always pass.
+func (l AnimationLimits) Check(source *ImageSourceMetadata) error {
+	if source.Frames > l.MaxFrames {
+		return fmt.Errorf("animation has %d frames, the maximum is %d", source.Frames, l.MaxFrames)
+	}
+	if source.Duration > float64(l.MaxDuration) {
+		return fmt.Errorf("animation is %.2f seconds long, the maximum is %d", source.Duration, l.MaxDuration)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProducedImageVariants(t *testing.T) {
+	variants := []ImageVariant{
+		{Width: 320, Format: "webp"}, {Width: 640, Format: "webp"}, {Width: 1280, Format: "webp"}, {Width: 1920, Format: "webp"},
+		{Width: 320, Format: "avif"}, {Width: 640, Format: "avif"}, {Width: 1280, Format: "avif"}, {Width: 1920, Format: "avif"},
+	}
+
+	produced := ProducedImageVariants(variants, 800)
+	want := []ImageVariant{
+		{Width: 320, Format: "webp"}, {Width: 640, Format: "webp"}, {Width: 800, Format: "webp"},
+		{Width: 320, Format: "avif"}, {Width: 640, Format: "avif"}, {Width: 800, Format: "avif"},
+	}
+	if !slices.Equal(produced, want) {
+		t.Errorf("ProducedImageVariants(800) = %v, want %v", produced, want)
+	}
+
+	if produced := ProducedImageVariants(variants, 640); len(produced) != 4 || produced[1].Width != 640 {
+		t.Errorf("ProducedImageVariants(640) = %v, want 320 and 640 per format", produced)
+	}
+	if produced := ProducedImageVariants(variants, 0); !slices.Equal(produced, variants) {
+		t.Errorf("ProducedImageVariants(0) = %v, want the variants unchanged", produced)
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	source := ImageSourceMetadata{Width: 4000, Height: 3000, Orientation: 6}
+	if width := source.DisplayWidth(); width != 3000 {
+		t.Errorf("DisplayWidth() of a rotated image = %d, want 3000", width)
+	}
+	source.Orientation = 3
+	if width := source.DisplayWidth(); width != 4000 {
+		t.Errorf("DisplayWidth() of an upside down image = %d, want 4000", width)
+	}
+}
+
+func TestLinkRequestedImageVariants(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "800.webp"), []byte("800"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	requested := []ImageVariant{{Width: 640, Format: "webp"}, {Width: 1280, Format: "webp"}, {Width: 1920, Format: "webp"}}
+	if err := LinkRequestedImageVariants(dir, requested, 800); err != nil {
+		t.Fatalf("LinkRequestedImageVariants() error = %v", err)
+	}
+	for _, name := range []string{"1280.webp", "1920.webp"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != "800" {
+			t.Errorf("%s = %q, %v, want the variant of the source width", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "640.webp")); !os.IsNotExist(err) {
+		t.Errorf("640.webp was linked, want only the widths above the source")
+	}
+}
@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// DefaultLoudnessTarget is the loudness target used when a job opts in without overriding its fields.
+// -16 LUFS is the common target of podcast and streaming platforms, EBU R128 broadcast uses -23 LUFS.
+var DefaultLoudnessTarget = LoudnessTarget{
+	IntegratedLUFS: -16,
+	TruePeak:       -1.5,
+	LRA:            11,
+}
+
+// LoudnessTarget holds the EBU R128 loudness an audio track is normalized to.
+type LoudnessTarget struct {
+	IntegratedLUFS float64 `json:"integratedLufs"` // Integrated loudness in LUFS
+	TruePeak       float64 `json:"truePeak"`       // Maximum true peak in dBTP
+	LRA            float64 `json:"lra"`            // Loudness range in LU
+}
+
+// LoudnessMeasurement holds the loudness of an audio track measured by the first loudnorm pass.
+type LoudnessMeasurement struct {
+	IntegratedLUFS float64 `json:"integratedLufs"` // Integrated loudness in LUFS
+	TruePeak       float64 `json:"truePeak"`       // True peak in dBTP
+	LRA            float64 `json:"lra"`            // Loudness range in LU
+	Threshold      float64 `json:"threshold"`      // Gating threshold in LUFS
+	TargetOffset   float64 `json:"targetOffset"`   // Offset gain applied by the second pass in LU
+}
+
+// Loudness holds the target and the measured loudness of an audio track, used by the second loudnorm pass
+// which corrects the loudness while the track is encoded. It is recorded in the metadata of the media file.
+type Loudness struct {
+	Target   LoudnessTarget      `json:"target"`   // Loudness the track is normalized to
+	Measured LoudnessMeasurement `json:"measured"` // Loudness of the source before the normalization
+}
+
+// LoudnessTargetFromMessage returns the loudness target of a job with every non-nil field applied
+// to DefaultLoudnessTarget.
+func LoudnessTargetFromMessage(options *topics.LoudnessOptions) LoudnessTarget {
+	target := DefaultLoudnessTarget
+	if options == nil {
+		return target
+	}
+	if options.TargetLUFS != nil {
+		target.IntegratedLUFS = *options.TargetLUFS
+	}
+	if options.TruePeak != nil {
+		target.TruePeak = *options.TruePeak
+	}
+	if options.LRA != nil {
+		target.LRA = *options.LRA
+	}
+	return target
+}
+
+// MeasureLoudness runs the first loudnorm pass over the first audio stream of the media file at path,
+// measuring its loudness for the normalization to the target of the job.
+//
+// It returns nil without an error if the job does not normalize the loudness, if the media file has no audio stream,
+// or if the audio is silent, as silence can not be normalized.
+func MeasureLoudness(path string, options *topics.LoudnessOptions) (*Loudness, error) {
+	if options == nil {
+		return nil, nil
+	}
+
+	hasAudio, err := hasAudioStream(path)
+	if err != nil {
+		return nil, err
+	}
+	if !hasAudio {
+		return nil, nil
+	}
+
+	return measureStreamLoudness(path, 0, LoudnessTargetFromMessage(options))
+}
+
+// measureStreamLoudness runs the first loudnorm pass over an audio stream of the media file at path,
+// stream being the position of the stream among the audio streams (0 is the first audio stream).
+// It returns nil without an error if the audio is silent.
+func measureStreamLoudness(path string, stream int, target LoudnessTarget) (*Loudness, error) {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-i", path, // Input media file
+		"-map", fmt.Sprintf("0:a:%d", stream), // Measure a single audio stream
+		"-af", fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g:print_format=json", target.IntegratedLUFS, target.TruePeak, target.LRA),
+		"-f", "null", "-", // Discard the output, only the measurement is needed
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("command: %s, %s", cmd.String(), err)
+	}
+
+	// The measurement is printed as the last json object of the log
+	output := stderr.Bytes()
+	start, end := bytes.LastIndexByte(output, '{'), bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudness measurement found: %s", path)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(output[start:end+1], &values); err != nil {
+		return nil, fmt.Errorf("error decoding loudness measurement: %w", err)
+	}
+
+	measured := LoudnessMeasurement{}
+	fields := map[string]*float64{
+		"input_i":       &measured.IntegratedLUFS,
+		"input_tp":      &measured.TruePeak,
+		"input_lra":     &measured.LRA,
+		"input_thresh":  &measured.Threshold,
+		"target_offset": &measured.TargetOffset,
+	}
+	for key, field := range fields {
+		value, err := strconv.ParseFloat(values[key], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid loudness measurement %s: %q", key, values[key])
+		}
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, nil // Silent audio has an infinite loudness
+		}
+		*field = value
+	}
+
+	return &Loudness{Target: target, Measured: measured}, nil
+}
+
+// filter returns the second loudnorm pass, correcting the loudness with the measured values.
+// The linear mode applies a single gain if the target can be reached without exceeding the true peak,
+// otherwise loudnorm falls back to the dynamic mode.
+func (l *Loudness) filter() string {
+	return fmt.Sprintf(
+		"loudnorm=I=%g:TP=%g:LRA=%g:measured_I=%g:measured_TP=%g:measured_LRA=%g:measured_thresh=%g:offset=%g:linear=true",
+		l.Target.IntegratedLUFS, l.Target.TruePeak, l.Target.LRA,
+		l.Measured.IntegratedLUFS, l.Measured.TruePeak, l.Measured.LRA, l.Measured.Threshold, l.Measured.TargetOffset,
+	)
+}
+
+// audioFilterArgs returns the ffmpeg arguments normalizing the audio of an output, or nil if l is nil.
+// loudnorm outputs 192 kHz audio, so the output must set its sample rate with "-ar".
+func (l *Loudness) audioFilterArgs() []string {
+	if l == nil {
+		return nil
+	}
+	return []string{"-af", l.filter()}
+}
+
+// hasAudioStream reports whether the media file at path has an audio stream.
+func hasAudioStream(path string) (bool, error) {
+	var probe struct {
+		Streams []struct {
+			Index int `json:"index"`
+		} `json:"streams"`
+	}
+	if err := runProbe(&probe, "-select_streams", "a", "-show_entries", "stream=index", path); err != nil {
+		return false, err
+	}
+	return len(probe.Streams) > 0, nil
+}
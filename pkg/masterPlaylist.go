@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// VideoMasterPlaylist is the name of the HLS master playlist in the media directory of a video,
+// listing the renditions and caption tracks of the video.
+const VideoMasterPlaylist = "master.m3u8"
+
+// captionsGroupID is the GROUP-ID of the caption tracks in the master playlist of a video.
+const captionsGroupID = "subs"
+
+// audioGroupID is the GROUP-ID of the alternate audio renditions in the master playlist of a video.
+const audioGroupID = "audio"
+
+// videoRendition is an HLS rendition of a video, listed in the master playlist.
+type videoRendition struct {
+	playlist string // Path of the rendition playlist inside the media directory, e.g. "720/index.m3u8"
+	width    int    // Width of the rendition in pixels, 0 if unknown
+	height   int    // Height of the rendition in pixels, 0 if unknown
+}
+
+// videoRenditions returns the HLS renditions of the video in videoDir, from the lowest to the highest resolution:
+// "index.m3u8" for videos of the "video" topic, or one rendition per resolution for the "video-resolutions" topic.
+func videoRenditions(videoDir string) []videoRendition {
+	if _, err := os.Stat(filepath.Join(videoDir, "index.m3u8")); err == nil {
+		rendition := videoRendition{playlist: "index.m3u8"}
+
+		// The resolution of the source is kept, it is read from the first segment if the segment is not encrypted
+		if segments, err := readMediaPlaylist(filepath.Join(videoDir, "index.m3u8")); err == nil && len(segments) > 0 {
+			rendition.width, rendition.height, _ = ProbeVideoSize(filepath.Join(videoDir, segments[0].uri))
+		}
+		return []videoRendition{rendition}
+	}
+
+	var renditions []videoRendition
+	for _, resolution := range []string{"360", "480", "720", "1080"} {
+		playlist := resolution + "/index.m3u8"
+		if _, err := os.Stat(filepath.Join(videoDir, playlist)); err != nil {
+			continue
+		}
+		width, _ := strconv.Atoi(heights[resolution])
+		height, _ := strconv.Atoi(resolution)
+		renditions = append(renditions, videoRendition{playlist: playlist, width: width, height: height})
+	}
+	return renditions
+}
+
+// playlistSegment is a segment of an HLS media playlist.
+type playlistSegment struct {
+	duration      float64 // Duration of the segment in seconds, from #EXTINF
+	uri           string  // URI of the segment relative to the playlist
+	discontinuity bool    // The timestamps or encoding parameters change with the segment, written as #EXT-X-DISCONTINUITY by writeEditPlaylist
+}
+
+// readMediaPlaylist reads the segments of the HLS media playlist at path.
+func readMediaPlaylist(path string) ([]playlistSegment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist: %w", err)
+	}
+	defer file.Close()
+
+	var segments []playlistSegment
+	duration := -1.0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			if duration, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid segment duration in playlist %s: %q", path, line)
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case duration >= 0:
+			segments = append(segments, playlistSegment{duration: duration, uri: line})
+			duration = -1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading playlist: %w", err)
+	}
+
+	return segments, nil
+}
+
+// renditionBandwidth returns the peak and average bandwidth in bits per second of the rendition playlist,
+// a path inside videoDir, computed from the sizes and durations of its segments.
+func renditionBandwidth(videoDir, playlist string) (int, int, error) {
+	segments, err := readMediaPlaylist(filepath.Join(videoDir, playlist))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var peak, totalBits, totalDuration float64
+	for _, segment := range segments {
+		info, err := os.Stat(filepath.Join(videoDir, filepath.Dir(playlist), segment.uri))
+		if err != nil {
+			return 0, 0, fmt.Errorf("error reading segment: %w", err)
+		}
+		bits := float64(info.Size() * 8)
+		if segment.duration > 0 && bits/segment.duration > peak {
+			peak = bits / segment.duration
+		}
+		totalBits += bits
+		totalDuration += segment.duration
+	}
+	if totalDuration <= 0 {
+		return 0, 0, fmt.Errorf("empty playlist: %s", playlist)
+	}
+
+	return int(peak), int(totalBits / totalDuration), nil
+}
+
+// quoteAttribute returns value as a quoted-string attribute of a playlist tag,
+// which can not contain double quotes or line breaks.
+func quoteAttribute(value string) string {
+	return `"` + strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ").Replace(value) + `"`
+}
+
+// WriteVideoMasterPlaylist writes the master playlist VideoMasterPlaylist of the video in videoDir,
+// listing every rendition of the video with its bandwidth and resolution, and the audio and caption tracks of the metadata.
+// The bandwidth of the renditions includes the bandwidth of the largest audio track.
+// The playlist is replaced atomically, so players never read a partially written playlist.
+func WriteVideoMasterPlaylist(videoDir string, metadata *MediaMetadata) error {
+	renditions := videoRenditions(videoDir)
+	if len(renditions) == 0 {
+		return fmt.Errorf("no HLS renditions found for video: %s", metadata.ID)
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	playlist.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	// The video renditions keep their muxed audio, which is replaced by the selected audio track
+	audioPeak, audioAverage := 0, 0
+	for _, track := range metadata.AudioTracks {
+		peak, average, err := renditionBandwidth(videoDir, track.Playlist())
+		if err != nil {
+			return err
+		}
+		audioPeak, audioAverage = max(audioPeak, peak), max(audioAverage, average)
+
+		isDefault := "NO"
+		if track.Default {
+			isDefault = "YES"
+		}
+		fmt.Fprintf(&playlist, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=%q,NAME=%s,", audioGroupID, quoteAttribute(track.Name))
+		if track.Language != "" {
+			fmt.Fprintf(&playlist, "LANGUAGE=%s,", quoteAttribute(track.Language))
+		}
+		fmt.Fprintf(&playlist, "DEFAULT=%s,AUTOSELECT=YES,CHANNELS=\"2\",URI=%s\n", isDefault, quoteAttribute(track.Playlist()))
+	}
+
+	for _, caption := range metadata.Captions {
+		isDefault := "NO"
+		if caption.Default {
+			isDefault = "YES"
+		}
+		fmt.Fprintf(&playlist,
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=%q,NAME=%s,LANGUAGE=%s,DEFAULT=%s,AUTOSELECT=YES,FORCED=NO,URI=%s\n",
+			captionsGroupID, quoteAttribute(caption.Name), quoteAttribute(caption.Language), isDefault, quoteAttribute(caption.Playlist()),
+		)
+	}
+
+	for _, rendition := range renditions {
+		peak, average, err := renditionBandwidth(videoDir, rendition.playlist)
+		if err != nil {
+			return err
+		}
+		peak, average = peak+audioPeak, average+audioAverage
+
+		fmt.Fprintf(&playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d", peak, average)
+		if rendition.width > 0 && rendition.height > 0 {
+			fmt.Fprintf(&playlist, ",RESOLUTION=%dx%d", rendition.width, rendition.height)
+		}
+		if len(metadata.AudioTracks) > 0 {
+			fmt.Fprintf(&playlist, ",AUDIO=%q", audioGroupID)
+		}
+		if len(metadata.Captions) > 0 {
+			fmt.Fprintf(&playlist, ",SUBTITLES=%q", captionsGroupID)
+		}
+		playlist.WriteString("\n" + rendition.playlist + "\n")
+	}
+
+	if err := writeFileAtomic(filepath.Join(videoDir, VideoMasterPlaylist), []byte(playlist.String())); err != nil {
+		return fmt.Errorf("error writing master playlist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// editBoundaryTolerance is the maximum distance in seconds between a cut and a segment boundary of the source video
+// for the cut to be aligned with the boundary. Segment durations are written with microsecond precision.
+const editBoundaryTolerance = 0.01
+
+// EditPart is a time range of an existing video or audio, cut by a clip or concat job.
+// It is recorded in the metadata of the new media file.
+type EditPart struct {
+	Id      string  `json:"id"`                // Id of the source media file
+	Start   float64 `json:"start"`             // Start of the range in seconds
+	End     float64 `json:"end"`               // End of the range in seconds, 0 until it is resolved to the end of the source
+	Copied  bool    `json:"copied,omitempty"`  // The range was copied from the source without re-encoding
+	Encoded float64 `json:"encoded,omitempty"` // Seconds of a video range which were re-encoded, the partial segments at cuts inside segments
+}
+
+// EditPartsFromMessage returns the parts of a clip or concat job, in the order of the job.
+func EditPartsFromMessage(parts []topics.EditPart) []EditPart {
+	result := make([]EditPart, len(parts))
+	for i, part := range parts {
+		result[i] = EditPart{Id: part.Id, Start: part.Start}
+		if part.End != nil {
+			result[i].End = *part.End
+		}
+	}
+	return result
+}
+
+// CreateVideoEdit cuts the parts from the stored HLS renditions of existing videos and joins them into the new
+// single rendition video id, written with its poster and metadata into its media directory in outputStorage,
+// e.g. the staging storage of the job. The sources are read from mediaStorage.
+//
+// The segments of a part are copied without re-encoding, as every segment of a stored video starts with a keyframe.
+// A cut inside a segment only re-encodes the partial segment with the HLS options, from the cut to the next segment boundary
+// at the start of the part and from the last segment boundary to the cut at its end, so a part whose cuts fall on
+// segment boundaries has no quality loss. The parts, and the re-encoded and copied ranges of a part, are separated by
+// discontinuities in the playlist, so videos of different resolutions can be joined.
+// The highest resolution of videos with several resolutions is used, the edited video has a single rendition,
+// and only the first audio stream is kept. Encrypted videos can not be edited.
+func CreateVideoEdit(mediaStorage, outputStorage, id string, parts []EditPart, hls HLSOptions) error {
+	outputDir := MediaDir(outputStorage, "video", id)
+	if err := CreateDir(outputDir); err != nil {
+		return fmt.Errorf("error creating media directory: %w", err)
+	}
+
+	partSegments := make([][]playlistSegment, len(parts))
+	for i := range parts {
+		segments, err := editVideoPart(mediaStorage, outputDir, i, &parts[i], hls)
+		if err != nil {
+			return err
+		}
+		partSegments[i] = segments
+	}
+
+	playlistPath := filepath.Join(outputDir, "index.m3u8")
+	if err := writeEditPlaylist(playlistPath, partSegments, hls); err != nil {
+		return err
+	}
+
+	placeholder, err := CreateVideoPoster(playlistPath, outputDir)
+	if err != nil {
+		return fmt.Errorf("error creating video poster: %w", err)
+	}
+
+	return WriteMetadata(outputStorage, &MediaMetadata{
+		ID:          id,
+		Type:        "video",
+		Placeholder: placeholder,
+		Poster:      PosterFileName,
+		Parts:       parts,
+		CreatedAt:   time.Now(),
+	})
+}
+
+// editVideoPart writes the segments of a part into outputDir, named "part<index>-<segment>" for copied segments
+// and "part<index>-head-<segment>" and "part<index>-tail-<segment>" for the re-encoded partial segments at the cuts,
+// and returns them in playback order. The range of the part is resolved against the duration of the source,
+// and cuts within the tolerance of a segment boundary are aligned to the boundary.
+func editVideoPart(mediaStorage, outputDir string, index int, part *EditPart, hls HLSOptions) ([]playlistSegment, error) {
+	sourceDir := MediaDir(mediaStorage, "video", part.Id)
+	renditions := videoRenditions(sourceDir)
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("video %s: %w", part.Id, os.ErrNotExist)
+	}
+	sourcePlaylist := filepath.Join(sourceDir, renditions[len(renditions)-1].playlist)
+
+	data, err := os.ReadFile(sourcePlaylist)
+	if err != nil {
+		return nil, fmt.Errorf("error reading playlist: %w", err)
+	}
+	if strings.Contains(string(data), "#EXT-X-KEY") {
+		return nil, fmt.Errorf("encrypted videos can not be edited: %s", part.Id)
+	}
+
+	segments, err := readMediaPlaylist(sourcePlaylist)
+	if err != nil {
+		return nil, err
+	}
+
+	// boundaries[k] is the start of segment k, the last boundary is the end of the video
+	boundaries := make([]float64, len(segments)+1)
+	for k, segment := range segments {
+		boundaries[k+1] = boundaries[k] + segment.duration
+	}
+	duration := boundaries[len(segments)]
+	if part.End == 0 || part.End > duration {
+		part.End = duration
+	}
+	if part.Start >= part.End {
+		return nil, fmt.Errorf("empty range %g-%g of video %s with a duration of %g seconds", part.Start, part.End, part.Id, duration)
+	}
+
+	prefix := fmt.Sprintf("part%d-", index)
+
+	// first and last are the boundaries of the copied segments: the boundaries at aligned cuts,
+	// otherwise the first boundary after the start and the last boundary before the end
+	first, startAligned := boundaryIndex(boundaries, part.Start), true
+	if first < 0 {
+		first, startAligned = boundaryAfter(boundaries, part.Start), false
+	}
+	last, endAligned := boundaryIndex(boundaries, part.End), true
+	if last < 0 {
+		last, endAligned = boundaryBefore(boundaries, part.End), false
+	}
+	if startAligned {
+		part.Start = boundaries[first]
+	}
+	if endAligned {
+		part.End = boundaries[last]
+	}
+
+	// Both cuts are inside the same segment, the range is re-encoded
+	if first > last {
+		encoded, err := encodeVideoRange(sourcePlaylist, outputDir, prefix+"head-", part.Start, part.End, hls)
+		if err != nil {
+			return nil, err
+		}
+		part.Encoded = part.End - part.Start
+		return encoded, nil
+	}
+
+	pieces := [][]playlistSegment{}
+
+	// Re-encode the partial segment from the start to the first boundary
+	if !startAligned {
+		head, err := encodeVideoRange(sourcePlaylist, outputDir, prefix+"head-", part.Start, boundaries[first], hls)
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, head)
+		part.Encoded += boundaries[first] - part.Start
+	}
+
+	// Copy the whole segments between the boundaries
+	if last > first {
+		copied := make([]playlistSegment, 0, last-first)
+		for k := first; k < last; k++ {
+			name := prefix + filepath.Base(segments[k].uri)
+			source := filepath.Join(filepath.Dir(sourcePlaylist), segments[k].uri)
+			if err := linkOrCopyFile(source, filepath.Join(outputDir, name)); err != nil {
+				return nil, fmt.Errorf("error copying segment: %w", err)
+			}
+			copied = append(copied, playlistSegment{duration: segments[k].duration, uri: name})
+		}
+		pieces = append(pieces, copied)
+	}
+
+	// Re-encode the partial segment from the last boundary to the end
+	if !endAligned {
+		tail, err := encodeVideoRange(sourcePlaylist, outputDir, prefix+"tail-", boundaries[last], part.End, hls)
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, tail)
+		part.Encoded += part.End - boundaries[last]
+	}
+
+	// The timestamps of the copied segments continue those of the source, the re-encoded segments start at 0
+	result := []playlistSegment{}
+	for i, piece := range pieces {
+		if i > 0 && len(piece) > 0 {
+			piece[0].discontinuity = true
+		}
+		result = append(result, piece...)
+	}
+	part.Copied = part.Encoded == 0
+	return result, nil
+}
+
+// encodeVideoRange re-encodes the range start-end of the source playlist with the HLS options, and moves the segments
+// into outputDir, named with the prefix. It returns the segments in playback order.
+func encodeVideoRange(sourcePlaylist, outputDir, prefix string, start, end float64, hls HLSOptions) ([]playlistSegment, error) {
+	// Re-encode the range into a temporary directory and move its segments next to the other parts
+	tmpDir := filepath.Join(outputDir, "."+strings.TrimSuffix(prefix, "-"))
+	os.RemoveAll(tmpDir)
+	if err := CreateDir(tmpDir); err != nil {
+		return nil, fmt.Errorf("error creating part directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := ConvertVideoPart(sourcePlaylist, tmpDir, start, end, hls); err != nil {
+		return nil, err
+	}
+	encoded, err := readMediaPlaylist(filepath.Join(tmpDir, "index.m3u8"))
+	if err != nil {
+		return nil, err
+	}
+	for k, segment := range encoded {
+		name := prefix + filepath.Base(segment.uri)
+		if err := os.Rename(filepath.Join(tmpDir, segment.uri), filepath.Join(outputDir, name)); err != nil {
+			return nil, fmt.Errorf("error moving segment: %w", err)
+		}
+		encoded[k].uri = name
+	}
+	return encoded, nil
+}
+
+// boundaryIndex returns the index of the segment boundary at t, or -1 if t is not on a boundary.
+func boundaryIndex(boundaries []float64, t float64) int {
+	for k, boundary := range boundaries {
+		if math.Abs(boundary-t) <= editBoundaryTolerance {
+			return k
+		}
+	}
+	return -1
+}
+
+// boundaryAfter returns the index of the first segment boundary after t, t is within the video.
+func boundaryAfter(boundaries []float64, t float64) int {
+	for k, boundary := range boundaries {
+		if boundary > t {
+			return k
+		}
+	}
+	return len(boundaries) - 1
+}
+
+// boundaryBefore returns the index of the last segment boundary before t, t is within the video.
+func boundaryBefore(boundaries []float64, t float64) int {
+	for k := len(boundaries) - 1; k >= 0; k-- {
+		if boundaries[k] < t {
+			return k
+		}
+	}
+	return 0
+}
+
+// writeEditPlaylist writes the VOD playlist of an edited video at path,
+// with a discontinuity between the segments of consecutive parts and before the segments marked as discontinuity.
+func writeEditPlaylist(path string, parts [][]playlistSegment, hls HLSOptions) error {
+	target := 0.0
+	for _, segments := range parts {
+		for _, segment := range segments {
+			target = math.Max(target, segment.duration)
+		}
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	if hls.IndependentSegments {
+		playlist.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+
+	for i, segments := range parts {
+		for k, segment := range segments {
+			// Timestamps and encoding parameters restart with every part, and between the copied and re-encoded ranges of a part
+			if (i > 0 && k == 0) || segment.discontinuity {
+				playlist.WriteString("#EXT-X-DISCONTINUITY\n")
+			}
+			fmt.Fprintf(&playlist, "#EXTINF:%.6f,\n%s\n", segment.duration, segment.uri)
+		}
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	if err := writeFileAtomic(path, []byte(playlist.String())); err != nil {
+		return fmt.Errorf("error writing playlist: %w", err)
+	}
+	return nil
+}
+
+// linkOrCopyFile hard links source to target, or copies it if the files are on different file systems.
+// Hard links keep the copied segments of an edited video independent of the source video, which can be deleted.
+func linkOrCopyFile(source, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	return copyFile(source, target)
+}
+
+// CreateAudioEdit cuts the parts from the stored MP3 files of existing audios and joins them into the new audio id,
+// written as "<outputStorage>/audios/<id>.mp3" with its waveform and metadata. The sources are read from mediaStorage.
+//
+// MP3 frames are decoded independently, so the parts are copied without re-encoding and cut at the closest frame
+// (about 26 ms). The additional outputs, HLS renditions, tags and cover art of the sources are not copied.
+func CreateAudioEdit(mediaStorage, outputStorage, id string, parts []EditPart) error {
+	var list strings.Builder
+	for i := range parts {
+		sourcePath, err := ResolveMediaFile(mediaStorage, "audio", parts[i].Id)
+		if err != nil {
+			return err
+		}
+		if filepath.Ext(sourcePath) != ".mp3" {
+			return fmt.Errorf("unsupported audio format: %s", sourcePath)
+		}
+		sourcePath, err = filepath.Abs(sourcePath) // The concat list resolves relative paths against its own directory
+		if err != nil {
+			return err
+		}
+
+		duration, err := ProbeDuration(sourcePath)
+		if err != nil {
+			return err
+		}
+		if duration > 0 && (parts[i].End == 0 || parts[i].End > duration) {
+			parts[i].End = duration
+		}
+		if parts[i].End > 0 && parts[i].Start >= parts[i].End {
+			return fmt.Errorf("empty range %g-%g of audio %s with a duration of %g seconds", parts[i].Start, parts[i].End, parts[i].Id, duration)
+		}
+		parts[i].Copied = true
+
+		// Single quotes are escaped as '\'' in the quoted file names of the concat demuxer
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(sourcePath, "'", `'\''`))
+		fmt.Fprintf(&list, "inpoint %s\n", strconv.FormatFloat(parts[i].Start, 'f', 3, 64))
+		if parts[i].End > 0 {
+			fmt.Fprintf(&list, "outpoint %s\n", strconv.FormatFloat(parts[i].End, 'f', 3, 64))
+		}
+	}
+
+	mediaDir := MediaDir(outputStorage, "audio", id)
+	if err := CreateDir(mediaDir); err != nil {
+		return fmt.Errorf("error creating media directory: %w", err)
+	}
+
+	listPath := filepath.Join(mediaDir, "concat.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return fmt.Errorf("error writing concat list: %w", err)
+	}
+	defer os.Remove(listPath)
+
+	outputPath := fmt.Sprintf("%s/audios/%s.mp3", outputStorage, id)
+	if err := ConcatAudio(listPath, outputPath); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+
+	if err := CreateWaveform(outputPath, mediaDir+"/"+WaveformFileName, DefaultWaveform); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+
+	metadata := NewAudioMetadata(id, nil, "", AudioProcessing{}, nil)
+	metadata.Parts = parts
+	if err := WriteMetadata(outputStorage, metadata); err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LocksDir is the directory of the lock files of the media files in the staging directory of the media storage.
+// It is a hidden directory, so its lock files are not matched by StagingStorages.
+const LocksDir = ".locks"
+
+// mediaLocks holds a mutex per lock file, so the jobs of a media file are serialized within the process
+// on every platform, the file lock serializes them with the other consumers sharing the media storage.
+var mediaLocks sync.Map
+
+// LockMedia serializes the jobs changing a published media file, e.g. adding a caption track to a video
+// or publishing a reprocessed version, which read and rewrite its metadata. It blocks until the lock is taken,
+// the returned function releases it.
+//
+// CAUTION: The lock is a file in the media storage, jobs are only serialized between the consumers sharing it.
+// Consumers writing to an object storage from separate disks rely on the order of the Kafka messages of the media file.
+func LockMedia(mediaStorage, mediaType, id string) (func(), error) {
+	lockDir := filepath.Join(mediaStorage, StagingDir, LocksDir)
+	lockPath := filepath.Join(lockDir, fmt.Sprintf("%ss-%s.lock", mediaType, id))
+
+	value, _ := mediaLocks.LoadOrStore(lockPath, &sync.Mutex{})
+	mutex := value.(*sync.Mutex)
+	mutex.Lock()
+
+	if err := CreateDir(lockDir); err != nil {
+		mutex.Unlock()
+		return nil, fmt.Errorf("error creating lock directory: %w", err)
+	}
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		mutex.Unlock()
+		return nil, fmt.Errorf("error opening lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		mutex.Unlock()
+		return nil, fmt.Errorf("error locking %s %s: %w", mediaType, id, err)
+	}
+
+	return func() {
+		file.Close() // Releases the file lock
+		mutex.Unlock()
+	}, nil
+}
+
+// stagingMediaStorage returns the media storage of a staging storage, see StagingStorage.
+func stagingMediaStorage(stagingStorage string) string {
+	return filepath.Dir(filepath.Dir(stagingStorage))
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// MetadataFileName is the name of the metadata file in the media directory of every media file.
+const MetadataFileName = "metadata.json"
+
+// MediaMetadata holds the metadata of a processed media file, written by the consumers
+// to "<MediaStorage>/<type>s/<id>/metadata.json".
+type MediaMetadata struct {
+	ID          string               `json:"id"`                    // Id of the media file
+	Type        string               `json:"type"`                  // Media type: "image", "video" or "audio"
+	Extension   string               `json:"extension,omitempty"`   // Extension of the served file, e.g. ".png", empty for videos
+	Variants    []ImageVariant       `json:"variants,omitempty"`    // Responsive variants of an image, stored in the media directory
+	Fallback    string               `json:"fallback,omitempty"`    // File name of the GIF fallback of an animated WebP image in the media directory
+	Source      *ImageSourceMetadata `json:"source,omitempty"`      // Dimensions and orientation of the uploaded image, its camera metadata is written to CameraFileName
+	Placeholder *topics.Placeholder  `json:"placeholder,omitempty"` // BlurHash and dominant colour of the image or video poster
+	Poster      string               `json:"poster,omitempty"`      // File name of the poster image in the media directory of a video
+	Previews    []string             `json:"previews,omitempty"`    // File names of the preview clips in the media directory of a video, e.g. "preview.mp4"
+	Waveform    string               `json:"waveform,omitempty"`    // File name of the waveform peaks in the media directory of an audio
+	Outputs     []AudioOutput        `json:"outputs,omitempty"`     // Additional formats and bitrates of an audio, stored in the media directory
+	HLS         string               `json:"hls,omitempty"`         // Path of the HLS master playlist of an audio in the media directory, e.g. "hls/master.m3u8"
+	Loudness    *Loudness            `json:"loudness,omitempty"`    // Target and measured loudness of a normalized audio or video
+	Tags        *AudioTags           `json:"tags,omitempty"`        // Tags written into the outputs of an audio
+	Cover       string               `json:"cover,omitempty"`       // File name of the cover art image in the media directory of an audio
+	AudioTracks []VideoAudioTrack    `json:"audioTracks,omitempty"` // Alternate audio renditions of a video, stored in the "audio" directory of the media directory
+	Captions    []CaptionTrack       `json:"captions,omitempty"`    // Caption tracks of a video, stored in the "captions" directory of the media directory
+	Master      string               `json:"master,omitempty"`      // File name of the HLS master playlist of a video in the media directory, written once an audio or caption track is added
+	Parts       []EditPart           `json:"parts,omitempty"`       // Source ranges of a clipped or joined video or audio
+	Watermark   string               `json:"watermark,omitempty"`   // Name of the watermark profile burned into a video or image
+	Version     int                  `json:"version,omitempty"`     // Version of the media file, incremented by every published reprocess job, empty for the first version
+	RequestedAt *time.Time           `json:"requestedAt,omitempty"` // Request time of the reprocess job that published the version
+	CreatedAt   time.Time            `json:"createdAt"`             // Time the media file was processed
+}
+
+// MediaDir returns the media directory of a media file, e.g. "media_docker_files/images/<id>".
+// Videos are served from this directory, images and audios are served next to it as "<id>.<ext>".
+func MediaDir(mediaStorage, mediaType, id string) string {
+	return fmt.Sprintf("%s/%ss/%s", mediaStorage, mediaType, id)
+}
+
+// WriteMetadata writes the metadata into the media directory of the media file, creating the directory if needed.
+// Readers never see a partially written file, see writeFileAtomic. The camera metadata of an image source
+// is written into the hidden CameraFileName instead, as metadata.json is served.
+func WriteMetadata(mediaStorage string, metadata *MediaMetadata) error {
+	dir := MediaDir(mediaStorage, metadata.Type, metadata.ID)
+	if err := CreateDir(dir); err != nil {
+		return fmt.Errorf("error creating media directory: %w", err)
+	}
+
+	if metadata.Source != nil && len(metadata.Source.Camera) > 0 {
+		camera, err := json.MarshalIndent(metadata.Source.Camera, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding camera metadata: %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(dir, CameraFileName), camera); err != nil {
+			return fmt.Errorf("error writing camera metadata: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding metadata: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, MetadataFileName), data); err != nil {
+		return fmt.Errorf("error writing metadata: %w", err)
+	}
+
+	return nil
+}
+
+// ReadMetadata reads the metadata of a media file.
+// The returned error wraps os.ErrNotExist if no metadata was written for the media file.
+func ReadMetadata(mediaStorage, mediaType, id string) (*MediaMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(MediaDir(mediaStorage, mediaType, id), MetadataFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata: %w", err)
+	}
+
+	var metadata MediaMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("error decoding metadata: %w", err)
+	}
+
+	return &metadata, nil
+}
+
+// ResolveMediaFile returns the path of the served file of a media file: the media directory for videos,
+// and "<MediaStorage>/<type>s/<id><ext>" for images and audios, with the extension recorded in the metadata.
+// The returned error wraps os.ErrNotExist if the media file does not exist.
+//
+// INFO: Media files processed before the metadata was recorded (e.g. "<id>.jpeg") are found by their id.
+func ResolveMediaFile(mediaStorage, mediaType, id string) (string, error) {
+	dir := MediaDir(mediaStorage, mediaType, id)
+	if mediaType == "video" {
+		if _, err := os.Stat(dir); err != nil {
+			return "", err
+		}
+		return dir, nil
+	}
+
+	if metadata, err := ReadMetadata(mediaStorage, mediaType, id); err == nil && metadata.Extension != "" {
+		path := dir + metadata.Extension
+		if _, err := os.Stat(path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	// The id is a validated uuid, so it can not contain any glob patterns
+	matches, err := filepath.Glob(dir + ".*")
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("media file %s: %w", dir, os.ErrNotExist)
+	}
+	return matches[0], nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it to path,
+// so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return nil
+}
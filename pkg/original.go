@@ -0,0 +1,248 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OriginalMetadataFileName is the name of the metadata file of an archived original, next to the original.
+const OriginalMetadataFileName = "original.json"
+
+// Original is the archived upload of a media file, kept to process the media file again with other settings.
+type Original struct {
+	ID         string    `json:"id"`         // Id of the media file processed from the original
+	Type       string    `json:"type"`       // Media type: "image", "video" or "audio"
+	File       string    `json:"file"`       // File name of the original in its directory, e.g. "original.mp4"
+	Size       int64     `json:"size"`       // Size of the original in bytes
+	SHA256     string    `json:"sha256"`     // Hex encoded SHA-256 checksum of the original
+	ArchivedAt time.Time `json:"archivedAt"` // Time the original was archived
+}
+
+// OriginalsDir is the hidden directory of the storage originals are archived in, hidden keys are never served by the client.
+const OriginalsDir = ".originals"
+
+// OriginalDir returns the directory of the archived original of a media file, e.g. "media_docker_originals/videos/<id>".
+// Originals are kept outside of the media storage, so they are never served.
+func OriginalDir(originalStorage, mediaType, id string) string {
+	return fmt.Sprintf("%s/%ss/%s", originalStorage, mediaType, id)
+}
+
+// OriginalKey returns the key prefix of the archived original of a media file in a storage which is not a LocalStorage,
+// e.g. ".originals/videos/<id>". The objects below it have the same names as the files of OriginalDir.
+func OriginalKey(mediaType, id string) string {
+	return fmt.Sprintf("%s/%ss/%s", OriginalsDir, mediaType, id)
+}
+
+// ArchiveOriginal archives the upload at sourcePath as the original of the media file "original<ext>",
+// and records its size and SHA-256 checksum in "original.json". An existing original of the media file is replaced.
+//
+// For a LocalStorage the upload is moved into the original directory in originalStorage, it is copied and removed
+// if the original storage is on another file system. Other storages keep the original below OriginalKey,
+// so every consumer and the server can read it, and the upload is left at sourcePath.
+func ArchiveOriginal(storage Storage, originalStorage, mediaType, id, sourcePath string) (*Original, error) {
+	if _, local := localRoot(storage); !local {
+		return storeOriginal(storage, mediaType, id, sourcePath)
+	}
+
+	dir := OriginalDir(originalStorage, mediaType, id)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("error removing previous original: %w", err)
+	}
+	if err := CreateDir(dir); err != nil {
+		return nil, fmt.Errorf("error creating original directory: %w", err)
+	}
+
+	name := "original" + strings.ToLower(filepath.Ext(sourcePath))
+	target := filepath.Join(dir, name)
+	if err := os.Rename(sourcePath, target); err != nil {
+		if err := copyFile(sourcePath, target); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("error archiving original: %w", err)
+		}
+		os.Remove(sourcePath)
+	}
+
+	size, checksum, err := fileSHA256(target)
+	if err != nil {
+		return nil, err
+	}
+
+	original := &Original{ID: id, Type: mediaType, File: name, Size: size, SHA256: checksum, ArchivedAt: time.Now()}
+	data, err := json.MarshalIndent(original, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding original metadata: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, OriginalMetadataFileName), data); err != nil {
+		return nil, fmt.Errorf("error writing original metadata: %w", err)
+	}
+
+	return original, nil
+}
+
+// storeOriginal uploads the upload at sourcePath as the original of a media file below OriginalKey, see ArchiveOriginal.
+// The metadata is uploaded last, so an original is complete once its metadata is found,
+// and the objects of a replaced original which were not uploaded again are removed.
+func storeOriginal(storage Storage, mediaType, id, sourcePath string) (*Original, error) {
+	prefix := OriginalKey(mediaType, id)
+	existing, err := storage.List(prefix + "/")
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %w", prefix, err)
+	}
+
+	size, checksum, err := fileSHA256(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	name := "original" + strings.ToLower(filepath.Ext(sourcePath))
+	if err := uploadObject(storage, prefix+"/"+name, sourcePath); err != nil {
+		return nil, fmt.Errorf("error archiving original: %w", err)
+	}
+
+	original := &Original{ID: id, Type: mediaType, File: name, Size: size, SHA256: checksum, ArchivedAt: time.Now()}
+	data, err := json.MarshalIndent(original, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding original metadata: %w", err)
+	}
+	metadataKey := prefix + "/" + OriginalMetadataFileName
+	if err := storage.Put(metadataKey, bytes.NewReader(data), int64(len(data)), "application/json"); err != nil {
+		return nil, fmt.Errorf("error writing original metadata: %w", err)
+	}
+
+	removeStaleObjects(storage, existing, []string{prefix + "/" + name, metadataKey}, nil)
+	return original, nil
+}
+
+// ReadOriginal reads the metadata of the archived original of a media file and verifies the checksum of the original,
+// so a media file is never processed again from a corrupted original. It returns the metadata and the path of the original.
+// Originals of a storage which is not a LocalStorage are fetched into workDir, e.g. the staging storage of the job.
+// The returned error wraps os.ErrNotExist if the media file has no archived original.
+func ReadOriginal(storage Storage, originalStorage, workDir, mediaType, id string) (*Original, string, error) {
+	original, path, err := StatOriginal(storage, originalStorage, mediaType, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, local := localRoot(storage); !local {
+		target := filepath.Join(workDir, original.File)
+		if err := FetchObject(storage, path, target); err != nil {
+			return nil, "", fmt.Errorf("error fetching original: %w", err)
+		}
+		path = target
+	}
+
+	size, checksum, err := fileSHA256(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if size != original.Size || checksum != original.SHA256 {
+		return nil, "", fmt.Errorf("checksum mismatch of original %s", path)
+	}
+
+	return original, path, nil
+}
+
+// StatOriginal reads the metadata of the archived original of a media file without verifying the checksum,
+// see ReadOriginal. It returns the metadata and the path of the original, or its key in a storage
+// which is not a LocalStorage. The returned error wraps os.ErrNotExist if the media file has no archived original.
+func StatOriginal(storage Storage, originalStorage, mediaType, id string) (*Original, string, error) {
+	dir := OriginalDir(originalStorage, mediaType, id)
+	_, local := localRoot(storage)
+	if !local {
+		dir = OriginalKey(mediaType, id)
+	}
+
+	data, err := readOriginalMetadata(storage, local, dir+"/"+OriginalMetadataFileName)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading original metadata: %w", err)
+	}
+
+	var original Original
+	if err := json.Unmarshal(data, &original); err != nil {
+		return nil, "", fmt.Errorf("error decoding original metadata: %w", err)
+	}
+
+	if !local {
+		return &original, dir + "/" + original.File, nil
+	}
+	return &original, filepath.Join(dir, original.File), nil
+}
+
+// readOriginalMetadata reads the metadata of an original from the file at name, or from the object name of the storage.
+func readOriginalMetadata(storage Storage, local bool, name string) ([]byte, error) {
+	if local {
+		return os.ReadFile(name)
+	}
+
+	body, err := storage.Get(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// DeleteOriginal removes the archived original of a media file from originalStorage,
+// and from storages which are not a LocalStorage. A missing original is not an error.
+func DeleteOriginal(storage Storage, originalStorage, mediaType, id string) error {
+	if err := os.RemoveAll(OriginalDir(originalStorage, mediaType, id)); err != nil {
+		return err
+	}
+	if _, local := localRoot(storage); local {
+		return nil
+	}
+
+	objects, err := storage.List(OriginalKey(mediaType, id) + "/")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := storage.Delete(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies source to target, which is created or truncated.
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fileSHA256 returns the size and the hex encoded SHA-256 checksum of the file at path.
+func fileSHA256(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", fmt.Errorf("error computing checksum: %w", err)
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
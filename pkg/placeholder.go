@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// PosterFileName is the name of the poster image in the media directory of a video.
+const PosterFileName = "poster.jpeg"
+
+const (
+	placeholderSize        = 32 // Width and height in pixels the image is scaled to before encoding
+	placeholderXComponents = 4  // Horizontal BlurHash components
+	placeholderYComponents = 3  // Vertical BlurHash components
+)
+
+// CreatePlaceholder computes the BlurHash and dominant colour of the image at imagePath.
+//
+// The image is decoded and scaled down to 32x32 pixels by ffmpeg, which is enough for both values,
+// as a BlurHash only keeps a few low frequency components. Transparent pixels are blended over white.
+func CreatePlaceholder(imagePath string) (*topics.Placeholder, error) {
+	cmd := exec.Command("ffmpeg",
+		"-v", "error",
+		"-i", imagePath, // Input image file
+		"-frames:v", "1", // Decode a single frame
+		"-vf", fmt.Sprintf("scale=%d:%d:flags=area", placeholderSize, placeholderSize), // Average the image down
+		"-pix_fmt", "rgba", // 4 bytes per pixel, keeping the transparency
+		"-f", "rawvideo", "pipe:1", // Write the raw pixels to stdout
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("command: %s, %s", cmd.String(), err)
+	}
+	if len(output) != placeholderSize*placeholderSize*4 {
+		return nil, fmt.Errorf("unexpected pixel data size %d: %s", len(output), imagePath)
+	}
+
+	// Blend the pixels over white, so transparent images get a light placeholder instead of a black one
+	pixels := make([]byte, 0, placeholderSize*placeholderSize*3)
+	for p := 0; p < len(output); p += 4 {
+		alpha := int(output[p+3])
+		for c := 0; c < 3; c++ {
+			pixels = append(pixels, byte((int(output[p+c])*alpha+255*(255-alpha))/255))
+		}
+	}
+
+	hash, err := EncodeBlurHash(pixels, placeholderSize, placeholderSize, placeholderXComponents, placeholderYComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &topics.Placeholder{BlurHash: hash, DominantColor: DominantColor(pixels)}, nil
+}
+
+// ReadPlaceholder returns the placeholder recorded in the metadata of a media file,
+// or nil if the media file has no placeholder. The fileType is the file type of the response message,
+// so "videoResolutions" reads the metadata of a video, and "clip" and "concat" the metadata of the new video or audio.
+func ReadPlaceholder(storage Storage, fileType, id string) *topics.Placeholder {
+	if fileType == "videoResolutions" {
+		fileType = "video"
+	}
+
+	// Clipped and joined media files are videos or audios, ids are unique across both
+	if fileType == "clip" || fileType == "concat" {
+		if placeholder := ReadPlaceholder(storage, "video", id); placeholder != nil {
+			return placeholder
+		}
+		fileType = "audio"
+	}
+
+	metadata, err := ReadStoredMetadata(storage, fileType, id)
+	if err != nil {
+		return nil
+	}
+	return metadata.Placeholder
+}
+
+// CreateVideoPoster writes the poster image of a video as PosterFileName into outputDir
+// and returns the placeholder of the poster.
+func CreateVideoPoster(videoPath, outputDir string) (*topics.Placeholder, error) {
+	posterPath := fmt.Sprintf("%s/%s", outputDir, PosterFileName)
+	if err := ExtractPoster(videoPath, posterPath); err != nil {
+		return nil, err
+	}
+	return CreatePlaceholder(posterPath)
+}
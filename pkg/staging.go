@@ -0,0 +1,263 @@
+package pkg
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// StagingDir is the directory of the media storage the jobs write their outputs into before they are published.
+// It is on the file system of the media storage, so outputs are published to a LocalStorage by renaming them,
+// and it is never served, see middleware.FileServer. Outputs published to other storages are uploaded from it.
+const StagingDir = ".staging"
+
+// StagingStorage returns the media storage a job writes into, "<MediaStorage>/.staging/<id>" for the first version
+// of a media file and "<MediaStorage>/.staging/<id>.<jobId>" for reprocess jobs.
+// The outputs of the job have the same layout below it as in the media storage (e.g. "<StagingStorage>/videos/<id>/index.m3u8"),
+// so the processors write into it like into the media storage. Once the outputs of a reprocess job are published,
+// it holds the previous version of the media file.
+func StagingStorage(mediaStorage, id string, reprocess *topics.Reprocess) string {
+	if reprocess == nil {
+		return fmt.Sprintf("%s/%s/%s", mediaStorage, StagingDir, id)
+	}
+	return fmt.Sprintf("%s/%s/%s.%s", mediaStorage, StagingDir, id, reprocess.JobId)
+}
+
+// StagingStorages returns the staging storages of all jobs of a media file, which are not published yet.
+func StagingStorages(mediaStorage, id string) ([]string, error) {
+	// The id is a validated uuid, so it can not contain any glob patterns
+	return filepath.Glob(fmt.Sprintf("%s/%s/%s*", mediaStorage, StagingDir, id))
+}
+
+// PublishOutputs publishes the outputs of a job from its staging storage into the storage,
+// so players never see partially written outputs. The media directory, and the served file of images and audios,
+// are renamed into a LocalStorage, or uploaded to other storages, see uploadOutputs.
+// The first version of a media file must not exist yet.
+//
+// The outputs of a reprocess job replace the served version of the media file, and the incremented version
+// and the request time of the job are recorded in their metadata. The caption tracks of a served video are copied
+// into the new version. They are exchanged atomically with the served version, so players read either the previous
+// or the new version, and the previous version is left in the staging storage, which is removed by the caller.
+// A job requested before the served version is rejected, so the latest requested version is served
+// even if jobs complete out of order.
+//
+// A reprocess job is published under the lock of the media file, see LockMedia, so the served metadata
+// and caption tracks are read right before the exchange, and a caption track added while the job ran is kept.
+//
+// CAUTION: The served URLs of a reprocessed media file are unchanged, except the file of an image reprocessed
+// into another format. Players and caches holding files of the previous version keep them until they expire,
+// the client never serves them as immutable.
+func PublishOutputs(storage Storage, stagingStorage, mediaType, id string, reprocess *topics.Reprocess) error {
+	metadata, err := ReadMetadata(stagingStorage, mediaType, id)
+	if err != nil {
+		return err
+	}
+
+	var served *MediaMetadata
+	if reprocess != nil {
+		unlock, err := LockMedia(stagingMediaStorage(stagingStorage), mediaType, id)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if served, err = ReadStoredMetadata(storage, mediaType, id); err != nil {
+			return err
+		}
+		if err := prepareVersion(stagingStorage, served, metadata, reprocess); err != nil {
+			return err
+		}
+	}
+
+	mediaStorage, local := localRoot(storage)
+	if !local {
+		return uploadOutputs(storage, stagingStorage, metadata, served)
+	}
+
+	servedDir := MediaDir(mediaStorage, mediaType, id)
+	stagedDir := MediaDir(stagingStorage, mediaType, id)
+	if err := CreateDir(filepath.Dir(servedDir)); err != nil {
+		return fmt.Errorf("error creating media directory: %w", err)
+	}
+
+	// The caption tracks of the served video are linked into the new version, uploads keep them in place
+	if served != nil && mediaType == "video" && len(served.Captions) > 0 {
+		if err := copyDir(filepath.Join(servedDir, CaptionsDir), filepath.Join(stagedDir, CaptionsDir)); err != nil {
+			return fmt.Errorf("error copying caption tracks: %w", err)
+		}
+	}
+
+	if mediaType == "video" {
+		if err := publishPath(stagedDir, servedDir, reprocess != nil); err != nil {
+			return fmt.Errorf("error publishing %s: %w", mediaType, err)
+		}
+		return nil
+	}
+
+	// Images and audios are served next to their media directory, the file is published first,
+	// so the metadata never records an extension whose file is not published yet
+	servedFile := ""
+	if reprocess != nil {
+		if servedFile, err = ResolveMediaFile(mediaStorage, mediaType, id); err != nil {
+			return err
+		}
+	}
+	target := servedDir + metadata.Extension
+	if err := publishPath(stagedDir+metadata.Extension, target, reprocess != nil); err != nil {
+		return fmt.Errorf("error publishing %s: %w", mediaType, err)
+	}
+	if err := publishPath(stagedDir, servedDir, reprocess != nil); err != nil {
+		exchangePaths(target, stagedDir+metadata.Extension) // Serve the previous file again, or none
+		return fmt.Errorf("error publishing %s: %w", mediaType, err)
+	}
+
+	// The file of the previous version is left if the extension changed, e.g. from ".jpg" to ".webp"
+	if servedFile != "" && servedFile != target {
+		if err := os.Rename(servedFile, stagedDir+filepath.Ext(servedFile)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing previous version: %w", err)
+		}
+	}
+	return nil
+}
+
+// UnpublishOutputs moves the published first version of a media file back into the staging storage of its job,
+// used if the job fails after its outputs were published, e.g. archiving the original failed.
+// Moving them back, instead of deleting them, frees their paths at once for a retry of the job.
+// Uploaded outputs are deleted from other storages, their staged files are still in the staging storage.
+func UnpublishOutputs(storage Storage, stagingStorage, mediaType, id string) error {
+	mediaStorage, local := localRoot(storage)
+	if !local {
+		return DeleteMedia(storage, mediaType, id)
+	}
+
+	metadata, err := ReadMetadata(mediaStorage, mediaType, id)
+	if err != nil {
+		return err
+	}
+	servedDir := MediaDir(mediaStorage, mediaType, id)
+	stagedDir := MediaDir(stagingStorage, mediaType, id)
+
+	if mediaType != "video" {
+		if err := os.Rename(servedDir+metadata.Extension, stagedDir+metadata.Extension); err != nil {
+			return err
+		}
+	}
+	return os.Rename(servedDir, stagedDir)
+}
+
+// prepareVersion rejects a stale reprocess job, and records the version of the outputs of the job in their metadata.
+// The caption tracks of the served video are listed in the outputs, they are not rendered from the original
+// and are kept by PublishOutputs.
+func prepareVersion(stagingStorage string, served, metadata *MediaMetadata, reprocess *topics.Reprocess) error {
+	if served.RequestedAt != nil && served.RequestedAt.After(reprocess.RequestedAt) {
+		return fmt.Errorf("stale version of %s %s, a later reprocess request is already served", metadata.Type, metadata.ID)
+	}
+
+	metadata.Version = max(served.Version, 1) + 1 // Media files never reprocessed are version 1
+	metadata.RequestedAt = &reprocess.RequestedAt
+
+	if metadata.Type == "video" && len(served.Captions) > 0 {
+		metadata.Captions = served.Captions
+		metadata.Master = VideoMasterPlaylist
+		if err := WriteVideoMasterPlaylist(MediaDir(stagingStorage, metadata.Type, metadata.ID), metadata); err != nil {
+			return err
+		}
+	}
+
+	return WriteMetadata(stagingStorage, metadata)
+}
+
+// uploadOutputs uploads the outputs of a job from its staging storage to a storage which is not a LocalStorage,
+// see uploadKeys, and removes the objects of the previous version which were not replaced. The first version
+// of a media file (served is nil) is rejected if its metadata was already published, objects left by a failed
+// upload are replaced. The caption tracks of the served video are kept.
+//
+// CAUTION: Objects are replaced one by one, players reading a reprocessed media file while its outputs are uploaded
+// may read segments of both versions.
+func uploadOutputs(storage Storage, stagingStorage string, metadata *MediaMetadata, served *MediaMetadata) error {
+	key := MediaKey(metadata.Type, metadata.ID)
+	existing, err := storage.List(key)
+	if err != nil {
+		return fmt.Errorf("error listing %s: %w", key, err)
+	}
+	if served == nil && slices.ContainsFunc(existing, func(object ObjectInfo) bool { return object.Key == key+"/"+MetadataFileName }) {
+		return fmt.Errorf("publish %s: %w", key, os.ErrExist)
+	}
+
+	keys, err := stagedKeys(stagingStorage, metadata.Type, metadata.ID, metadata.Extension)
+	if err != nil {
+		return fmt.Errorf("error listing outputs of %s: %w", key, err)
+	}
+	if err := uploadKeys(storage, stagingStorage, keys); err != nil {
+		return fmt.Errorf("error publishing %s: %w", metadata.Type, err)
+	}
+
+	removeStaleObjects(storage, existing, keys, func(object string) bool {
+		return strings.HasPrefix(object, key+"/"+CaptionsDir+"/")
+	})
+	return nil
+}
+
+// publishPath renames source to target. An existing target is exchanged atomically with source if replace is set,
+// and is an error wrapping os.ErrExist otherwise.
+func publishPath(source, target string, replace bool) error {
+	if replace {
+		return exchangePaths(source, target)
+	}
+	return renameNoReplace(source, target)
+}
+
+// checkedRename renames source to target if target does not exist, used if the file system does not support
+// renames without replacing. Target may be created between the check and the rename.
+func checkedRename(source, target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("rename %s %s: %w", source, target, os.ErrExist)
+	}
+	return os.Rename(source, target)
+}
+
+// exchangePaths atomically exchanges the files or directories at source and target, or renames source to target
+// if target does not exist. Once exchanged, source holds the previous target.
+func exchangePaths(source, target string) error {
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		return os.Rename(source, target)
+	}
+	return renameExchange(source, target)
+}
+
+// swapPaths exchanges the files or directories at source and target with three renames,
+// used if the file system does not support atomic exchanges. Target is missing between the renames.
+func swapPaths(source, target string) error {
+	swap := source + ".swap"
+	if err := os.Rename(target, swap); err != nil {
+		return err
+	}
+	if err := os.Rename(source, target); err != nil {
+		os.Rename(swap, target)
+		return err
+	}
+	return os.Rename(swap, source)
+}
+
+// copyDir copies the directory source with its files to target, hard linking the files where possible.
+// Hard links are safe for files that are replaced by renames and never modified, e.g. caption segments.
+func copyDir(source, target string) error {
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return CreateDir(filepath.Join(target, relative))
+		}
+		return linkOrCopyFile(path, filepath.Join(target, relative))
+	})
+}
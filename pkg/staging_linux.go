@@ -0,0 +1,36 @@
+//go:build linux
+
+package pkg
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// renameExchange atomically exchanges source and target with renameat2 and RENAME_EXCHANGE,
+// falling back to swapPaths on file systems or kernels without support for exchanges.
+func renameExchange(source, target string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, source, unix.AT_FDCWD, target, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		return swapPaths(source, target)
+	}
+	return err
+}
+
+// renameNoReplace atomically renames source to target with renameat2 and RENAME_NOREPLACE, failing if target exists,
+// falling back to checkedRename on file systems or kernels without support for it.
+func renameNoReplace(source, target string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, source, unix.AT_FDCWD, target, unix.RENAME_NOREPLACE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		return checkedRename(source, target)
+	}
+	return err
+}
+
+// lockFile takes an exclusive flock on the file, blocking until it is released by other processes.
+// The lock is released when the file is closed.
+func lockFile(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_EX)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package pkg
+
+import "os"
+
+// renameExchange exchanges source and target with swapPaths, atomic exchanges are only supported on Linux.
+func renameExchange(source, target string) error {
+	return swapPaths(source, target)
+}
+
+// renameNoReplace renames source to target with checkedRename, atomic renames without replacing are only supported on Linux.
+func renameNoReplace(source, target string) error {
+	return checkedRename(source, target)
+}
+
+// lockFile does not lock the file, file locks are only supported on Linux,
+// so the jobs of a media file are only serialized within a process.
+func lockFile(file *os.File) error {
+	return nil
+}
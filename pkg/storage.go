@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrPresignUnsupported is returned by Storage.Presign of storages whose objects can not be fetched directly,
+// e.g. LocalStorage, whose files are only served by media-docker-client.
+var ErrPresignUnsupported = errors.New("storage does not support presigned URLs")
+
+// ObjectInfo describes an object of a Storage.
+type ObjectInfo struct {
+	Key     string    // Key of the object, a slash separated path relative to the storage, e.g. "videos/<id>/index.m3u8"
+	Size    int64     // Size of the object in bytes
+	ModTime time.Time // Time the object was last written
+	ETag    string    // Hex MD5 of objects written in a single upload, empty if the storage does not provide it
+}
+
+// Storage holds the published outputs of the media files, with the same layout as the media storage
+// (e.g. "videos/<id>/index.m3u8" or "images/<id>.webp"). Directories are implicit, every key names a file.
+//
+// Errors of Get and Stat for missing objects wrap os.ErrNotExist.
+type Storage interface {
+	// Put writes the object key with size bytes read from body, replacing an existing object.
+	Put(key string, body io.Reader, size int64, contentType string) error
+	// Get reads the object key from the byte offset to its end.
+	Get(key string, offset int64) (io.ReadCloser, error)
+	// Stat returns the size, modification time and entity tag of the object key.
+	Stat(key string) (*ObjectInfo, error)
+	// Delete removes the object key, a missing object is not an error.
+	Delete(key string) error
+	// List returns all objects whose key starts with prefix.
+	List(prefix string) ([]ObjectInfo, error)
+	// Presign returns a URL fetching the object key without credentials until expiry,
+	// or ErrPresignUnsupported.
+	Presign(key string, expiry time.Duration) (string, error)
+}
+
+// LocalStorage is a Storage of the files in a directory on the local disk, e.g. the media storage.
+// Its files are published by atomic renames, see PublishOutputs.
+type LocalStorage struct {
+	Root string // Directory of the storage, e.g. "media_docker_files"
+}
+
+// NewLocalStorage returns the LocalStorage of the directory root.
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{Root: root}
+}
+
+// path returns the file path of key, rejecting keys leaving the root or naming hidden files, e.g. the staging directory.
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" || !fs.ValidPath(key) || strings.HasPrefix(key, ".") || strings.Contains(key, "/.") {
+		return "", fmt.Errorf("invalid storage key: %q", key)
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object key into a temporary file next to it and renames it into place,
+// so readers never see a partially written file.
+func (s *LocalStorage) Put(key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := CreateDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return nil
+}
+
+// Get opens the file of key at the byte offset.
+func (s *LocalStorage) Get(key string, offset int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Stat returns the size and modification time of the file of key, directories do not exist as objects.
+func (s *LocalStorage) Stat(key string) (*ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("storage object %s: %w", key, os.ErrNotExist)
+	}
+	return &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete removes the file of key, and its parent directories that are empty afterwards,
+// except the directories of the media types (e.g. "images"), which are created once by the consumers.
+func (s *LocalStorage) Delete(key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Directories are implicit, like the prefixes of an object storage
+	for dir := path.Dir(key); strings.Contains(dir, "/"); dir = path.Dir(dir) {
+		if os.Remove(filepath.Join(s.Root, filepath.FromSlash(dir))) != nil {
+			break // Not empty, or already removed
+		}
+	}
+	return nil
+}
+
+// List walks the directory of the prefix, skipping hidden files and directories.
+func (s *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	// Only the directory holding the prefix has to be walked, e.g. "images" for "images/<id>"
+	dir := path.Dir(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		dir = strings.TrimSuffix(prefix, "/")
+	}
+
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(filepath.Join(s.Root, filepath.FromSlash(dir)), func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relative, err := filepath.Rel(s.Root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if entry.IsDir() {
+			// Skip the directories which can not hold a key with the prefix
+			if !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") && key != "." {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// Presign is not supported, the files of a local storage are served by media-docker-client.
+func (s *LocalStorage) Presign(key string, expiry time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// MediaKey returns the key of the media directory of a media file, e.g. "videos/<id>", see MediaDir.
+// Images and audios are stored next to it as "<MediaKey><ext>".
+func MediaKey(mediaType, id string) string {
+	return fmt.Sprintf("%ss/%s", mediaType, id)
+}
@@ -0,0 +1,323 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// storageContentTypes maps the extensions of the outputs to the content types they are stored with.
+// The system mime database is not reliable for media files, e.g. ".ts" is often mapped to Qt translation files.
+var storageContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".vtt":  "text/vtt; charset=utf-8",
+	".json": "application/json",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".m4a":  "audio/mp4",
+	".opus": "audio/ogg; codecs=opus",
+}
+
+// segmentExtensions are the extensions of HLS segments, which are not needed to read the playlists of a video.
+var segmentExtensions = []string{".ts", ".m4s", ".aac", ".vtt"}
+
+// localRoot returns the root directory of a LocalStorage, whose files are read and published in place.
+func localRoot(storage Storage) (string, bool) {
+	if local, ok := storage.(*LocalStorage); ok {
+		return local.Root, true
+	}
+	return "", false
+}
+
+// ReadStoredMetadata reads the metadata of a published media file from the storage.
+// The returned error wraps os.ErrNotExist if no metadata was published for the media file.
+func ReadStoredMetadata(storage Storage, mediaType, id string) (*MediaMetadata, error) {
+	if root, ok := localRoot(storage); ok {
+		return ReadMetadata(root, mediaType, id)
+	}
+
+	body, err := storage.Get(MediaKey(mediaType, id)+"/"+MetadataFileName, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metadata: %w", err)
+	}
+	defer body.Close()
+
+	var metadata MediaMetadata
+	if err := json.NewDecoder(body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("error decoding metadata: %w", err)
+	}
+	return &metadata, nil
+}
+
+// ReadStoredCamera reads the camera metadata of a published image from the storage, see CameraFileName.
+// It is nil for images whose upload had no camera metadata.
+func ReadStoredCamera(storage Storage, id string) (map[string]string, error) {
+	var data []byte
+	var err error
+	if root, ok := localRoot(storage); ok {
+		// LocalStorage rejects hidden keys, the file is read from the media directory
+		data, err = os.ReadFile(filepath.Join(MediaDir(root, "image", id), CameraFileName))
+	} else {
+		var body io.ReadCloser
+		if body, err = storage.Get(MediaKey("image", id)+"/"+CameraFileName, 0); err == nil {
+			data, err = io.ReadAll(body)
+			body.Close()
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading camera metadata: %w", err)
+	}
+
+	var camera map[string]string
+	if err := json.Unmarshal(data, &camera); err != nil {
+		return nil, fmt.Errorf("error decoding camera metadata: %w", err)
+	}
+	return camera, nil
+}
+
+// ResolveStoredMedia returns the key of the served file of a published media file, see ResolveMediaFile:
+// the media directory for videos (e.g. "videos/<id>"), and "<type>s/<id><ext>" for images and audios.
+// The returned error wraps os.ErrNotExist if the media file does not exist.
+func ResolveStoredMedia(storage Storage, mediaType, id string) (string, error) {
+	if root, ok := localRoot(storage); ok {
+		filePath, err := ResolveMediaFile(root, mediaType, id)
+		if err != nil {
+			return "", err
+		}
+		relative, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return "", err
+		}
+		return filepath.ToSlash(relative), nil
+	}
+
+	// The metadata is published last, a media file without metadata is not published yet
+	metadata, err := ReadStoredMetadata(storage, mediaType, id)
+	if err != nil {
+		return "", err
+	}
+	key := MediaKey(mediaType, id)
+	if mediaType == "video" {
+		return key, nil
+	}
+	if _, err := storage.Stat(key + metadata.Extension); err != nil {
+		return "", err
+	}
+	return key + metadata.Extension, nil
+}
+
+// DeleteMedia removes the served file and the media directory of a media file from the storage.
+// Media files processed before the metadata was recorded are found by their id.
+func DeleteMedia(storage Storage, mediaType, id string) error {
+	if root, ok := localRoot(storage); ok {
+		var err error
+		if mediaType != "video" {
+			var filePath string
+			if filePath, err = ResolveMediaFile(root, mediaType, id); err == nil {
+				err = os.Remove(filePath)
+			}
+		}
+		// The media directory does not exist for older images and audios
+		return errors.Join(err, os.RemoveAll(MediaDir(root, mediaType, id)))
+	}
+
+	objects, err := storage.List(MediaKey(mediaType, id))
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("media file %s: %w", MediaKey(mediaType, id), os.ErrNotExist)
+	}
+
+	// The metadata is removed first, so the media file is no longer resolved while its files are removed
+	slices.SortStableFunc(objects, func(a, b ObjectInfo) int {
+		return compareBool(path.Base(a.Key) != MetadataFileName, path.Base(b.Key) != MetadataFileName)
+	})
+	for _, object := range objects {
+		if deleteErr := storage.Delete(object.Key); deleteErr != nil {
+			err = errors.Join(err, deleteErr)
+		}
+	}
+	return err
+}
+
+// FetchMedia downloads the published files of a media file from the storage into mediaStorage, a local directory
+// with the layout of the media storage, e.g. to read a video stored in an object storage with ffmpeg.
+// Only the keys accepted by include are downloaded, all keys if it is nil.
+func FetchMedia(storage Storage, mediaStorage, mediaType, id string, include func(key string) bool) error {
+	objects, err := storage.List(MediaKey(mediaType, id))
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("media file %s: %w", MediaKey(mediaType, id), os.ErrNotExist)
+	}
+
+	for _, object := range objects {
+		if include != nil && !include(object.Key) {
+			continue
+		}
+		if err := FetchObject(storage, object.Key, filepath.Join(mediaStorage, filepath.FromSlash(object.Key))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchEditSources returns the media storage the sources of an edit are read from, see CreateVideoEdit.
+// The sources are read in place from a LocalStorage, and are downloaded into workStorage from other storages.
+func FetchEditSources(storage Storage, workStorage, mediaType string, parts []EditPart) (string, error) {
+	if root, ok := localRoot(storage); ok {
+		return root, nil
+	}
+
+	fetched := map[string]bool{}
+	for _, part := range parts {
+		if fetched[part.Id] {
+			continue
+		}
+		if err := FetchMedia(storage, workStorage, mediaType, part.Id, nil); err != nil {
+			return "", fmt.Errorf("error fetching %s %s: %w", mediaType, part.Id, err)
+		}
+		fetched[part.Id] = true
+	}
+	return workStorage, nil
+}
+
+// FetchObject downloads the object key into the file target, creating its directory.
+func FetchObject(storage Storage, key, target string) error {
+	body, err := storage.Get(key, 0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := CreateDir(filepath.Dir(target)); err != nil {
+		return err
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		return fmt.Errorf("error fetching %s: %w", key, err)
+	}
+	return file.Close()
+}
+
+// stagedKeys returns the keys of the outputs of a media file in a local media storage, e.g. a staging storage:
+// the files of its media directory, and the served file of images and audios.
+func stagedKeys(mediaStorage, mediaType, id, extension string) ([]string, error) {
+	keys := []string{}
+	if mediaType != "video" {
+		keys = append(keys, MediaKey(mediaType, id)+extension)
+	}
+
+	dir := MediaDir(mediaStorage, mediaType, id)
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == dir {
+				return nil // Images and audios without variants or outputs may have no media directory
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		relative, err := filepath.Rel(mediaStorage, filePath)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(relative))
+		return nil
+	})
+	return keys, err
+}
+
+// uploadKeys uploads the files of the keys from the local media storage into the storage, in the order players
+// need them: segments and other files first, then the playlists from the deepest (media playlists) to the master playlist,
+// then the served file of images and audios, and the metadata last. So every uploaded playlist references uploaded files,
+// and the media file is only resolved (see ResolveStoredMedia) once all its files are uploaded.
+func uploadKeys(storage Storage, mediaStorage string, keys []string) error {
+	ordered := slices.Clone(keys)
+	slices.SortStableFunc(ordered, func(a, b string) int { return uploadRank(a) - uploadRank(b) })
+
+	for _, key := range ordered {
+		if err := uploadObject(storage, key, filepath.Join(mediaStorage, filepath.FromSlash(key))); err != nil {
+			return fmt.Errorf("error uploading %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// uploadRank returns the position of the key in the upload order of uploadKeys, lower ranks are uploaded first.
+func uploadRank(key string) int {
+	switch {
+	case path.Base(key) == MetadataFileName:
+		return 1000
+	case strings.Count(key, "/") == 1:
+		return 900 // Served file of an image or audio, e.g. "images/<id>.webp"
+	case path.Ext(key) == ".m3u8":
+		return 100 - strings.Count(key, "/") // Deeper playlists first
+	default:
+		return 0
+	}
+}
+
+// uploadObject uploads the local file to the object key.
+func uploadObject(storage Storage, key, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	contentType, ok := storageContentTypes[strings.ToLower(path.Ext(key))]
+	if !ok {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	return storage.Put(key, file, info.Size(), contentType)
+}
+
+// removeStaleObjects removes the objects of a media file listed before an upload which were not uploaded again,
+// e.g. the segments of a rendition the new version does not have. Objects accepted by keep are not removed.
+// Failed removals are ignored, the remaining objects are no longer referenced by the published playlists.
+func removeStaleObjects(storage Storage, existing []ObjectInfo, uploaded []string, keep func(key string) bool) {
+	for _, object := range existing {
+		if slices.Contains(uploaded, object.Key) || (keep != nil && keep(object.Key)) {
+			continue
+		}
+		storage.Delete(object.Key)
+	}
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	default:
+		return 1
+	}
+}
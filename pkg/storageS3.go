@@ -0,0 +1,363 @@
+package pkg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3UnsignedPayload is the payload hash of requests whose body is not signed, e.g. uploads streamed from a file.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Storage is a Storage of the objects in a bucket of an S3-compatible object storage, e.g. AWS S3 or MinIO.
+// Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	Endpoint  *url.URL     // Endpoint of the object storage, e.g. "http://minio:9000" or "https://s3.eu-central-1.amazonaws.com"
+	Region    string       // Region of the bucket, e.g. "us-east-1" for MinIO
+	Bucket    string       // Name of the bucket
+	AccessKey string       // Access key id
+	SecretKey string       // Secret access key
+	PathStyle bool         // Address the bucket in the path ("<endpoint>/<bucket>/<key>") instead of the host ("<bucket>.<endpoint>/<key>")
+	Client    *http.Client // Client sending the requests
+}
+
+// NewS3Storage returns the S3Storage of the bucket at endpoint.
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey string, pathStyle bool) (*S3Storage, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is not provided")
+	}
+
+	return &S3Storage{
+		Endpoint:  parsed,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: pathStyle,
+		Client:    &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// Put uploads the object key in a single request, objects are limited to 5 GB.
+func (s *S3Storage) Put(key string, body io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest(http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads the object key from the byte offset to its end.
+func (s *S3Storage) Get(key string, offset int64) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		// The range of an offset at the end of the object is not satisfiable, there is nothing to read
+		if offset > 0 && strings.Contains(err.Error(), "status "+strconv.Itoa(http.StatusRequestedRangeNotSatisfiable)) {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat reads the headers of the object key.
+func (s *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	req, err := s.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{
+		Key:     key,
+		Size:    resp.ContentLength,
+		ModTime: modTime,
+		ETag:    strings.Trim(resp.Header.Get("ETag"), `"`),
+	}, nil
+}
+
+// Delete removes the object key, object storages do not report missing objects.
+func (s *S3Storage) Delete(key string) error {
+	req, err := s.newRequest(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3ListResult is the response of a ListObjectsV2 request.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List lists the objects with ListObjectsV2, following the continuation tokens of truncated results.
+func (s *S3Storage) List(prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.do(req, prefix)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding S3 object list: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     content.Key,
+				Size:    content.Size,
+				ModTime: content.LastModified,
+				ETag:    strings.Trim(content.ETag, `"`),
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Presign returns a presigned GET URL of the object key, valid for expiry (at most 7 days).
+func (s *S3Storage) Presign(key string, expiry time.Duration) (string, error) {
+	req, err := s.newRequest(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	s.presign(req, time.Now().UTC(), expiry)
+	return req.URL.String(), nil
+}
+
+// newRequest creates an unsigned request of the object key, or of the bucket if key is empty.
+func (s *S3Storage) newRequest(method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := *s.Endpoint
+	objectPath := "/" + key
+	if s.PathStyle {
+		objectPath = "/" + s.Bucket + objectPath
+	} else {
+		target.Host = s.Bucket + "." + target.Host
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + objectPath
+	target.RawPath = strings.TrimSuffix(target.Path[:len(target.Path)-len(objectPath)], "/") + s3EscapePath(objectPath)
+	target.RawQuery = s3CanonicalQuery(query)
+
+	return http.NewRequest(method, target.String(), body)
+}
+
+// do signs and sends the request, returning an error wrapping os.ErrNotExist for missing objects,
+// and an error holding the response of other failed requests.
+func (s *S3Storage) do(req *http.Request, key string) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("storage object %s: %w", key, os.ErrNotExist)
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("S3 %s %s failed with status %d: %s", req.Method, key, resp.StatusCode, strings.TrimSpace(string(message)))
+}
+
+// sign adds the Authorization header of AWS Signature Version 4 to the request, the payload is not signed.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-date":           amzDate,
+		"x-amz-content-sha256": s3UnsignedPayload,
+	}
+	for _, name := range []string{"Range", "Content-Type"} {
+		if value := req.Header.Get(name); value != "" {
+			headers[strings.ToLower(name)] = value
+		}
+	}
+
+	scope, signedHeaders, signature := s.signature(req, now, headers, s3UnsignedPayload)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// presign adds the query parameters of a presigned URL of AWS Signature Version 4 to the request.
+func (s *S3Storage) presign(req *http.Request, now time.Time, expiry time.Duration) {
+	amzDate := now.Format("20060102T150405Z")
+	query := req.URL.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	req.URL.RawQuery = s3CanonicalQuery(query)
+
+	_, _, signature := s.signature(req, now, map[string]string{"host": req.URL.Host}, s3UnsignedPayload)
+	req.URL.RawQuery += "&X-Amz-Signature=" + signature
+}
+
+// signature returns the credential scope, the signed header names and the signature of the request
+// with the signed headers (lower case names) and the payload hash.
+func (s *S3Storage) signature(req *http.Request, now time.Time, headers map[string]string, payloadHash string) (string, string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := s.scope(now)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// scope returns the credential scope of requests signed at now.
+func (s *S3Storage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes s as required by AWS Signature Version 4, every byte except the unreserved characters
+// "A-Z a-z 0-9 - _ . ~" is percent encoded with upper case hex digits.
+func s3Escape(s string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// s3EscapePath escapes every segment of the slash separated path with s3Escape.
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		segments[i] = s3Escape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery encodes the query sorted by name with s3Escape, as required by AWS Signature Version 4.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := []string{}
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
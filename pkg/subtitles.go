@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// subtitleCue is a single cue of a subtitle file.
+type subtitleCue struct {
+	start    time.Duration // Start time of the cue
+	end      time.Duration // End time of the cue
+	settings string        // WebVTT cue settings, e.g. "line:0 align:start", empty for SRT cues
+	text     string        // Text of the cue, one or more lines
+}
+
+// subtitleTimestampRegex matches SRT ("00:01:02,500") and WebVTT ("00:01:02.500" or "01:02.500") timestamps.
+var subtitleTimestampRegex = regexp.MustCompile(`^(?:(\d+):)?(\d{2}):(\d{2})[.,](\d{3})$`)
+
+// subtitleBlockRegex matches the blank lines separating the blocks of a subtitle file.
+var subtitleBlockRegex = regexp.MustCompile(`\n{2,}`)
+
+// srtFormattingRegex matches the <font> tags and {\...} override tags of SRT files, which WebVTT does not support.
+var srtFormattingRegex = regexp.MustCompile(`</?font[^>]*>|\{\\[^}]*\}`)
+
+// parseSubtitleTimestamp parses an SRT or WebVTT timestamp.
+func parseSubtitleTimestamp(value string) (time.Duration, error) {
+	match := subtitleTimestampRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, fmt.Errorf("invalid subtitle timestamp: %q", value)
+	}
+
+	hours := 0
+	if match[1] != "" {
+		hours, _ = strconv.Atoi(match[1])
+	}
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.Atoi(match[3])
+	milliseconds, _ := strconv.Atoi(match[4])
+
+	return time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second +
+		time.Duration(milliseconds)*time.Millisecond, nil
+}
+
+// formatVTTTimestamp formats d as a WebVTT timestamp, e.g. "00:01:02.500".
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// ParseSubtitles parses the cues of an SRT or WebVTT file, WebVTT files are detected by their "WEBVTT" header.
+//
+// SRT <font> and {\...} override tags are removed, as WebVTT does not support them.
+// WebVTT NOTE, STYLE and REGION blocks are dropped, only the cues and their settings are kept.
+func ParseSubtitles(data []byte) ([]subtitleCue, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Strip the UTF-8 byte order mark
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+	isVTT := strings.HasPrefix(text, "WEBVTT")
+
+	var cues []subtitleCue
+	for _, block := range subtitleBlockRegex.Split(strings.TrimSpace(text), -1) {
+		lines := strings.Split(block, "\n")
+
+		// The timing line is the first line of the block containing "-->",
+		// lines above it are the cue number of SRT or the cue identifier of WebVTT
+		timing := -1
+		for i, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 || timing > 1 {
+			continue // The header, NOTE, STYLE and REGION blocks have no timing line
+		}
+
+		times := strings.SplitN(lines[timing], "-->", 2)
+		start, err := parseSubtitleTimestamp(times[0])
+		if err != nil {
+			return nil, err
+		}
+		endFields := strings.Fields(times[1])
+		if len(endFields) == 0 {
+			return nil, fmt.Errorf("missing subtitle end timestamp: %q", lines[timing])
+		}
+		end, err := parseSubtitleTimestamp(endFields[0])
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			continue // Cues without a duration are never shown
+		}
+
+		cue := subtitleCue{start: start, end: end, text: strings.Join(lines[timing+1:], "\n")}
+		if isVTT {
+			cue.settings = strings.Join(endFields[1:], " ")
+		} else {
+			cue.text = srtFormattingRegex.ReplaceAllString(cue.text, "")
+		}
+		if strings.TrimSpace(cue.text) == "" {
+			continue
+		}
+		cues = append(cues, cue)
+	}
+
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no subtitle cues found")
+	}
+	return cues, nil
+}
+
+// WriteSubtitleSegments splits the cues into WebVTT segments of segmentDuration seconds and writes them
+// with their HLS playlist "index.m3u8" into outputDir, covering duration seconds of video.
+// Cues spanning a segment boundary are repeated in every segment they overlap, and cues after the end of the
+// video are written to the last segment.
+//
+// mpegts is the presentation timestamp (90 kHz) of the first video frame, written as X-TIMESTAMP-MAP
+// so the cues are synchronised with the MPEG-TS segments of the video.
+func WriteSubtitleSegments(outputDir string, cues []subtitleCue, duration float64, segmentDuration int, mpegts int64) error {
+	count := int(math.Ceil(duration / float64(segmentDuration)))
+	if count < 1 {
+		count = 1
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", segmentDuration)
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+
+	segment := time.Duration(segmentDuration) * time.Second
+	for i := 0; i < count; i++ {
+		segmentStart := time.Duration(i) * segment
+		segmentEnd := segmentStart + segment
+		length := float64(segmentDuration)
+		if i == count-1 {
+			length = duration - float64(i*segmentDuration)
+			segmentEnd = time.Duration(math.MaxInt64) // The last segment holds every remaining cue
+		}
+		if length <= 0 {
+			length = float64(segmentDuration) // Videos without a known duration get a single full segment
+		}
+
+		var vtt strings.Builder
+		vtt.WriteString("WEBVTT\n")
+		fmt.Fprintf(&vtt, "X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n", mpegts)
+		for _, cue := range cues {
+			if cue.start >= segmentEnd || cue.end <= segmentStart {
+				continue
+			}
+			vtt.WriteString("\n" + formatVTTTimestamp(cue.start) + " --> " + formatVTTTimestamp(cue.end))
+			if cue.settings != "" {
+				vtt.WriteString(" " + cue.settings)
+			}
+			vtt.WriteString("\n" + cue.text + "\n")
+		}
+
+		name := fmt.Sprintf("segment%d.vtt", i)
+		if err := os.WriteFile(outputDir+"/"+name, []byte(vtt.String()), 0644); err != nil {
+			return fmt.Errorf("error writing subtitle segment: %w", err)
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.6f,\n%s\n", length, name)
+	}
+
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	if err := os.WriteFile(outputDir+"/index.m3u8", []byte(playlist.String()), 0644); err != nil {
+		return fmt.Errorf("error writing subtitle playlist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package pkg
+
+import "path/filepath"
+
+// UploadsDir is the hidden directory of the storage uploads are handed off in, hidden keys are never served by the client.
+const UploadsDir = ".uploads"
+
+// HandOffUpload uploads the file at uploadPath into the storage, so it can be fetched by consumers which do not share
+// the upload storage of the server, and schedules the local file for deletion. It returns the key of the upload,
+// ".uploads/<uuidFilename>", which is fetched with FetchObject and removed with RemoveUpload once its job completes.
+// Uploads are not handed off through a LocalStorage, its consumers read the upload at uploadPath, so the key is empty.
+func HandOffUpload(storage Storage, uploadPath string) (string, error) {
+	if _, ok := localRoot(storage); ok {
+		return "", nil
+	}
+
+	key := UploadsDir + "/" + filepath.Base(uploadPath)
+	if err := uploadObject(storage, key, uploadPath); err != nil {
+		return "", err
+	}
+	AddToFileDeleteChan(uploadPath)
+	return key, nil
+}
+
+// RemoveUpload removes the upload handed off at key from the storage, see HandOffUpload.
+// Nothing is removed for an empty key, the upload was not handed off.
+func RemoveUpload(storage Storage, key string) error {
+	if key == "" {
+		return nil
+	}
+	return storage.Delete(key)
+}
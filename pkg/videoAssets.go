@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+)
+
+// CreateVideoAssets writes the poster and the optional preview of a converted video into its media directory,
+// and records them with the placeholder of the poster in the metadata of the video.
+// The loudness normalization of the audio track is recorded too if loudness is not nil.
+// If the video has alternate audio tracks, they are recorded and listed in the master playlist of the video.
+// The name of the watermark profile burned into the video is recorded if watermark is not nil.
+// The media directory of the video must exist, a nil preview writes no preview.
+func CreateVideoAssets(mediaStorage, id, videoPath string, preview *VideoPreviewOptions, loudness *Loudness, audioTracks []VideoAudioTrack, watermark *WatermarkProfile) error {
+	outputDir := MediaDir(mediaStorage, "video", id)
+
+	placeholder, err := CreateVideoPoster(videoPath, outputDir)
+	if err != nil {
+		return fmt.Errorf("error creating video poster: %w", err)
+	}
+
+	metadata := &MediaMetadata{
+		ID:          id,
+		Type:        "video",
+		Placeholder: placeholder,
+		Poster:      PosterFileName,
+		Loudness:    loudness,
+		AudioTracks: audioTracks,
+		Watermark:   watermark.ProfileName(),
+		CreatedAt:   time.Now(),
+	}
+
+	if preview != nil {
+		duration, err := ProbeDuration(videoPath)
+		if err != nil {
+			return fmt.Errorf("error probing video duration: %w", err)
+		}
+		if err := ConvertVideoPreview(videoPath, outputDir, duration, *preview); err != nil {
+			return fmt.Errorf("error creating video preview: %w", err)
+		}
+		metadata.Previews = preview.FileNames()
+	}
+
+	if len(audioTracks) > 0 {
+		metadata.Master = VideoMasterPlaylist
+		if err := WriteVideoMasterPlaylist(outputDir, metadata); err != nil {
+			return err
+		}
+	}
+
+	return WriteMetadata(mediaStorage, metadata)
+}
@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// VideoAudioDir is the directory of the alternate audio renditions in the media directory of a video,
+// every audio track is written to "audio/<stream>/index.m3u8".
+const VideoAudioDir = "audio"
+
+// videoAudioBitrate is the AAC bitrate of the alternate audio renditions of a video.
+const videoAudioBitrate = "128k"
+
+// VideoAudioTrack is an audio stream of a video written as an alternate HLS audio rendition,
+// recorded in the metadata of the video and listed in its master playlist.
+type VideoAudioTrack struct {
+	Stream   int       `json:"stream"`             // Position of the stream among the audio streams of the upload, 0 is the first audio stream
+	Language string    `json:"language,omitempty"` // Language tag of the stream from the source metadata, e.g. "eng", empty if unknown
+	Name     string    `json:"name"`               // Name of the track shown by players, the title of the stream or its language
+	Default  bool      `json:"default,omitempty"`  // Track selected by players without a language preference
+	Loudness *Loudness `json:"loudness,omitempty"` // Target and measured loudness of the track if it is normalized
+}
+
+// Playlist returns the path of the track playlist inside the media directory, e.g. "audio/1/index.m3u8".
+func (t VideoAudioTrack) Playlist() string {
+	return fmt.Sprintf("%s/%d/index.m3u8", VideoAudioDir, t.Stream)
+}
+
+// ProbeVideoAudioTracks reads the audio streams of the video at videoPath with ffprobe and returns the streams
+// selected by the job, with their language and name from the source metadata. The loudness of every track is measured
+// if the job normalizes the loudness, as the tracks of a multilingual video are usually mixed differently.
+//
+// The default track is the first selected stream marked as default in the upload, or the first selected stream.
+// It returns nil without an error if the job requests no audio tracks or if the video has no audio stream,
+// and an error if no audio stream matches the selection of the job.
+func ProbeVideoAudioTracks(videoPath string, selection *topics.AudioTracks, loudness *topics.LoudnessOptions) ([]VideoAudioTrack, error) {
+	if selection == nil {
+		return nil, nil
+	}
+
+	var probe struct {
+		Streams []struct {
+			Tags        map[string]string `json:"tags"`
+			Disposition struct {
+				Default int `json:"default"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := runProbe(&probe,
+		"-select_streams", "a",
+		"-show_entries", "stream=index:stream_tags=language,title:stream_disposition=default",
+		videoPath,
+	); err != nil {
+		return nil, err
+	}
+	if len(probe.Streams) == 0 {
+		return nil, nil
+	}
+
+	tracks := []VideoAudioTrack{}
+	defaultTrack := -1
+	for i, stream := range probe.Streams {
+		language := strings.TrimSpace(stream.Tags["language"])
+		if strings.EqualFold(language, "und") {
+			language = "" // ffmpeg writes "und" for streams without a language
+		}
+
+		// Keep every stream if the job lists neither positions nor languages
+		if len(selection.Streams) > 0 || len(selection.Languages) > 0 {
+			selected := slices.Contains(selection.Streams, i) ||
+				slices.ContainsFunc(selection.Languages, func(l string) bool { return language != "" && strings.EqualFold(l, language) })
+			if !selected {
+				continue
+			}
+		}
+
+		track := VideoAudioTrack{Stream: i, Language: language, Name: strings.TrimSpace(stream.Tags["title"])}
+		if track.Name == "" {
+			track.Name = language
+		}
+		if track.Name == "" {
+			track.Name = "Audio " + strconv.Itoa(i+1)
+		}
+		if stream.Disposition.Default == 1 && defaultTrack < 0 {
+			defaultTrack = len(tracks)
+		}
+		tracks = append(tracks, track)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no audio stream matches the audio tracks of the job: %s", videoPath)
+	}
+	if defaultTrack < 0 {
+		defaultTrack = 0
+	}
+	tracks[defaultTrack].Default = true
+
+	if loudness != nil {
+		target := LoudnessTargetFromMessage(loudness)
+		for i := range tracks {
+			measured, err := measureStreamLoudness(videoPath, tracks[i].Stream, target)
+			if err != nil {
+				return nil, err
+			}
+			tracks[i].Loudness = measured
+		}
+	}
+
+	return tracks, nil
+}
+
+// ConvertVideoAudioTracks converts the audio tracks of a video to audio-only HLS renditions using ffmpeg.
+// It accepts the following parameters:
+//   - videoPath: the path to the input video file.
+//   - outputPath: the media directory of the video, the renditions are saved as "audio/<stream>/index.m3u8".
+//   - tracks: the audio streams to convert, see ProbeVideoAudioTracks.
+//   - hls: the HLS options used for segment duration, segment naming, playlist type and encryption.
+//     The key URI of the key info file must be relative to the rendition playlists.
+//
+// All tracks are encoded in a single run as stereo AAC at 48000 Hz, each normalized with its own loudness
+// measurement. The segments have the same duration as the segments of the video renditions.
+func ConvertVideoAudioTracks(videoPath, outputPath string, tracks []VideoAudioTrack, hls HLSOptions) error {
+	args := []string{"-i", videoPath} // Input video file path
+
+	streamMap := make([]string, len(tracks))
+	for i, track := range tracks {
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", track.Stream)) // One audio stream per rendition
+		if track.Loudness != nil {
+			args = append(args, fmt.Sprintf("-filter:a:%d", i), track.Loudness.filter()) // Normalize the loudness of the track
+		}
+		streamMap[i] = fmt.Sprintf("a:%d,name:%d", i, track.Stream) // The rendition directory is named by the stream position
+	}
+
+	args = append(args,
+		"-c:a", "aac", // AAC is supported by every HLS player
+		"-b:a", videoAudioBitrate, // Bitrate of every rendition
+		"-ar", "48000", // Set the audio sample rate to 48000 Hz, also resamples the loudnorm output
+		"-ac", "2", // Set the number of audio channels to 2 (stereo)
+		"-var_stream_map", strings.Join(streamMap, " "), // Write one playlist per rendition
+	)
+
+	// Add arguments specific to HLS (HTTP Live Streaming) format, "%v" is replaced by the rendition name
+	args = append(args, hls.muxerArgs(outputPath+"/"+VideoAudioDir+"/%v")...)
+
+	return runCommand(exec.Command("ffmpeg", args...))
+}
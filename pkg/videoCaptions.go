@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CaptionsDir is the directory of the caption tracks in the media directory of a video,
+// every language is written to "captions/<language>/index.m3u8".
+const CaptionsDir = "captions"
+
+// defaultMPEGTSStart is the presentation timestamp (90 kHz) of the first frame of the MPEG-TS segments written by ffmpeg,
+// which start at 1.4 seconds. It is used if the first segment can not be probed, e.g. if the video is encrypted.
+const defaultMPEGTSStart = 126000
+
+// CaptionTrack is a caption track of a video, recorded in the metadata of the video
+// and listed in its master playlist.
+type CaptionTrack struct {
+	Language string `json:"language"`          // BCP 47 language tag, e.g. "en" or "pt-BR"
+	Name     string `json:"name"`              // Name of the track shown by players, e.g. "English"
+	Default  bool   `json:"default,omitempty"` // Track selected by players without a language preference
+}
+
+// Playlist returns the path of the track playlist inside the media directory, e.g. "captions/en/index.m3u8".
+func (c CaptionTrack) Playlist() string {
+	return fmt.Sprintf("%s/%s/index.m3u8", CaptionsDir, c.Language)
+}
+
+// AddVideoCaption converts the SRT or WebVTT file at subtitlePath to a segmented WebVTT caption track of the video,
+// records the track in the metadata of the video and rewrites the master playlist of the video.
+//
+// An existing track of the same language is replaced. If the track is the default track,
+// the other tracks of the video are no longer marked as default.
+// Callers serialize the jobs of the video with LockMedia, see AddStoredVideoCaption.
+func AddVideoCaption(mediaStorage, id, subtitlePath string, track CaptionTrack, segmentDuration int) error {
+	videoDir := MediaDir(mediaStorage, "video", id)
+
+	// The caption track covers the duration of the first rendition, all renditions share the same timeline
+	renditions := videoRenditions(videoDir)
+	if len(renditions) == 0 {
+		return fmt.Errorf("no HLS renditions found for video: %s", id)
+	}
+	segments, err := readMediaPlaylist(filepath.Join(videoDir, renditions[0].playlist))
+	if err != nil {
+		return err
+	}
+	duration := 0.0
+	for _, segment := range segments {
+		duration += segment.duration
+	}
+
+	// Synchronise the cues with the timestamps of the video segments
+	mpegts := int64(defaultMPEGTSStart)
+	if len(segments) > 0 {
+		firstSegment := filepath.Join(videoDir, filepath.Dir(renditions[0].playlist), segments[0].uri)
+		if start, err := ProbeStartTime(firstSegment); err == nil {
+			mpegts = int64(math.Round(start * 90000))
+		}
+	}
+
+	data, err := os.ReadFile(subtitlePath)
+	if err != nil {
+		return fmt.Errorf("error reading subtitle file: %w", err)
+	}
+	cues, err := ParseSubtitles(data)
+	if err != nil {
+		return err
+	}
+
+	// Write the track into the staging directory first, so players never see a partially written track,
+	// and an existing track of the language is only replaced once the new track is complete
+	trackDir := filepath.Join(videoDir, CaptionsDir, track.Language)
+	stagedDir := fmt.Sprintf("%s/%s/%s.captions.%s", mediaStorage, StagingDir, id, track.Language)
+	os.RemoveAll(stagedDir)
+	defer os.RemoveAll(stagedDir) // Holds the replaced track once published
+	if err := CreateDir(stagedDir); err != nil {
+		return fmt.Errorf("error creating caption directory: %w", err)
+	}
+	if err := WriteSubtitleSegments(stagedDir, cues, duration, segmentDuration, mpegts); err != nil {
+		return err
+	}
+	if err := CreateDir(filepath.Dir(trackDir)); err != nil {
+		return fmt.Errorf("error creating caption directory: %w", err)
+	}
+	if err := exchangePaths(stagedDir, trackDir); err != nil {
+		return fmt.Errorf("error replacing caption track: %w", err)
+	}
+
+	// Record the track, replacing an existing track of the language. The metadata is read right before it is written,
+	// so the tracks recorded while this track was converted are kept
+	metadata, err := ReadMetadata(mediaStorage, "video", id)
+	if err != nil {
+		return err
+	}
+	captions := make([]CaptionTrack, 0, len(metadata.Captions)+1)
+	for _, caption := range metadata.Captions {
+		if caption.Language == track.Language {
+			continue
+		}
+		if track.Default {
+			caption.Default = false
+		}
+		captions = append(captions, caption)
+	}
+	metadata.Captions = append(captions, track)
+	metadata.Master = VideoMasterPlaylist
+
+	if err := WriteVideoMasterPlaylist(videoDir, metadata); err != nil {
+		return err
+	}
+	return WriteMetadata(mediaStorage, metadata)
+}
+
+// AddStoredVideoCaption adds the caption track to a video published in the storage, see AddVideoCaption.
+// The track is added in place to a LocalStorage. From other storages the playlists and metadata of the video,
+// and the first segment probed for the timestamps of the cues, are downloaded into workStorage,
+// and the track, the master playlist and the metadata are uploaded once the track is written.
+//
+// The caption jobs and the published reprocess jobs of the video are serialized with LockMedia, so concurrent jobs
+// do not drop the tracks recorded by each other. workStorage is a directory in the staging directory of the media storage.
+func AddStoredVideoCaption(storage Storage, workStorage, id, subtitlePath string, track CaptionTrack, segmentDuration int) error {
+	if root, ok := localRoot(storage); ok {
+		unlock, err := LockMedia(root, "video", id)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return AddVideoCaption(root, id, subtitlePath, track, segmentDuration)
+	}
+
+	unlock, err := LockMedia(stagingMediaStorage(workStorage), "video", id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key := MediaKey("video", id)
+	trackKey := key + "/" + CaptionsDir + "/" + track.Language + "/"
+	os.RemoveAll(workStorage)
+	defer os.RemoveAll(workStorage)
+
+	// The segments of the video and its caption tracks are not read, except the first segment
+	err = FetchMedia(storage, workStorage, "video", id, func(object string) bool {
+		return !strings.HasPrefix(object, key+"/"+CaptionsDir+"/") && !slices.Contains(segmentExtensions, path.Ext(object))
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching video: %w", err)
+	}
+	videoDir := MediaDir(workStorage, "video", id)
+	if renditions := videoRenditions(videoDir); len(renditions) > 0 {
+		segments, err := readMediaPlaylist(filepath.Join(videoDir, renditions[0].playlist))
+		if err == nil && len(segments) > 0 {
+			segmentKey := path.Join(key, path.Dir(renditions[0].playlist), segments[0].uri)
+			// The default timestamp is used if the segment can not be fetched, like for encrypted videos
+			FetchObject(storage, segmentKey, filepath.Join(workStorage, filepath.FromSlash(segmentKey)))
+		}
+	}
+
+	// The segments of a replaced track of the language are removed once the new track is uploaded
+	existing, err := storage.List(trackKey)
+	if err != nil {
+		return fmt.Errorf("error listing caption track: %w", err)
+	}
+
+	if err := AddVideoCaption(workStorage, id, subtitlePath, track, segmentDuration); err != nil {
+		return err
+	}
+
+	keys, err := stagedKeys(workStorage, "video", id, "")
+	if err != nil {
+		return err
+	}
+	keys = slices.DeleteFunc(keys, func(object string) bool {
+		return !strings.HasPrefix(object, trackKey) && object != key+"/"+VideoMasterPlaylist && object != key+"/"+MetadataFileName
+	})
+	if err := uploadKeys(storage, workStorage, keys); err != nil {
+		return fmt.Errorf("error publishing caption track: %w", err)
+	}
+
+	removeStaleObjects(storage, existing, keys, nil)
+	return nil
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import "github.com/nvj9singhnavjot/media-docker/topics"
+
+// VideoPreviewOptions holds the settings used by ConvertVideoPreview to write the preview of a video.
+type VideoPreviewOptions struct {
+	Clips        int      // Number of clips taken at evenly spaced points of the video
+	ClipDuration float64  // Duration of each clip in seconds
+	Width        int      // Width of the preview in pixels, the height keeps the aspect ratio
+	Formats      []string // Formats of the preview: "mp4" and/or "webp"
+}
+
+// DefaultVideoPreview is the preview written when a job requests a preview without overriding its fields.
+var DefaultVideoPreview = VideoPreviewOptions{
+	Clips:        4,
+	ClipDuration: 1,
+	Width:        320,
+	Formats:      []string{"mp4"},
+}
+
+// VideoPreviewFromMessage returns the preview options of a video job with every non-nil field applied
+// to DefaultVideoPreview. It returns nil if the job requests no preview.
+func VideoPreviewFromMessage(preview *topics.VideoPreview) *VideoPreviewOptions {
+	if preview == nil {
+		return nil
+	}
+
+	options := DefaultVideoPreview
+	if preview.Clips != nil {
+		options.Clips = *preview.Clips
+	}
+	if preview.ClipDuration != nil {
+		options.ClipDuration = *preview.ClipDuration
+	}
+	if preview.Width != nil {
+		options.Width = *preview.Width
+	}
+	if len(preview.Formats) > 0 {
+		options.Formats = preview.Formats
+	}
+	return &options
+}
+
+// VideoPreviewFileName returns the file name of the preview in the media directory of a video, e.g. "preview.mp4".
+func VideoPreviewFileName(format string) string {
+	return "preview." + format
+}
+
+// FileNames returns the file names of all formats of the preview.
+func (p VideoPreviewOptions) FileNames() []string {
+	names := make([]string, len(p.Formats))
+	for i, format := range p.Formats {
+		names[i] = VideoPreviewFileName(format)
+	}
+	return names
+}
+
+// clips returns the start times of the clips in a video of the duration, and the duration of every clip.
+// Each clip is centered in an equal part of the video. Videos shorter than the whole preview
+// are used from the start as a single clip, as are videos of an unknown (0) duration.
+func (p VideoPreviewOptions) clips(duration float64) ([]float64, float64) {
+	total := float64(p.Clips) * p.ClipDuration
+	if duration <= 0 {
+		return []float64{0}, total
+	}
+	if duration <= total {
+		return []float64{0}, min(duration, total)
+	}
+
+	part := duration / float64(p.Clips)
+	starts := make([]float64, p.Clips)
+	for i := range starts {
+		starts[i] = float64(i)*part + (part-p.ClipDuration)/2
+	}
+	return starts, p.ClipDuration
+}
@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// WatermarkProfile is a named watermark burned into videos and images by the consumers,
+// referenced by name on video and image jobs. It holds a logo, a text, or both.
+type WatermarkProfile struct {
+	Name     string         `json:"-"`        // Name of the profile, set by LoadWatermarkProfiles
+	Image    string         `json:"image"`    // Optional path of the logo image (e.g. a PNG with transparency), read by the consumers
+	Position string         `json:"position"` // Position of the logo: "top-left", "top-right", "bottom-left", "bottom-right" (default) or "center"
+	Opacity  float64        `json:"opacity"`  // Opacity of the logo between 0 (exclusive) and 1 (default)
+	Scale    float64        `json:"scale"`    // Width of the logo relative to the width of the media, default 0.15
+	MarginX  int            `json:"marginX"`  // Horizontal distance in pixels from the edge of the media, default 16
+	MarginY  int            `json:"marginY"`  // Vertical distance in pixels from the edge of the media, default 16
+	Text     *WatermarkText `json:"text"`     // Optional text drawn with its own position
+}
+
+// WatermarkText is the optional text of a watermark profile.
+type WatermarkText struct {
+	Value     string  `json:"value"`     // Text to draw, e.g. "© Partner"
+	Timestamp string  `json:"timestamp"` // Optional timestamp appended to the text: "timecode" (playback time of videos) or "datetime" (processing time, UTC)
+	FontFile  string  `json:"fontFile"`  // Optional path of the font file, the default font of fontconfig is used if empty
+	FontSize  int     `json:"fontSize"`  // Font size in pixels, default 24
+	Color     string  `json:"color"`     // Font color name or hex code, default "white"
+	Opacity   float64 `json:"opacity"`   // Opacity of the text between 0 (exclusive) and 1 (default)
+	Position  string  `json:"position"`  // Position of the text, same values as the logo position, default "bottom-left"
+}
+
+// defaultWatermarkProfile holds the defaults of every field of a watermark profile that is not provided.
+var defaultWatermarkProfile = WatermarkProfile{Position: "bottom-right", Opacity: 1, Scale: 0.15, MarginX: 16, MarginY: 16}
+
+// defaultWatermarkText holds the defaults of every field of a watermark text that is not provided.
+var defaultWatermarkText = WatermarkText{FontSize: 24, Color: "white", Opacity: 1, Position: "bottom-left"}
+
+// watermarkNameRegex matches the names of watermark profiles, which are referenced by jobs.
+var watermarkNameRegex = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// watermarkColorRegex matches ffmpeg color names and hex codes, e.g. "white" or "#ffcc00".
+var watermarkColorRegex = regexp.MustCompile(`^([a-zA-Z]+|#[0-9a-fA-F]{6})$`)
+
+// watermarkPositions maps the positions of a watermark to the ffmpeg x and y expressions,
+// with %[1]s the width and %[2]s the height of the media, %[3]s and %[4]s the width and height of the watermark,
+// and %[5]d and %[6]d the margins.
+var watermarkPositions = map[string][2]string{
+	"top-left":     {"%[5]d", "%[6]d"},
+	"top-right":    {"%[1]s-%[3]s-%[5]d", "%[6]d"},
+	"bottom-left":  {"%[5]d", "%[2]s-%[4]s-%[6]d"},
+	"bottom-right": {"%[1]s-%[3]s-%[5]d", "%[2]s-%[4]s-%[6]d"},
+	"center":       {"(%[1]s-%[3]s)/2", "(%[2]s-%[4]s)/2"},
+}
+
+// LoadWatermarkProfiles reads the watermark profiles from the JSON file at path, an object of profiles by name.
+// Every field of a profile that is not provided keeps its default. The files of the profiles are not checked,
+// see CheckWatermarkFiles, as only the consumers read them.
+func LoadWatermarkProfiles(path string) (map[string]WatermarkProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading watermark profiles: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error decoding watermark profiles: %w", err)
+	}
+
+	profiles := make(map[string]WatermarkProfile, len(raw))
+	for name, value := range raw {
+		if !watermarkNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid watermark profile name %q, must be 1 to 32 lowercase letters, digits, - or _", name)
+		}
+
+		profile := defaultWatermarkProfile
+		profile.Name = name
+		if err := json.Unmarshal(value, &profile); err != nil {
+			return nil, fmt.Errorf("error decoding watermark profile %s: %w", name, err)
+		}
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("invalid watermark profile %s: %w", name, err)
+		}
+		profiles[name] = profile
+	}
+
+	return profiles, nil
+}
+
+// ProfileName returns the name of the profile, or an empty string if w is nil.
+func (w *WatermarkProfile) ProfileName() string {
+	if w == nil {
+		return ""
+	}
+	return w.Name
+}
+
+// UnmarshalJSON decodes the text on top of its defaults, see defaultWatermarkText.
+func (t *WatermarkText) UnmarshalJSON(data []byte) error {
+	type plain WatermarkText // Without the UnmarshalJSON method
+	text := plain(defaultWatermarkText)
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*t = WatermarkText(text)
+	return nil
+}
+
+// validate checks the fields of the profile.
+func (w WatermarkProfile) validate() error {
+	if w.Image == "" && w.Text == nil {
+		return fmt.Errorf("image or text is required")
+	}
+	if _, ok := watermarkPositions[w.Position]; !ok {
+		return fmt.Errorf("invalid position: %s", w.Position)
+	}
+	if w.Opacity <= 0 || w.Opacity > 1 {
+		return fmt.Errorf("opacity must be greater than 0 and at most 1")
+	}
+	if w.Scale <= 0 || w.Scale > 1 {
+		return fmt.Errorf("scale must be greater than 0 and at most 1")
+	}
+	if w.MarginX < 0 || w.MarginY < 0 {
+		return fmt.Errorf("margins must be 0 or more pixels")
+	}
+
+	if text := w.Text; text != nil {
+		if text.Value == "" && text.Timestamp == "" {
+			return fmt.Errorf("text value or timestamp is required")
+		}
+		if text.Timestamp != "" && text.Timestamp != "timecode" && text.Timestamp != "datetime" {
+			return fmt.Errorf("invalid text timestamp, must be timecode or datetime: %s", text.Timestamp)
+		}
+		if text.FontSize < 1 || text.FontSize > 500 {
+			return fmt.Errorf("text font size must be between 1 and 500")
+		}
+		if !watermarkColorRegex.MatchString(text.Color) {
+			return fmt.Errorf("invalid text color: %s", text.Color)
+		}
+		if text.Opacity <= 0 || text.Opacity > 1 {
+			return fmt.Errorf("text opacity must be greater than 0 and at most 1")
+		}
+		if _, ok := watermarkPositions[text.Position]; !ok {
+			return fmt.Errorf("invalid text position: %s", text.Position)
+		}
+	}
+
+	return nil
+}
+
+// CheckWatermarkFiles checks that the logo and font files of every profile can be read.
+func CheckWatermarkFiles(profiles map[string]WatermarkProfile) error {
+	for name, profile := range profiles {
+		files := []string{profile.Image}
+		if profile.Text != nil {
+			files = append(files, profile.Text.FontFile)
+		}
+		for _, file := range files {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				return fmt.Errorf("invalid watermark profile %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// filter returns the ffmpeg filter graph burning the watermark into a stream, or an empty string if w is nil.
+// The graph takes the output of the previous filter of the chain and is continued by the next filter,
+// so it can be placed anywhere in a comma separated filter chain. The label prefixes the intermediate streams,
+// it must be unique within a filter graph.
+//
+// The logo is read with the movie source, scaled relative to the width of the stream and overlaid once,
+// the overlay repeats it on every frame. The overlay keeps the alpha channel of transparent images.
+func (w *WatermarkProfile) filter(label string) string {
+	if w == nil {
+		return ""
+	}
+
+	filters := []string{}
+	if w.Image != "" {
+		x, y := watermarkPosition(w.Position, "main_w", "main_h", "overlay_w", "overlay_h", w.MarginX, w.MarginY)
+		filters = append(filters, fmt.Sprintf(
+			"null[%[1]sbase];movie=filename=%[2]s,format=rgba,colorchannelmixer=aa=%[3]g[%[1]slogo];"+
+				"[%[1]slogo][%[1]sbase]scale2ref=w=main_w*%[4]g:h=ow/a[%[1]smark][%[1]smain];"+
+				"[%[1]smain][%[1]smark]overlay=x=%[5]s:y=%[6]s:format=auto",
+			label, escapeFilterValue(w.Image), w.Opacity, w.Scale, x, y,
+		))
+	}
+
+	if text := w.Text; text != nil {
+		value := escapeDrawtext(text.Value)
+		switch text.Timestamp {
+		case "timecode":
+			value = strings.TrimSpace(value + " %{pts:hms}") // Playback time of the frame, e.g. "0:01:02.500"
+		case "datetime":
+			value = strings.TrimSpace(value + " " + escapeDrawtext(time.Now().UTC().Format("2006-01-02 15:04 UTC")))
+		}
+
+		x, y := watermarkPosition(text.Position, "w", "h", "text_w", "text_h", w.MarginX, w.MarginY)
+		drawtext := fmt.Sprintf("drawtext=text=%s:fontsize=%d:fontcolor=%s@%g:x=%s:y=%s",
+			escapeFilterValue(value), text.FontSize, text.Color, text.Opacity, x, y)
+		if text.FontFile != "" {
+			drawtext += ":fontfile=" + escapeFilterValue(text.FontFile)
+		}
+		filters = append(filters, drawtext)
+	}
+
+	return strings.Join(filters, ",")
+}
+
+// watermarkPosition returns the ffmpeg x and y expressions of a position, see watermarkPositions.
+func watermarkPosition(position, width, height, markWidth, markHeight string, marginX, marginY int) (string, string) {
+	expressions := watermarkPositions[position]
+	return fmt.Sprintf(expressions[0], width, height, markWidth, markHeight, marginX, marginY),
+		fmt.Sprintf(expressions[1], width, height, markWidth, markHeight, marginX, marginY)
+}
+
+// escapeDrawtext escapes the text expansion of drawtext, so "%" and "\" are drawn literally.
+func escapeDrawtext(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`).Replace(text)
+}
+
+// filterValueEscaper escapes the special characters of a filter option value.
+var filterValueEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`, `,`, `\,`, `;`, `\;`, `[`, `\[`, `]`, `\]`)
+
+// escapeFilterValue escapes a filter option value (e.g. a path or a text) for a filter graph.
+// The value is parsed twice by ffmpeg, once by the filter graph and once by the options of the filter,
+// so it is escaped twice.
+func escapeFilterValue(value string) string {
+	return filterValueEscaper.Replace(filterValueEscaper.Replace(value))
+}
+
+// WatermarkFromMessage returns the watermark profile named by a job, or nil if the job has no watermark.
+// It returns an error if the profile is not configured, e.g. if the profiles of the server and consumers differ.
+func WatermarkFromMessage(profiles map[string]WatermarkProfile, name *string) (*WatermarkProfile, error) {
+	if name == nil {
+		return nil, nil
+	}
+	profile, ok := profiles[*name]
+	if !ok {
+		return nil, fmt.Errorf("unknown watermark profile: %s", *name)
+	}
+	return &profile, nil
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/nvj9singhnavjot/media-docker/topics"
+)
+
+// WaveformFileName is the name of the waveform peaks in the media directory of an audio.
+const WaveformFileName = "waveform.json"
+
+// waveformSampleRate is the sample rate the audio is decoded at, the same rate ConvertAudio writes.
+const waveformSampleRate = 44100
+
+// WaveformOptions holds the resolution of the waveform peaks written by CreateWaveform.
+type WaveformOptions struct {
+	SamplesPerPixel int // Number of audio samples summarized by each peak
+	Bits            int // Resolution of the peaks, 8 (-128 to 127) or 16 (-32768 to 32767)
+}
+
+// DefaultWaveform is the waveform resolution used when a job provides no overrides,
+// about 86 peaks per second of audio.
+var DefaultWaveform = WaveformOptions{
+	SamplesPerPixel: 512,
+	Bits:            8,
+}
+
+// WaveformFromMessage returns the waveform options of an audio job with every non-nil field applied
+// to DefaultWaveform.
+func WaveformFromMessage(waveform *topics.AudioWaveform) WaveformOptions {
+	options := DefaultWaveform
+	if waveform == nil {
+		return options
+	}
+	if waveform.SamplesPerPixel != nil {
+		options.SamplesPerPixel = *waveform.SamplesPerPixel
+	}
+	if waveform.Bits != nil {
+		options.Bits = *waveform.Bits
+	}
+	return options
+}
+
+// waveformData is the audiowaveform JSON format (version 2), read by waveform renderers like peaks.js.
+// Data holds a minimum and a maximum value for every peak.
+type waveformData struct {
+	Version         int   `json:"version"`
+	Channels        int   `json:"channels"`
+	SampleRate      int   `json:"sample_rate"`
+	SamplesPerPixel int   `json:"samples_per_pixel"`
+	Bits            int   `json:"bits"`
+	Length          int   `json:"length"`
+	Data            []int `json:"data"`
+}
+
+// CreateWaveform writes the waveform peaks of the audio at audioPath to outputPath in the audiowaveform JSON format.
+//
+// The audio is decoded by ffmpeg as mono 16 bit samples and streamed, so long podcasts are never held in memory,
+// only their peaks are.
+func CreateWaveform(audioPath, outputPath string, options WaveformOptions) error {
+	cmd := exec.Command("ffmpeg",
+		"-v", "error",
+		"-i", audioPath, // Input audio file
+		"-vn",      // Ignore cover art and video streams
+		"-ac", "1", // Mix all channels down to mono
+		"-ar", strconv.Itoa(waveformSampleRate), // Resample to the sample rate of the converted audio
+		"-f", "s16le", "pipe:1", // Write signed 16 bit little endian samples to stdout
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("command: %s, %s", cmd.String(), err)
+	}
+
+	waveform := waveformData{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      waveformSampleRate,
+		SamplesPerPixel: options.SamplesPerPixel,
+		Bits:            options.Bits,
+		Data:            []int{},
+	}
+
+	// Reduce every block of SamplesPerPixel samples to its minimum and maximum
+	shift := 16 - options.Bits
+	reader := bufio.NewReaderSize(stdout, 64*1024)
+	sample := make([]byte, 2)
+	count, low, high := 0, 0, 0
+	for {
+		if _, err = io.ReadFull(reader, sample); err != nil {
+			break
+		}
+
+		value := int(int16(binary.LittleEndian.Uint16(sample)))
+		if count == 0 || value < low {
+			low = value
+		}
+		if count == 0 || value > high {
+			high = value
+		}
+
+		count++
+		if count == options.SamplesPerPixel {
+			waveform.Data = append(waveform.Data, low>>shift, high>>shift)
+			count = 0
+		}
+	}
+	if count > 0 {
+		waveform.Data = append(waveform.Data, low>>shift, high>>shift) // Last partial block
+	}
+
+	// An odd trailing byte is dropped, any other read error stops ffmpeg, which would block on a full pipe
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("error reading audio samples: %w", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("command: %s, %s", cmd.String(), err)
+	}
+
+	waveform.Length = len(waveform.Data) / 2
+
+	data, err := json.Marshal(waveform)
+	if err != nil {
+		return fmt.Errorf("error encoding waveform: %w", err)
+	}
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing waveform: %w", err)
+	}
+	return nil
+}
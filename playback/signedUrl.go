@@ -0,0 +1,105 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignOptions holds the optional bindings of a signed URL.
+type SignOptions struct {
+	IP     string // Optional client IP, the URL is only valid for requests from this IP
+	Prefix string // Optional path prefix, the signature is valid for every path starting with this prefix
+}
+
+// SignURL signs the URL path and returns the query parameters that must be added to the URL.
+//
+// The returned query contains "exp" (unix expiry), "sig" (signature) and, if set in opts,
+// "ip" and "prefix". When a prefix is provided, the signature covers the prefix instead of
+// the path, so the same query can be used for every file below the prefix. Path and prefix
+// signatures are signed with different kinds, so the signature of a path is never valid as a prefix.
+func SignURL(secret, path string, expiresAt time.Time, opts SignOptions) url.Values {
+	query := url.Values{}
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	query.Set("exp", expiry)
+
+	kind, signedPath := "url", path
+	if opts.Prefix != "" {
+		kind, signedPath = "prefix", opts.Prefix
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.IP != "" {
+		query.Set("ip", opts.IP)
+	}
+
+	query.Set("sig", sign(secret, kind, signedPath, expiry, opts.IP))
+	return query
+}
+
+// VerifyURL checks the signature query of a request for the URL path and client IP.
+// On success it returns the expiry and options of the signature, so that URLs derived
+// from this URL (e.g. segments of a playlist) can be signed with the same bindings.
+func VerifyURL(secret, path string, query url.Values, clientIP string) (time.Time, SignOptions, error) {
+	expiry := query.Get("exp")
+	signature := query.Get("sig")
+	if expiry == "" || signature == "" {
+		return time.Time{}, SignOptions{}, fmt.Errorf("missing signature")
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return time.Time{}, SignOptions{}, fmt.Errorf("invalid signature expiry: %w", err)
+	}
+
+	opts := SignOptions{IP: query.Get("ip"), Prefix: query.Get("prefix")}
+
+	// A prefix signature is only valid for paths below the prefix, a ".." segment would leave the prefix
+	// once the path is cleaned by the file server
+	kind, signedPath := "url", path
+	if opts.Prefix != "" {
+		if !strings.HasPrefix(path, opts.Prefix) || slices.Contains(strings.Split(path, "/"), "..") {
+			return time.Time{}, SignOptions{}, fmt.Errorf("path is outside of the signed prefix")
+		}
+		kind, signedPath = "prefix", opts.Prefix
+	}
+
+	if !hmacEqual(signature, sign(secret, kind, signedPath, expiry, opts.IP)) {
+		return time.Time{}, SignOptions{}, fmt.Errorf("invalid signature")
+	}
+
+	if opts.IP != "" && opts.IP != clientIP {
+		return time.Time{}, SignOptions{}, fmt.Errorf("signature is bound to a different ip")
+	}
+
+	if time.Now().Unix() > expiresAt {
+		return time.Time{}, SignOptions{}, fmt.Errorf("signature expired")
+	}
+
+	return time.Unix(expiresAt, 0), opts, nil
+}
+
+// signedContextKey is the context key marking a request whose URL signature was verified.
+type signedContextKey struct{}
+
+// WithSignedRequest returns a copy of ctx marking the request as verified by a URL signature,
+// which is valid until expiresAt.
+func WithSignedRequest(ctx context.Context, expiresAt time.Time) context.Context {
+	return context.WithValue(ctx, signedContextKey{}, expiresAt)
+}
+
+// IsSignedRequest reports whether the request of ctx was verified by its URL signature.
+func IsSignedRequest(ctx context.Context) bool {
+	_, signed := SignedRequestExpiry(ctx)
+	return signed
+}
+
+// SignedRequestExpiry returns the expiry of the verified URL signature of the request of ctx.
+// The boolean is false if the request was not verified by a URL signature.
+func SignedRequestExpiry(ctx context.Context) (time.Time, bool) {
+	expiresAt, signed := ctx.Value(signedContextKey{}).(time.Time)
+	return expiresAt, signed
+}
@@ -0,0 +1,43 @@
+package playback
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyURLPrefix(t *testing.T) {
+	secret := "secret"
+	query := SignURL(secret, "/videos/A/index.m3u8", time.Now().Add(time.Hour), SignOptions{Prefix: "/videos/A/"})
+
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"/videos/A/index.m3u8", true},
+		{"/videos/A/720p/segment_1.ts", true},
+		{"/videos/B/index.m3u8", false},
+		{"/videos/A/../B/index.m3u8", false},
+		{"/videos/A/720p/../../B/index.m3u8", false},
+		{"/videos/A/..", false},
+	}
+	for _, test := range tests {
+		_, _, err := VerifyURL(secret, test.path, query, "")
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("VerifyURL(%q) valid = %v, want %v (err: %v)", test.path, valid, test.valid, err)
+		}
+	}
+}
+
+func TestVerifyURLPathSignatureAsPrefix(t *testing.T) {
+	secret := "secret"
+	query := SignURL(secret, "/videos/A", time.Now().Add(time.Hour), SignOptions{})
+	if _, _, err := VerifyURL(secret, "/videos/A", query, ""); err != nil {
+		t.Fatalf("VerifyURL() of the signed path error = %v", err)
+	}
+
+	// The signature of a path must not open every path starting with it
+	query.Set("prefix", "/videos/A")
+	if _, _, err := VerifyURL(secret, "/videos/AB/index.m3u8", query, ""); err == nil {
+		t.Errorf("VerifyURL() accepted the signature of a path as a prefix signature")
+	}
+}
@@ -0,0 +1,61 @@
+// Package playback provides HMAC based playback tokens and signed URLs used in Media Docker.
+// Tokens and signed URLs are issued by media-docker-server and verified by media-docker-client,
+// both services must be configured with the same PLAYBACK_SECRET.
+package playback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sign returns the hex encoded HMAC-SHA256 of the given parts joined by ":".
+func sign(secret string, parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hmacEqual compares two hex encoded signatures in constant time.
+func hmacEqual(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+// GenerateToken creates a playback token for the media id, which is valid until expiresAt.
+//
+// The token has the format "<unix expiry>.<hex signature>", the media id is not part
+// of the token itself but is covered by the signature, so a token only works for the
+// media id it was issued for.
+func GenerateToken(secret, id string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + sign(secret, "token", id, expiry)
+}
+
+// ValidateToken checks that the token was issued for the media id with the secret and has not expired.
+// It returns an error describing why the token is invalid, or nil if the token is valid.
+func ValidateToken(secret, id, token string) error {
+	expiry, signature, found := strings.Cut(token, ".")
+	if !found {
+		return fmt.Errorf("invalid token format")
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid token expiry: %w", err)
+	}
+
+	// Compare signatures in constant time before checking the expiry.
+	if !hmacEqual(signature, sign(secret, "token", id, expiry)) {
+		return fmt.Errorf("invalid token signature")
+	}
+
+	if time.Now().Unix() > expiresAt {
+		return fmt.Errorf("token expired")
+	}
+
+	return nil
+}
@@ -17,58 +17,245 @@ import "time"
 //
 // Topic: "failed-letter-queue"
 type DLQMessage struct {
-	NewId          *string   `json:"newId" validate:"omitempty,uuid4"`                                            // Optional NewId from other topic Kafka message
-	OriginalTopic  string    `json:"originalTopic" validate:"required,oneof=image video video-resolutions audio"` // The topic where the message originated
-	Partition      int       `json:"partition" validate:"customNonNegativeInt"`                                   // Kafka partition of the original message
-	Offset         int64     `json:"offset" validate:"customNonNegativeInt"`                                      // Offset position of the original message in the partition
-	HighWaterMark  int64     `json:"highWaterMark" validate:"customNonNegativeInt"`                               // The high-water mark of the partition (latest offset)
-	Value          string    `json:"value" validate:"required"`                                                   // The original message content as a string
-	ErrorDetails   string    `json:"errorDetails" validate:"required"`                                            // Description of the error encountered during processing
-	ProcessingTime time.Time `json:"processingTime" validate:"required"`                                          // Timestamp of when the message was processed
-	ErrorTime      time.Time `json:"errorTime" validate:"required"`                                               // Timestamp of when the error occurred
-	Worker         string    `json:"worker" validate:"required"`                                                  // Identifier of the worker that processed the message
-	CustomMessage  string    `json:"customMessage" validate:"required"`                                           // Additional custom message or context about the error
+	NewId          *string   `json:"newId" validate:"omitempty,uuid4"`                                                                // Optional NewId from other topic Kafka message
+	OriginalTopic  string    `json:"originalTopic" validate:"required,oneof=image video video-resolutions audio caption clip concat"` // The topic where the message originated
+	Partition      int       `json:"partition" validate:"customNonNegativeInt"`                                                       // Kafka partition of the original message
+	Offset         int64     `json:"offset" validate:"customNonNegativeInt"`                                                          // Offset position of the original message in the partition
+	HighWaterMark  int64     `json:"highWaterMark" validate:"customNonNegativeInt"`                                                   // The high-water mark of the partition (latest offset)
+	Value          string    `json:"value" validate:"required"`                                                                       // The original message content as a string
+	ErrorDetails   string    `json:"errorDetails" validate:"required"`                                                                // Description of the error encountered during processing
+	ProcessingTime time.Time `json:"processingTime" validate:"required"`                                                              // Timestamp of when the message was processed
+	ErrorTime      time.Time `json:"errorTime" validate:"required"`                                                                   // Timestamp of when the error occurred
+	Worker         string    `json:"worker" validate:"required"`                                                                      // Identifier of the worker that processed the message
+	CustomMessage  string    `json:"customMessage" validate:"required"`                                                               // Additional custom message or context about the error
 }
 
 // KafkaResponseMessage represents a message from the Media Docker system.
 //
 // Topic: "media-docker-files-response"
 type KafkaResponseMessage struct {
-	ID       string `json:"id" validate:"required,uuid4"`                                          // Unique identifier (UUIDv4) for the media file, required field
-	FileType string `json:"fileType" validate:"required,oneof=image video videoResolutions audio"` // Media file type, required and must be one of "image", "video", "videoResolutions", or "audio"
-	Status   string `json:"status" validate:"required,oneof=completed failed"`                     // Status of the media processing, required and must be either "completed" or "failed"
+	ID          string       `json:"id" validate:"required,uuid4"`                                                              // Unique identifier (UUIDv4) for the media file, required field
+	FileType    string       `json:"fileType" validate:"required,oneof=image video videoResolutions audio caption clip concat"` // Media file type, required and must be one of "image", "video", "videoResolutions", "audio", "caption", "clip" or "concat"
+	Status      string       `json:"status" validate:"required,oneof=completed failed"`                                         // Status of the media processing, required and must be either "completed" or "failed"
+	Placeholder *Placeholder `json:"placeholder,omitempty" validate:"omitempty"`                                                // Placeholder of the image, video poster or audio cover art, only set for completed files
+}
+
+// Placeholder holds the low quality placeholder of an image or video poster, shown while the media file loads.
+type Placeholder struct {
+	BlurHash      string `json:"blurHash" validate:"required"`               // BlurHash of the image, see https://blurha.sh
+	DominantColor string `json:"dominantColor" validate:"required,hexcolor"` // Dominant colour of the image, e.g. "#1e90ff"
 }
 
 // AudioMessage represents the structure of the message sent to Kafka for audio processing.
 //
 // Topic: "audio"
 type AudioMessage struct {
-	FilePath string  `json:"filePath" validate:"required"` // Mandatory field for the file path
-	NewId    string  `json:"newId" validate:"required"`    // New unique identifier for the audio file URL
-	Bitrate  *string `json:"bitrate" validate:"omitempty"` // Optional quality parameter
+	FilePath          string           `json:"filePath" validate:"required"`                   // Mandatory field for the file path
+	Upload            string           `json:"upload" validate:"omitempty"`                    // Key of the upload handed off through an object storage, empty if the upload is read at the file path
+	NewId             string           `json:"newId" validate:"required"`                      // New unique identifier for the audio file URL
+	Bitrate           *string          `json:"bitrate" validate:"omitempty"`                   // Optional quality parameter
+	Waveform          *AudioWaveform   `json:"waveform" validate:"omitempty"`                  // Optional resolution of the waveform peaks, written to "audios/<id>/waveform.json"
+	Outputs           []AudioOutput    `json:"outputs" validate:"omitempty,max=8,unique,dive"` // Optional additional outputs, written to "audios/<id>/<bitrate>.<ext>"
+	HLS               *AudioHLS        `json:"hls" validate:"omitempty"`                       // Optional HLS audio renditions, written to "audios/<id>/hls/master.m3u8"
+	NormalizeLoudness *LoudnessOptions `json:"normalizeLoudness" validate:"omitempty"`         // Optional two-pass loudness normalization of every output
+	Tags              *AudioTags       `json:"tags" validate:"omitempty"`                      // Optional tags replacing the tags of the upload
+	Reprocess         *Reprocess       `json:"reprocess" validate:"omitempty"`                 // Set if the job reprocesses the existing audio NewId from its archived original
+}
+
+// AudioTags represents optional overrides of the tags read from an audio upload and written into every output.
+// Any field left nil keeps the tag of the upload, an empty string removes the tag.
+//
+// Used in: AudioMessage
+type AudioTags struct {
+	Title       *string `json:"title" validate:"omitempty,max=256"`
+	Artist      *string `json:"artist" validate:"omitempty,max=256"`
+	Album       *string `json:"album" validate:"omitempty,max=256"`
+	AlbumArtist *string `json:"albumArtist" validate:"omitempty,max=256"`
+	Genre       *string `json:"genre" validate:"omitempty,max=64"`
+	Date        *string `json:"date" validate:"omitempty,max=32"`  // Release date or year, e.g. "2024"
+	Track       *string `json:"track" validate:"omitempty,max=16"` // Track number, optionally with the total, e.g. "3/12"
+	Disc        *string `json:"disc" validate:"omitempty,max=16"`  // Disc number, optionally with the total, e.g. "1/2"
+	Composer    *string `json:"composer" validate:"omitempty,max=256"`
+	Comment     *string `json:"comment" validate:"omitempty,max=1024"`
+}
+
+// AudioOutput represents an additional output format and bitrate of an audio job.
+//
+// Used in: AudioMessage
+type AudioOutput struct {
+	Format  string `json:"format" validate:"required,oneof=mp3 aac opus"`                              // Output format, written as .mp3, .m4a or .opus
+	Bitrate string `json:"bitrate" validate:"required,oneof=32k 48k 64k 96k 128k 160k 192k 256k 320k"` // Output bitrate
+}
+
+// AudioHLS represents the audio-only HLS renditions of an audio job, listed in a master playlist.
+//
+// Used in: AudioMessage
+type AudioHLS struct {
+	Bitrates        []string `json:"bitrates" validate:"required,min=1,max=4,unique,dive,oneof=32k 48k 64k 96k 128k 160k 192k 256k 320k"` // AAC bitrates of the renditions
+	SegmentDuration *int     `json:"segmentDuration" validate:"omitempty,min=1,max=60"`                                                   // Optional segment duration in seconds, default HLS_SEGMENT_DURATION
+}
+
+// AudioWaveform represents optional overrides of the waveform peaks written for every audio job.
+// Any field left nil falls back to the default waveform of the consumer.
+//
+// Used in: AudioMessage
+type AudioWaveform struct {
+	SamplesPerPixel *int `json:"samplesPerPixel" validate:"omitempty,min=32,max=65536"` // Optional audio samples per peak, default 512
+	Bits            *int `json:"bits" validate:"omitempty,oneof=8 16"`                  // Optional resolution of the peaks in bits, default 8
+}
+
+// LoudnessOptions represents the opt-in EBU R128 loudness normalization of an audio or video job.
+// The loudness is measured by a first loudnorm pass and corrected by a second one while encoding.
+// Any field left nil falls back to the default target of the consumer.
+//
+// Used in: AudioMessage, VideoMessage, VideoResolutionsMessage
+type LoudnessOptions struct {
+	TargetLUFS *float64 `json:"targetLufs" validate:"omitempty,min=-70,max=-5"` // Optional integrated loudness target in LUFS, default -16
+	TruePeak   *float64 `json:"truePeak" validate:"omitempty,min=-9,max=0"`     // Optional maximum true peak in dBTP, default -1.5
+	LRA        *float64 `json:"lra" validate:"omitempty,min=1,max=50"`          // Optional loudness range target in LU, default 11
+}
+
+// AudioTracks selects the audio streams of a video which are written as alternate HLS audio renditions.
+// A stream is kept if its position or its language is listed, an empty object keeps every audio stream of the upload.
+//
+// Used in: VideoMessage, VideoResolutionsMessage
+type AudioTracks struct {
+	Streams   []int    `json:"streams" validate:"omitempty,max=16,unique,dive,min=0,max=63"`      // Optional positions of the audio streams, 0 is the first audio stream of the upload
+	Languages []string `json:"languages" validate:"omitempty,max=16,unique,dive,required,max=35"` // Optional language tags of the audio streams, e.g. "eng" or "fr"
 }
 
 // ImageMessage represents the structure of the message sent to Kafka for image processing.
 //
 // Topic: "image"
 type ImageMessage struct {
-	FilePath string `json:"filePath" validate:"required"` // Mandatory field for the file path
-	NewId    string `json:"newId" validate:"required"`    // New unique identifier for the image file URL
+	FilePath      string         `json:"filePath" validate:"required"`                             // Mandatory field for the file path
+	Upload        string         `json:"upload" validate:"omitempty"`                              // Key of the upload handed off through an object storage, empty if the upload is read at the file path
+	NewId         string         `json:"newId" validate:"required"`                                // New unique identifier for the image file URL
+	Format        string         `json:"format" validate:"omitempty,oneof=jpeg png webp avif gif"` // Output image format, empty for messages without a format (jpeg)
+	Quality       *int           `json:"quality" validate:"omitempty,min=1,max=100"`               // Optional encoder quality, 1 (lowest) to 100 (highest)
+	MaxWidth      *int           `json:"maxWidth" validate:"omitempty,min=1,max=8192"`             // Optional maximum width in pixels
+	MaxHeight     *int           `json:"maxHeight" validate:"omitempty,min=1,max=8192"`            // Optional maximum height in pixels
+	Fit           *string        `json:"fit" validate:"omitempty,oneof=contain cover fill"`        // Optional fit into maxWidth x maxHeight, default contain
+	Variants      *ImageVariants `json:"variants" validate:"omitempty"`                            // Optional responsive variants, written to "images/<id>/<width>.<ext>"
+	KeepCopyright bool           `json:"keepCopyright" validate:"omitempty"`                       // Record the Copyright and Artist fields of the source in the metadata, the output is stripped of all metadata
+	Fallback      bool           `json:"fallback" validate:"omitempty"`                            // Write a GIF fallback of the image to "images/<id>/fallback.gif" if the WebP output is animated
+	Watermark     *string        `json:"watermark" validate:"omitempty,max=32"`                    // Optional name of the watermark profile burned into the image, its fallback and variants
+	Reprocess     *Reprocess     `json:"reprocess" validate:"omitempty"`                           // Set if the job reprocesses the existing image NewId from its archived original
+}
+
+// ImageVariants represents the responsive variants of an image job, every width is written in every format.
+//
+// Used in: ImageMessage
+type ImageVariants struct {
+	Widths  []int    `json:"widths" validate:"required,min=1,max=10,unique,dive,min=16,max=8192"`              // Widths of the variants in pixels
+	Formats []string `json:"formats" validate:"required,min=1,max=5,unique,dive,oneof=jpeg png webp avif gif"` // Formats of the variants
+}
+
+// HLSOptions represents optional per-job overrides for HLS segmenting.
+// Any field left nil falls back to the global HLS configuration of the consumer.
+//
+// Used in: VideoMessage, VideoResolutionsMessage
+type HLSOptions struct {
+	SegmentDuration     *int    `json:"segmentDuration" validate:"omitempty,min=1,max=60"`  // Optional segment duration in seconds
+	SegmentNameWidth    *int    `json:"segmentNameWidth" validate:"omitempty,min=0,max=12"` // Optional zero-padded width of segment numbers, 0 disables padding
+	PlaylistType        *string `json:"playlistType" validate:"omitempty,oneof=vod event"`  // Optional playlist type, "vod" or "event"
+	ForceKeyframes      *bool   `json:"forceKeyframes" validate:"omitempty"`                // Optional keyframe alignment with segment boundaries
+	IndependentSegments *bool   `json:"independentSegments" validate:"omitempty"`           // Optional #EXT-X-INDEPENDENT-SEGMENTS flag
 }
 
 // VideoMessage represents the structure of the message sent to Kafka for video processing.
 //
 // Topic: "video"
 type VideoMessage struct {
-	FilePath string `json:"filePath" validate:"required"` // Mandatory field for the file path
-	NewId    string `json:"newId" validate:"required"`    // New unique identifier for the video file URL
-	Quality  *int   `json:"quality" validate:"omitempty"` // Optional video quality (using pointer for omitempty)
+	FilePath          string           `json:"filePath" validate:"required"`                  // Mandatory field for the file path
+	Upload            string           `json:"upload" validate:"omitempty"`                   // Key of the upload handed off through an object storage, empty if the upload is read at the file path
+	NewId             string           `json:"newId" validate:"required"`                     // New unique identifier for the video file URL
+	Quality           *int             `json:"quality" validate:"omitempty"`                  // Optional video quality (using pointer for omitempty)
+	HLS               *HLSOptions      `json:"hls" validate:"omitempty"`                      // Optional HLS overrides for this job
+	Encryption        *string          `json:"encryption" validate:"omitempty,oneof=AES-128"` // Optional HLS segment encryption method
+	Preview           *VideoPreview    `json:"preview" validate:"omitempty"`                  // Optional preview clip, written to "videos/<id>/preview.<ext>"
+	NormalizeLoudness *LoudnessOptions `json:"normalizeLoudness" validate:"omitempty"`        // Optional two-pass loudness normalization of the audio track
+	AudioTracks       *AudioTracks     `json:"audioTracks" validate:"omitempty"`              // Optional alternate HLS audio renditions, listed in "videos/<id>/master.m3u8"
+	Watermark         *string          `json:"watermark" validate:"omitempty,max=32"`         // Optional name of the watermark profile burned into the video
+	Reprocess         *Reprocess       `json:"reprocess" validate:"omitempty"`                // Set if the job reprocesses the existing video NewId from its archived original
 }
 
 // VideoResolutionsMessage represents the structure of the message sent to Kafka for video resolution processing.
 //
 // Topic: "video-resolutions"
 type VideoResolutionsMessage struct {
-	FilePath string `json:"filePath" validate:"required"` // Mandatory field for the file path
-	NewId    string `json:"newId" validate:"required"`    // New unique identifier for the video file URL
+	FilePath          string           `json:"filePath" validate:"required"`                  // Mandatory field for the file path
+	Upload            string           `json:"upload" validate:"omitempty"`                   // Key of the upload handed off through an object storage, empty if the upload is read at the file path
+	NewId             string           `json:"newId" validate:"required"`                     // New unique identifier for the video file URL
+	HLS               *HLSOptions      `json:"hls" validate:"omitempty"`                      // Optional HLS overrides for this job
+	Encryption        *string          `json:"encryption" validate:"omitempty,oneof=AES-128"` // Optional HLS segment encryption method
+	Preview           *VideoPreview    `json:"preview" validate:"omitempty"`                  // Optional preview clip, written to "videos/<id>/preview.<ext>"
+	NormalizeLoudness *LoudnessOptions `json:"normalizeLoudness" validate:"omitempty"`        // Optional two-pass loudness normalization of the audio track
+	AudioTracks       *AudioTracks     `json:"audioTracks" validate:"omitempty"`              // Optional alternate HLS audio renditions, listed in "videos/<id>/master.m3u8"
+	Watermark         *string          `json:"watermark" validate:"omitempty,max=32"`         // Optional name of the watermark profile burned into the video
+	Reprocess         *Reprocess       `json:"reprocess" validate:"omitempty"`                // Set if the job reprocesses the existing video NewId from its archived original
+}
+
+// Reprocess represents a job processing an existing media file again from its archived original,
+// whose outputs replace the served version of the media file once the job completes.
+//
+// Used in: VideoMessage, VideoResolutionsMessage, ImageMessage, AudioMessage
+type Reprocess struct {
+	JobId       string    `json:"jobId" validate:"required,uuid4"` // Unique identifier of the job, naming the directory the job writes into
+	RequestedAt time.Time `json:"requestedAt" validate:"required"` // Time of the request, a job requested before the served version is not published
+}
+
+// CaptionMessage represents the structure of the message sent to Kafka for adding a caption track to a video.
+//
+// Topic: "caption"
+type CaptionMessage struct {
+	FilePath string `json:"filePath" validate:"required"`                    // Mandatory field for the file path of the SRT or WebVTT file
+	Upload   string `json:"upload" validate:"omitempty"`                     // Key of the subtitle file handed off through an object storage, empty if it is read at the file path
+	NewId    string `json:"newId" validate:"required,uuid4"`                 // Id of the existing video the caption track is added to
+	Language string `json:"language" validate:"required,bcp47_language_tag"` // BCP 47 language tag of the track, e.g. "en" or "pt-BR"
+	Name     string `json:"name" validate:"required,max=64"`                 // Name of the track shown by players, e.g. "English"
+	Default  bool   `json:"default" validate:"omitempty"`                    // Select the track by default, unsetting the current default track
+}
+
+// EditPart represents a time range of an existing video or audio, cut by a clip or concat job.
+//
+// Used in: ClipMessage, ConcatMessage
+type EditPart struct {
+	Id    string   `json:"id" validate:"required,uuid4"`           // Id of the existing media file
+	Start float64  `json:"start" validate:"min=0"`                 // Start of the range in seconds, default 0
+	End   *float64 `json:"end" validate:"omitempty,gtfield=Start"` // Optional end of the range in seconds, default the end of the media file
+}
+
+// ClipMessage represents the structure of the message sent to Kafka for cutting a range of an existing media file
+// into a new media file.
+//
+// Topic: "clip"
+type ClipMessage struct {
+	NewId     string      `json:"newId" validate:"required,uuid4"`                 // New unique identifier for the clipped media file
+	MediaType string      `json:"mediaType" validate:"required,oneof=video audio"` // Type of the existing media file
+	Part      EditPart    `json:"part" validate:"required"`                        // Range of the existing media file
+	HLS       *HLSOptions `json:"hls" validate:"omitempty"`                        // Optional HLS overrides for re-encoded ranges of a video
+}
+
+// ConcatMessage represents the structure of the message sent to Kafka for joining ranges of existing media files
+// into a new media file, in the order of the parts.
+//
+// Topic: "concat"
+type ConcatMessage struct {
+	NewId     string      `json:"newId" validate:"required,uuid4"`                 // New unique identifier for the joined media file
+	MediaType string      `json:"mediaType" validate:"required,oneof=video audio"` // Type of the existing media files
+	Parts     []EditPart  `json:"parts" validate:"required,min=2,max=20,dive"`     // Ranges of the existing media files
+	HLS       *HLSOptions `json:"hls" validate:"omitempty"`                        // Optional HLS overrides for re-encoded ranges of a video
+}
+
+// VideoPreview represents an optional short, muted and low resolution preview of a video job,
+// stitched from clips taken at evenly spaced points of the video.
+// Any field left nil falls back to the default preview of the consumer.
+//
+// Used in: VideoMessage, VideoResolutionsMessage
+type VideoPreview struct {
+	Clips        *int     `json:"clips" validate:"omitempty,min=1,max=10"`                       // Optional number of clips, default 4
+	ClipDuration *float64 `json:"clipDuration" validate:"omitempty,min=0.5,max=5"`               // Optional duration of each clip in seconds, default 1
+	Width        *int     `json:"width" validate:"omitempty,min=64,max=640"`                     // Optional width in pixels, default 320
+	Formats      []string `json:"formats" validate:"omitempty,max=2,unique,dive,oneof=mp4 webp"` // Optional formats, default mp4
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"reflect"
+	"regexp"
 	"strconv"
 
 	"github.com/go-playground/validator/v10"
@@ -41,12 +42,22 @@ func customNonNegativeInt(fl validator.FieldLevel) bool {
 	return false // If the field is not an integer, the validation fails
 }
 
+// uuidFilenameRegex matches the file names created for uploads, a UUIDv4 with an optional extension.
+var uuidFilenameRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}(\.[a-z0-9]+)?$`)
+
+// customUuidFilename is a custom validation function for the uuidFilename of uploaded files.
+// Uploads are stored as "<uuid>.<extension>" (e.g. "<uuid>.png"), which the uuid4 validator rejects.
+// Only a UUIDv4 and an alphanumeric extension are accepted, so the name can not escape the upload directory.
+func customUuidFilename(fl validator.FieldLevel) bool {
+	return uuidFilenameRegex.MatchString(fl.Field().String())
+}
+
 // Declare the validator variable.
 // This global variable is used to perform validation on structs.
 var validate *validator.Validate
 
 // InitializeValidator initializes the validator and registers custom validation functions.
-// It registers the 'customVideoQuality' and 'customNonNegativeInt' validators
+// It registers the 'customVideoQuality', 'customNonNegativeInt' and 'customUuidFilename' validators
 // to allow custom validation logic for specific fields in your structs.
 func InitializeValidator() {
 	validate = validator.New(validator.WithRequiredStructEnabled()) // Enable validation of required struct fields
@@ -54,6 +65,7 @@ func InitializeValidator() {
 	// Register custom validators
 	validate.RegisterValidation("customVideoQuality", customVideoQuality)     // Register video quality validator
 	validate.RegisterValidation("customNonNegativeInt", customNonNegativeInt) // Register non-negative integer validator
+	validate.RegisterValidation("customUuidFilename", customUuidFilename)     // Register upload file name validator
 }
 
 // ValidateRequestBody validates the request body against the provided struct.